@@ -19,7 +19,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"flag"
@@ -124,7 +123,7 @@ func checkMetadata(ri *apb.CompilationUnit_FileInput, f indexer.Fetcher) (*index
 	if err != nil {
 		return nil, fmt.Errorf("reading metadata file: %w", err)
 	}
-	rules, err := metadata.Parse(bytes.NewReader(bits))
+	rules, err := metadata.ParseBytes(bits)
 	if err != nil {
 		// Check if file is actually a GeneratedCodeInfo proto.
 		var gci protopb.GeneratedCodeInfo
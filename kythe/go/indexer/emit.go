@@ -134,6 +134,7 @@ func (pi *PackageInfo) Emit(ctx context.Context, sink Sink, opts *EmitOptions) e
 		// All Go source files are encoded as UTF-8, which is the default.
 
 		e.writeEdge(vname, pi.VName, edges.ChildOf)
+		e.applyFileRules(file, vname)
 	}
 
 	// Traverse the AST of each file in the package for xref entries.
@@ -938,9 +939,9 @@ func (e *emitter) writeRef(origin ast.Node, target *spb.VName, kind string) *spb
 	// whether there are any to process.
 	e.applyRules(file, start, end, kind, func(rule metadata.Rule) {
 		if rule.Reverse {
-			e.writeEdge(rule.VName, target, rule.EdgeOut)
+			e.writeEdge(rule.VName, target, rule.EdgeKind())
 		} else {
-			e.writeEdge(target, rule.VName, rule.EdgeOut)
+			e.writeEdge(target, rule.VName, rule.EdgeKind())
 		}
 		if rule.EdgeOut == edges.Generates && !e.fmeta[file] {
 			e.fmeta[file] = true
@@ -1135,6 +1136,9 @@ func (e *emitter) applyRules(file *ast.File, start, end int, kind string, apply
 	if !ok {
 		rules = make(map[int]metadata.Rules)
 		for _, rule := range e.pi.Rules[file] {
+			if rule.WholeFile {
+				continue // see applyFileRules
+			}
 			rules[rule.Begin] = append(rules[rule.Begin], rule)
 		}
 		e.rmap[file] = rules
@@ -1147,6 +1151,23 @@ func (e *emitter) applyRules(file *ast.File, start, end int, kind string, apply
 	}
 }
 
+// applyFileRules emits the file-level edges of the whole-file metadata rules
+// for file, whose vname is given. Such rules have no span, and so are not
+// matched against anchors by applyRules.
+func (e *emitter) applyFileRules(file *ast.File, vname *spb.VName) {
+	if e.opts == nil || !e.opts.EmitLinkages {
+		return // nothing to do
+	}
+	for _, rule := range e.pi.Rules[file] {
+		if !rule.WholeFile {
+			continue
+		}
+		for _, entry := range rule.Apply(nil, vname) {
+			e.check(e.sink(e.ctx, entry))
+		}
+	}
+}
+
 // A visitFunc visits a node of the Go AST. The function can use stack to
 // retrieve AST nodes on the path from the node up to the root.  If the return
 // value is true, the children of node are also visited; otherwise they are
@@ -30,6 +30,7 @@ import (
 	"kythe.io/kythe/go/test/testutil"
 	"kythe.io/kythe/go/util/metadata"
 	"kythe.io/kythe/go/util/ptypes"
+	"kythe.io/kythe/go/util/schema/edges"
 
 	"github.com/golang/protobuf/proto"
 
@@ -385,5 +386,55 @@ func TestRules(t *testing.T) {
 	}
 }
 
+func TestWholeFileRules(t *testing.T) {
+	const input = "package main\n"
+	unit, digest := oneFileCompilation("main.go", "main", input)
+	unit.RequiredInput = append(unit.RequiredInput, &apb.CompilationUnit_FileInput{
+		VName: &spb.VName{Corpus: "test", Path: "main.proto"},
+		Info:  &apb.FileInfo{Path: "meta"},
+	})
+	pi, err := Resolve(unit, memFetcher{digest: input}, &ResolveOptions{
+		Info: XRefTypeInfo(),
+		CheckRules: func(ri *apb.CompilationUnit_FileInput, _ Fetcher) (*Ruleset, error) {
+			if ri.Info.Path != "meta" {
+				return nil, nil
+			}
+			return &Ruleset{
+				Path: "main.go",
+				Rules: metadata.Rules{{
+					WholeFile: true,
+					EdgeIn:    edges.DefinesBinding,
+					EdgeOut:   edges.Generates,
+					Reverse:   true,
+					VName:     ri.VName,
+				}},
+			}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	// The rule is applied to the file as a whole, and not to any anchor.
+	var got []*spb.Entry
+	if err := pi.Emit(context.Background(), func(_ context.Context, e *spb.Entry) error {
+		if isEdge(e) && e.EdgeKind == edges.Generates {
+			got = append(got, e)
+		}
+		return nil
+	}, &EmitOptions{EmitLinkages: true}); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	want := []*spb.Entry{{
+		Source:   &spb.VName{Corpus: "test", Path: "main.proto"},
+		Target:   pi.FileVName(pi.Files[0]),
+		EdgeKind: edges.Generates,
+		FactName: "/",
+	}}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("Wrong generates edges: %v", err)
+	}
+}
+
 // isEdge reports whether e represents an edge.
 func isEdge(e *spb.Entry) bool { return e.Target != nil && e.EdgeKind != "" }
@@ -0,0 +1,271 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"kythe.io/kythe/go/util/schema/edges"
+	"kythe.io/kythe/go/util/schema/facts"
+	"kythe.io/kythe/go/util/schema/nodes"
+
+	"github.com/golang/protobuf/proto"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+// Apply returns the entries that result from applying r to the generated
+// anchor whose vname is given, in the generated file denoted by file.
+//
+// The result contains the edge between the anchor and the vname of r, drawn
+// from the anchor unless the edge is reversed, as reported by EffectiveEdge,
+// along with the facts that establish the anchor itself. For an anchor_anchor
+// rule, the target anchor and its facts are synthesized from r.VName and
+// r.TargetSpan. For a generates rule whose vname has a path, the result also
+// includes a generates edge between the source file and file, if file != nil.
+//
+// A whole-file rule does not concern any anchor: the result is the single edge
+// between file and the vname of r, if file != nil, and anchor is ignored. For
+// the usual reverse generates rule, this is a generates edge from the source
+// to the generated file.
+//
+// Apply returns nil for nop rules and rules without a vname.
+func (r Rule) Apply(anchor, file *spb.VName) []*spb.Entry {
+	return r.ApplyWithOptions(anchor, file, nil)
+}
+
+// ApplyWithOptions behaves as Apply, using the settings from opts.
+func (r Rule) ApplyWithOptions(anchor, file *spb.VName, opts *ApplyOptions) []*spb.Entry {
+	if r.EdgeIn == "" || r.VName == nil {
+		return nil
+	} else if r.WholeFile {
+		if file == nil {
+			return nil
+		}
+		kind, reversed := r.EffectiveEdge()
+		return []*spb.Entry{edgeEntry(file, r.VName, kind, reversed)}
+	}
+	entries := anchorEntries(anchor, r.Begin, r.End)
+	if opts.childOf() && file != nil {
+		entries = append(entries, edgeEntry(anchor, file, edges.ChildOf, false))
+	}
+
+	target := r.VName
+	if t := r.TargetSpan; t != nil {
+		target = anchorVName(r.VName, t.Begin, t.End)
+		entries = append(entries, anchorEntries(target, t.Begin, t.End)...)
+	}
+	kind, reversed := r.EffectiveEdge()
+	entries = append(entries, edgeEntry(anchor, target, kind, reversed))
+
+	if base, _ := r.effectiveBase(); base == edges.Generates && r.VName.Path != "" && file != nil {
+		src := &spb.VName{
+			Corpus: r.VName.Corpus,
+			Root:   r.VName.Root,
+			Path:   r.VName.Path,
+		}
+		entries = append(entries, edgeEntry(file, src, edges.Generates, reversed))
+	}
+	return entries
+}
+
+// An AnchorSpan gives the span and vname of a generated anchor.
+type AnchorSpan struct {
+	Begin, End int
+	VName      *spb.VName
+}
+
+// ApplyOptions control the behaviour of ApplyWithOptions, ApplyAllWithOptions,
+// and ApplyToWithOptions. A nil *ApplyOptions provides default values.
+type ApplyOptions struct {
+	// If true, do not remove duplicate entries from the result.
+	KeepDuplicates bool
+
+	// If true, emit a childof edge from each generated anchor to the file
+	// containing it, when the file is known.
+	EmitChildOf bool
+}
+
+func (o *ApplyOptions) dedup() bool   { return o == nil || !o.KeepDuplicates }
+func (o *ApplyOptions) childOf() bool { return o != nil && o.EmitChildOf }
+
+// ApplyAll applies rs to each of the given anchors in the generated file
+// denoted by file, and returns the combined entries with duplicates removed,
+// in order of first appearance. As in the C++ implementation, a rule applies
+// to an anchor only if their spans are exactly equal. Whole-file rules are
+// applied once, before any anchors, regardless of the anchors given.
+func (rs Rules) ApplyAll(anchors []AnchorSpan, file *spb.VName) []*spb.Entry {
+	return rs.ApplyAllWithOptions(anchors, file, nil)
+}
+
+// ApplyAllWithOptions behaves as ApplyAll, using the settings from opts.
+func (rs Rules) ApplyAllWithOptions(anchors []AnchorSpan, file *spb.VName, opts *ApplyOptions) []*spb.Entry {
+	whole, idx := rs.applyIndex()
+	var entries []*spb.Entry
+	for _, r := range whole {
+		entries = append(entries, r.Apply(nil, file)...)
+	}
+	for _, a := range anchors {
+		for _, r := range idx.Exact(a.Begin, a.End) {
+			entries = append(entries, r.ApplyWithOptions(a.VName, file, opts)...)
+		}
+	}
+	if opts.dedup() {
+		return DedupEntries(entries)
+	}
+	return entries
+}
+
+// ApplyTo behaves as ApplyAllWithOptions with KeepDuplicates set, but sends
+// each entry to out as it is produced rather than accumulating them. ApplyTo
+// does not close out; it returns nil once the last entry has been sent. If ctx
+// ends before then, ApplyTo stops sending and returns the error of ctx. The
+// caller must not close out while ApplyTo is running.
+func (rs Rules) ApplyTo(ctx context.Context, anchors []AnchorSpan, file *spb.VName, out chan<- *spb.Entry) error {
+	return rs.ApplyToWithOptions(ctx, anchors, file, &ApplyOptions{KeepDuplicates: true}, out)
+}
+
+// ApplyToWithOptions behaves as ApplyTo, using the settings from opts. Unless
+// KeepDuplicates is set, an entry identical to one already sent is skipped.
+func (rs Rules) ApplyToWithOptions(ctx context.Context, anchors []AnchorSpan, file *spb.VName, opts *ApplyOptions, out chan<- *spb.Entry) error {
+	var seen map[string]bool
+	if opts.dedup() {
+		seen = make(map[string]bool)
+	}
+	send := func(entries []*spb.Entry) error {
+		for _, e := range entries {
+			if seen != nil {
+				key := entryKey(e)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			if err := sendEntry(ctx, out, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	whole, idx := rs.applyIndex()
+	for _, r := range whole {
+		if err := send(r.Apply(nil, file)); err != nil {
+			return err
+		}
+	}
+	for _, a := range anchors {
+		for _, r := range idx.Exact(a.Begin, a.End) {
+			if err := send(r.ApplyWithOptions(a.VName, file, opts)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyIndex returns the whole-file rules of rs, in order, and an index of the
+// remaining rules for matching against anchors.
+func (rs Rules) applyIndex() (Rules, *RuleIndex) {
+	var whole, spans Rules
+	for _, r := range rs {
+		if r.WholeFile {
+			whole = append(whole, r)
+		} else {
+			spans = append(spans, r)
+		}
+	}
+	return whole, BuildIndex(spans)
+}
+
+// sendEntry sends e to out, or returns the error of ctx if it ends first.
+func sendEntry(ctx context.Context, out chan<- *spb.Entry, e *spb.Entry) error {
+	select {
+	case out <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DedupEntries returns the entries in order of first appearance, omitting
+// any that are identical to an earlier entry. Entries are compared by their
+// source and target vnames, edge kind, fact name, and fact value. The input
+// slice is not modified.
+func DedupEntries(entries []*spb.Entry) []*spb.Entry {
+	seen := make(map[string]bool)
+	var out []*spb.Entry
+	for _, e := range entries {
+		if key := entryKey(e); !seen[key] {
+			seen[key] = true
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// entryKey returns a string that uniquely identifies the contents of e.
+func entryKey(e *spb.Entry) string {
+	var sb strings.Builder
+	for _, v := range []*spb.VName{e.Source, e.Target} {
+		for _, f := range []string{v.GetCorpus(), v.GetRoot(), v.GetPath(), v.GetLanguage(), v.GetSignature()} {
+			sb.WriteString(strconv.Quote(f))
+		}
+		sb.WriteByte(0)
+	}
+	sb.WriteString(strconv.Quote(e.EdgeKind))
+	sb.WriteString(strconv.Quote(e.FactName))
+	sb.Write(e.FactValue)
+	return sb.String()
+}
+
+// edgeEntry returns an edge entry of the given kind from src to tgt, or from
+// tgt to src if reverse is true.
+func edgeEntry(src, tgt *spb.VName, kind string, reverse bool) *spb.Entry {
+	if reverse {
+		src, tgt = tgt, src
+	}
+	return &spb.Entry{
+		Source:   src,
+		Target:   tgt,
+		EdgeKind: kind,
+		FactName: "/",
+	}
+}
+
+// anchorEntries returns the fact entries for an anchor with the given vname
+// and span.
+func anchorEntries(anchor *spb.VName, begin, end int) []*spb.Entry {
+	fact := func(name, value string) *spb.Entry {
+		return &spb.Entry{Source: anchor, FactName: name, FactValue: []byte(value)}
+	}
+	return []*spb.Entry{
+		fact(facts.NodeKind, nodes.Anchor),
+		fact(facts.AnchorStart, strconv.Itoa(begin)),
+		fact(facts.AnchorEnd, strconv.Itoa(end)),
+	}
+}
+
+// anchorVName returns the vname of an anchor spanning the given offsets in the
+// file denoted by file. The signature follows the convention of the Go
+// indexer.
+func anchorVName(file *spb.VName, begin, end int) *spb.VName {
+	vname := proto.Clone(file).(*spb.VName)
+	vname.Signature = "#" + strconv.Itoa(begin) + ":" + strconv.Itoa(end)
+	return vname
+}
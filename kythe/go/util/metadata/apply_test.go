@@ -0,0 +1,377 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+	"kythe.io/kythe/go/util/schema/edges"
+	"kythe.io/kythe/go/util/schema/facts"
+	"kythe.io/kythe/go/util/schema/nodes"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+// anchorFacts returns the fact entries expected for an anchor.
+func anchorFacts(anchor *spb.VName, begin, end string) []*spb.Entry {
+	return []*spb.Entry{
+		{Source: anchor, FactName: facts.NodeKind, FactValue: []byte(nodes.Anchor)},
+		{Source: anchor, FactName: facts.AnchorStart, FactValue: []byte(begin)},
+		{Source: anchor, FactName: facts.AnchorEnd, FactValue: []byte(end)},
+	}
+}
+
+func TestApply(t *testing.T) {
+	file := &spb.VName{Corpus: "c", Path: "gen/foo.go"}
+	anchor := &spb.VName{Corpus: "c", Path: "gen/foo.go", Signature: "#179:182", Language: "go"}
+	source := &spb.VName{Corpus: "c", Path: "foo.proto", Signature: "1.2", Language: "protobuf"}
+
+	tests := []struct {
+		rule Rule
+		want []*spb.Entry
+	}{
+		// Nop rules, and rules without a target, produce nothing.
+		{Rule{Begin: 179, End: 182}, nil},
+		{Rule{Begin: 179, End: 182, EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates}, nil},
+
+		// A reverse generates edge, with the file-level edge.
+		{Rule{
+			Begin:   179,
+			End:     182,
+			EdgeIn:  edges.DefinesBinding,
+			EdgeOut: edges.Generates,
+			Reverse: true,
+			VName:   source,
+		}, append(anchorFacts(anchor, "179", "182"),
+			&spb.Entry{Source: source, Target: anchor, EdgeKind: edges.Generates, FactName: "/"},
+			&spb.Entry{
+				Source:   &spb.VName{Corpus: "c", Path: "foo.proto"},
+				Target:   file,
+				EdgeKind: edges.Generates,
+				FactName: "/",
+			},
+		)},
+
+		// A forward edge of some other kind.
+		{Rule{
+			Begin:   179,
+			End:     182,
+			EdgeIn:  edges.Ref,
+			EdgeOut: edges.Ref,
+			VName:   source,
+		}, append(anchorFacts(anchor, "179", "182"),
+			&spb.Entry{Source: anchor, Target: source, EdgeKind: edges.Ref, FactName: "/"},
+		)},
+	}
+	for _, test := range tests {
+		got := test.rule.Apply(anchor, file)
+		if err := testutil.DeepEqual(test.want, got); err != nil {
+			t.Errorf("Apply %v: %v", test.rule, err)
+		}
+	}
+}
+
+func TestApplyChildOf(t *testing.T) {
+	file := &spb.VName{Corpus: "c", Path: "gen/foo.go"}
+	anchor := &spb.VName{Corpus: "c", Path: "gen/foo.go", Signature: "#179:182", Language: "go"}
+	target := &spb.VName{Corpus: "c", Signature: "x"}
+	r := Rule{Begin: 179, End: 182, EdgeIn: edges.Ref, EdgeOut: edges.Ref, VName: target}
+
+	// The childof edge is emitted only on request.
+	plain := append(anchorFacts(anchor, "179", "182"),
+		&spb.Entry{Source: anchor, Target: target, EdgeKind: edges.Ref, FactName: "/"},
+	)
+	if err := testutil.DeepEqual(plain, r.ApplyWithOptions(anchor, file, nil)); err != nil {
+		t.Errorf("ApplyWithOptions(nil): %v", err)
+	}
+	want := append(anchorFacts(anchor, "179", "182"),
+		&spb.Entry{Source: anchor, Target: file, EdgeKind: edges.ChildOf, FactName: "/"},
+		&spb.Entry{Source: anchor, Target: target, EdgeKind: edges.Ref, FactName: "/"},
+	)
+	opts := &ApplyOptions{EmitChildOf: true}
+	if err := testutil.DeepEqual(want, r.ApplyWithOptions(anchor, file, opts)); err != nil {
+		t.Errorf("ApplyWithOptions(EmitChildOf): %v", err)
+	}
+	if err := testutil.DeepEqual(plain, r.ApplyWithOptions(anchor, nil, opts)); err != nil {
+		t.Errorf("ApplyWithOptions(EmitChildOf) without a file: %v", err)
+	}
+
+	anchors := []AnchorSpan{{Begin: 179, End: 182, VName: anchor}}
+	if err := testutil.DeepEqual(want, Rules{r}.ApplyAllWithOptions(anchors, file, opts)); err != nil {
+		t.Errorf("ApplyAllWithOptions(EmitChildOf): %v", err)
+	}
+}
+
+func TestApplyOrdinal(t *testing.T) {
+	anchor := &spb.VName{Corpus: "c", Path: "gen/foo.go", Signature: "#5:10"}
+	target := &spb.VName{Corpus: "c", Path: "foo.proto", Signature: "1.2"}
+	tests := []struct {
+		ordinal *int
+		want    string
+	}{
+		{nil, edges.Param},
+		{intPtr(0), "/kythe/edge/param.0"},
+		{intPtr(2), "/kythe/edge/param.2"},
+	}
+	for _, test := range tests {
+		rs := Rules{{Begin: 5, End: 10, EdgeIn: edges.Ref, EdgeOut: edges.Param, Ordinal: test.ordinal, VName: target}}
+		var kinds []string
+		for _, e := range rs.ApplyAll([]AnchorSpan{{Begin: 5, End: 10, VName: anchor}}, nil) {
+			if e.EdgeKind != "" {
+				kinds = append(kinds, e.EdgeKind)
+			}
+		}
+		if err := testutil.DeepEqual([]string{test.want}, kinds); err != nil {
+			t.Errorf("ApplyAll %v: wrong edge kinds: %v", rs[0], err)
+		}
+	}
+}
+
+func TestApplyWholeFile(t *testing.T) {
+	file := &spb.VName{Corpus: "c", Path: "gen/foo.go"}
+	source := &spb.VName{Corpus: "c", Path: "foo.proto"}
+	rs := Rules{{
+		EdgeIn:    edges.DefinesBinding,
+		EdgeOut:   edges.Generates,
+		Reverse:   true,
+		VName:     source,
+		WholeFile: true,
+	}}
+	want := []*spb.Entry{{Source: source, Target: file, EdgeKind: edges.Generates, FactName: "/"}}
+	if err := testutil.DeepEqual(want, rs[0].Apply(nil, file)); err != nil {
+		t.Errorf("Apply: %v", err)
+	}
+	if got := rs[0].Apply(nil, nil); got != nil {
+		t.Errorf("Apply without a file: got %v, want nil", got)
+	}
+
+	// The rule applies once, and does not match an anchor with an empty span
+	// at the start of the file.
+	anchor := &spb.VName{Corpus: "c", Path: "gen/foo.go", Signature: "#0:0"}
+	anchors := []AnchorSpan{{Begin: 0, End: 0, VName: anchor}, {Begin: 5, End: 9, VName: anchor}}
+	if err := testutil.DeepEqual(want, rs.ApplyAllWithOptions(anchors, file, &ApplyOptions{KeepDuplicates: true})); err != nil {
+		t.Errorf("ApplyAll: %v", err)
+	}
+}
+
+func TestApplyAnchorAnchor(t *testing.T) {
+	// As in the C++ MappingRule format, the source range imputes the
+	// generated text.
+	rs, err := Parse(strings.NewReader(`{"type":"kythe0","meta":[{"type":"anchor_anchor",
+"target_begin":10,"target_end":15,"source_begin":100,"source_end":105,
+"edge":"/kythe/edge/imputes","source_vname":{"path":"src.ts","language":"typescript"}}]}`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	rule := rs[0]
+	anchor := &spb.VName{Path: "gen.js", Signature: "#10:15"}
+	source := &spb.VName{Path: "src.ts", Language: "typescript", Signature: "#100:105"}
+	want := anchorFacts(anchor, "10", "15")
+	want = append(want, anchorFacts(source, "100", "105")...)
+	want = append(want, &spb.Entry{
+		Source:   source,
+		Target:   anchor,
+		EdgeKind: AnchorAnchorEdge,
+		FactName: "/",
+	})
+	got := rule.Apply(anchor, nil)
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("Apply %v: %v", rule, err)
+	}
+}
+
+func TestApplyAll(t *testing.T) {
+	file := &spb.VName{Path: "gen.go"}
+	source := &spb.VName{Path: "src.proto", Signature: "x"}
+	rs := Rules{{
+		Begin:   10,
+		End:     20,
+		EdgeIn:  edges.DefinesBinding,
+		EdgeOut: edges.Generates,
+		Reverse: true,
+		VName:   source,
+	}, {
+		// Equal to the first rule, so its entries are all duplicates.
+		Begin:   10,
+		End:     20,
+		EdgeIn:  edges.DefinesBinding,
+		EdgeOut: edges.Generates,
+		Reverse: true,
+		VName:   source,
+	}, {
+		// Not matched, since the match must be exact.
+		Begin:   0,
+		End:     100,
+		EdgeIn:  edges.DefinesBinding,
+		EdgeOut: edges.Generates,
+		VName:   source,
+	}}
+	a1 := &spb.VName{Path: "gen.go", Signature: "#10:20"}
+	a2 := &spb.VName{Path: "gen.go", Signature: "other"}
+	anchors := []AnchorSpan{
+		{Begin: 10, End: 20, VName: a1},
+		{Begin: 50, End: 60, VName: a2},
+		{Begin: 10, End: 20, VName: a2},
+	}
+	srcFile := &spb.VName{Path: "src.proto"}
+	var want []*spb.Entry
+	want = append(want, anchorFacts(a1, "10", "20")...)
+	want = append(want,
+		&spb.Entry{Source: source, Target: a1, EdgeKind: edges.Generates, FactName: "/"},
+		&spb.Entry{Source: srcFile, Target: file, EdgeKind: edges.Generates, FactName: "/"})
+	want = append(want, anchorFacts(a2, "10", "20")...)
+	want = append(want,
+		&spb.Entry{Source: source, Target: a2, EdgeKind: edges.Generates, FactName: "/"})
+
+	got := rs.ApplyAll(anchors, file)
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("ApplyAll: %v", err)
+	}
+}
+
+func TestApplyAllDuplicates(t *testing.T) {
+	source := &spb.VName{Signature: "x"}
+	rule := Rule{Begin: 1, End: 2, EdgeIn: edges.Ref, EdgeOut: edges.Ref, VName: source}
+	rs := Rules{rule, rule}
+	anchors := []AnchorSpan{{Begin: 1, End: 2, VName: &spb.VName{Signature: "a"}}}
+
+	if got := rs.ApplyAll(anchors, nil); len(got) != 4 {
+		t.Errorf("ApplyAll: got %d entries, want 4: %v", len(got), got)
+	}
+	opts := &ApplyOptions{KeepDuplicates: true}
+	if got := rs.ApplyAllWithOptions(anchors, nil, opts); len(got) != 8 {
+		t.Errorf("ApplyAllWithOptions: got %d entries, want 8: %v", len(got), got)
+	}
+}
+
+func TestApplyTo(t *testing.T) {
+	file := &spb.VName{Path: "gen.go"}
+	rs := Rules{
+		{Begin: 1, End: 2, EdgeIn: edges.Ref, EdgeOut: edges.Ref, VName: &spb.VName{Signature: "x"}},
+		{Begin: 3, End: 4, EdgeIn: edges.Ref, EdgeOut: edges.Ref, VName: &spb.VName{Signature: "y"}},
+	}
+	anchors := []AnchorSpan{
+		{Begin: 1, End: 2, VName: &spb.VName{Signature: "a"}},
+		{Begin: 3, End: 4, VName: &spb.VName{Signature: "b"}},
+	}
+	want := rs.ApplyAll(anchors, file)
+
+	out := make(chan *spb.Entry)
+	errc := make(chan error, 1)
+	go func() { errc <- rs.ApplyTo(context.Background(), anchors, file, out); close(out) }()
+	var got []*spb.Entry
+	for e := range out {
+		got = append(got, e)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("ApplyTo failed: %v", err)
+	}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("ApplyTo: %v", err)
+	}
+
+	// The options are honoured as by ApplyAllWithOptions, here adding childof
+	// edges and dropping the entries of the duplicate rule.
+	dups := append(Rules{rs[0]}, rs...)
+	opts := &ApplyOptions{EmitChildOf: true}
+	want = dups.ApplyAllWithOptions(anchors, file, opts)
+	out = make(chan *spb.Entry)
+	go func() {
+		errc <- dups.ApplyToWithOptions(context.Background(), anchors, file, opts, out)
+		close(out)
+	}()
+	got = nil
+	for e := range out {
+		got = append(got, e)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("ApplyToWithOptions failed: %v", err)
+	}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("ApplyToWithOptions: %v", err)
+	}
+
+	// A consumer that stops reading can cancel the context to unblock the
+	// sender, which reports the error of the context.
+	ctx, cancel := context.WithCancel(context.Background())
+	stalled := make(chan *spb.Entry)
+	go func() { <-stalled; cancel() }()
+	if err := rs.ApplyTo(ctx, anchors, file, stalled); err != context.Canceled {
+		t.Errorf("ApplyTo cancelled: got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestDedupEntries(t *testing.T) {
+	a := &spb.VName{Signature: "a"}
+	b := &spb.VName{Signature: "b"}
+	edge := func(src, tgt *spb.VName, kind string) *spb.Entry {
+		return &spb.Entry{Source: src, Target: tgt, EdgeKind: kind, FactName: "/"}
+	}
+	fact := func(src *spb.VName, name, value string) *spb.Entry {
+		return &spb.Entry{Source: src, FactName: name, FactValue: []byte(value)}
+	}
+	input := []*spb.Entry{
+		edge(a, b, edges.Ref),
+		fact(a, facts.NodeKind, nodes.Anchor),
+		edge(b, a, edges.Ref),
+		edge(&spb.VName{Signature: "a"}, &spb.VName{Signature: "b"}, edges.Ref), // dup of 0
+		edge(a, b, edges.Generates),
+		fact(a, facts.NodeKind, nodes.Anchor), // dup of 1
+		fact(a, facts.NodeKind, "file"),
+	}
+	want := []*spb.Entry{input[0], input[1], input[2], input[4], input[6]}
+	got := DedupEntries(input)
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("DedupEntries: %v", err)
+	}
+	if len(input) != 7 {
+		t.Errorf("DedupEntries modified its input: %v", input)
+	}
+}
+
+func BenchmarkApplyAll(b *testing.B) {
+	const numAnchors = 5000
+	file := &spb.VName{Path: "gen.go"}
+	var rs Rules
+	var anchors []AnchorSpan
+	for i := 0; i < numAnchors; i++ {
+		begin, end := i*10, i*10+5
+		anchors = append(anchors, AnchorSpan{
+			Begin: begin,
+			End:   end,
+			VName: &spb.VName{Path: "gen.go", Signature: fmt.Sprintf("#%d:%d", begin, end)},
+		})
+		if i%2 == 0 {
+			rs = append(rs, Rule{
+				Begin:   begin,
+				End:     end,
+				EdgeIn:  edges.DefinesBinding,
+				EdgeOut: edges.Generates,
+				Reverse: true,
+				VName:   &spb.VName{Path: "src.proto", Signature: fmt.Sprint(i)},
+			})
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rs.ApplyAll(anchors, file)
+	}
+}
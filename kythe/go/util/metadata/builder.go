@@ -0,0 +1,92 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"kythe.io/kythe/go/util/schema/edges"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+// A RuleBuilder constructs a Rule, for example:
+//
+//	r, err := new(metadata.RuleBuilder).
+//	    Span(179, 182).
+//	    DefinesBinding().
+//	    Generates().
+//	    Target(vname).
+//	    Build()
+//
+// The zero value is ready for use, and builds a nop rule.
+type RuleBuilder struct{ rule Rule }
+
+// Span sets the span of the rule.
+func (b *RuleBuilder) Span(begin, end int) *RuleBuilder {
+	b.rule.Begin, b.rule.End = begin, end
+	return b
+}
+
+// DefinesBinding makes the rule match defines/binding edges, as an
+// anchor_defines rule.
+func (b *RuleBuilder) DefinesBinding() *RuleBuilder {
+	b.rule.EdgeIn = edges.DefinesBinding
+	return b
+}
+
+// Ref makes the rule match ref edges, as a ref rule.
+func (b *RuleBuilder) Ref() *RuleBuilder {
+	b.rule.EdgeIn = edges.Ref
+	return b
+}
+
+// Generates makes the rule emit a generates edge from the target to the
+// matched anchor.
+func (b *RuleBuilder) Generates() *RuleBuilder { return b.Edge(edges.Mirror(edges.Generates)) }
+
+// Edge makes the rule emit an edge of the given kind from the matched anchor
+// to the target. If kind is a reverse edge kind, the edge is emitted from the
+// target to the anchor instead.
+func (b *RuleBuilder) Edge(kind string) *RuleBuilder {
+	b.rule.EdgeOut = edges.Canonical(kind)
+	b.rule.Reverse = edges.IsReverse(kind)
+	return b
+}
+
+// Target sets the vname of the node to which the rule links the anchor.
+func (b *RuleBuilder) Target(v *spb.VName) *RuleBuilder {
+	b.rule.VName = v
+	return b
+}
+
+// Build returns the rule constructed by b, after checking it as Validate. It
+// is also an error to give an outbound edge for a rule that matches no edge.
+// The resulting error, if any, has concrete type ValidationError.
+func (b *RuleBuilder) Build() (Rule, error) {
+	r := b.rule
+	ps := r.problems()
+	if r.EdgeIn == "" && r.EdgeOut != "" {
+		ps = append(ps, "outbound edge kind without a matched edge kind")
+	}
+	if ps != nil {
+		bad := make(ValidationError, len(ps))
+		for i, p := range ps {
+			bad[i] = InvalidRule{Reason: p}
+		}
+		return Rule{}, bad
+	}
+	return r, nil
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+	"kythe.io/kythe/go/util/schema/edges"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+func TestRuleBuilder(t *testing.T) {
+	vname := &spb.VName{Corpus: "c", Signature: "s"}
+	got, err := new(RuleBuilder).Span(179, 182).DefinesBinding().Generates().Target(vname).Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	want := Rule{
+		Begin:   179,
+		End:     182,
+		EdgeIn:  edges.DefinesBinding,
+		EdgeOut: edges.Generates,
+		Reverse: true,
+		VName:   vname,
+	}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("Build: %v", err)
+	}
+
+	if got, err := new(RuleBuilder).Span(1, 2).Ref().Edge(edges.Param).Target(vname).Build(); err != nil {
+		t.Errorf("Build failed: %v", err)
+	} else if got.Reverse || got.EdgeOut != edges.Param || got.EdgeIn != edges.Ref {
+		t.Errorf("Build: got %v, want forward ref -> param rule", got)
+	}
+
+	if got, err := new(RuleBuilder).Build(); err != nil {
+		t.Errorf("Build of nop rule failed: %v", err)
+	} else if err := testutil.DeepEqual(Rule{}, got); err != nil {
+		t.Errorf("Build of nop rule: %v", err)
+	}
+
+	for _, bad := range []*RuleBuilder{
+		new(RuleBuilder).Span(5, 1).DefinesBinding().Generates().Target(vname),
+		new(RuleBuilder).DefinesBinding().Generates(),   // no target
+		new(RuleBuilder).DefinesBinding().Target(vname), // no outbound edge
+		new(RuleBuilder).Generates().Target(vname),      // no matched edge
+	} {
+		r, err := bad.Build()
+		if err == nil {
+			t.Errorf("Build: got %v, wanted error", r)
+		} else if _, ok := err.(ValidationError); !ok {
+			t.Errorf("Build: got error %T, want ValidationError", err)
+		} else {
+			t.Logf("Build: %v", err)
+		}
+	}
+}
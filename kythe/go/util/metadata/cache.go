@@ -0,0 +1,120 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// DefaultCacheEntries is the number of rule sets held by a Cache whose
+// MaxEntries is zero.
+const DefaultCacheEntries = 1024
+
+// A Cache holds parsed rule sets by key. It is safe for concurrent use by
+// multiple goroutines. The zero value is an empty cache ready for use.
+//
+// The cache holds at most MaxEntries rule sets; when it is full, Put evicts
+// the least recently used one.
+//
+// The cache stores and returns copies of the rules, as Rules.Clone, so that
+// callers may modify the rules they get without affecting the cache.
+type Cache struct {
+	// The maximum number of rule sets held. If zero, DefaultCacheEntries is
+	// used; if negative, the cache is unbounded. It must not be changed
+	// after the cache is first used.
+	MaxEntries int
+
+	mu    sync.Mutex
+	order *list.List               // of *cacheEntry, most recently used first
+	rules map[string]*list.Element // the element of order for each key
+}
+
+type cacheEntry struct {
+	key   string
+	rules Rules
+}
+
+// Get returns a copy of the rules stored for key, and reports whether there
+// were any.
+func (c *Cache) Get(key string) (Rules, bool) {
+	c.mu.Lock()
+	e, ok := c.rules[key]
+	if ok {
+		c.order.MoveToFront(e)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return e.Value.(*cacheEntry).rules.Clone(), true
+}
+
+// Put stores a copy of rs for key, replacing any rules already stored, and
+// evicting the least recently used rule set if the cache is full.
+func (c *Cache) Put(key string, rs Rules) {
+	rs = rs.Clone()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rules == nil {
+		c.rules = make(map[string]*list.Element)
+		c.order = list.New()
+	}
+	if e, ok := c.rules[key]; ok {
+		e.Value = &cacheEntry{key: key, rules: rs}
+		c.order.MoveToFront(e)
+		return
+	}
+	c.rules[key] = c.order.PushFront(&cacheEntry{key: key, rules: rs})
+	if max := c.maxEntries(); max > 0 && c.order.Len() > max {
+		oldest := c.order.Remove(c.order.Back()).(*cacheEntry)
+		delete(c.rules, oldest.key)
+	}
+}
+
+func (c *Cache) maxEntries() int {
+	if c.MaxEntries == 0 {
+		return DefaultCacheEntries
+	}
+	return c.MaxEntries
+}
+
+// Len returns the number of rule sets stored in c.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.rules)
+}
+
+// ParseCached parses content as ParseBytes, using cache to avoid parsing the
+// same content more than once. The cache is keyed by the hex-encoded SHA-256
+// digest of content. Errors are not cached.
+func ParseCached(cache *Cache, content []byte) (Rules, error) {
+	sum := sha256.Sum256(content)
+	key := hex.EncodeToString(sum[:])
+	if rs, ok := cache.Get(key); ok {
+		return rs, nil
+	}
+	rs, err := ParseBytes(content)
+	if err != nil {
+		return nil, err
+	}
+	cache.Put(key, rs)
+	return rs, nil
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+)
+
+func TestParseCached(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[{"type":"anchor_defines","begin":1,"end":2,
+"edge":"%/kythe/edge/generates","vname":{"corpus":"c","signature":"s"}}]}`
+	var cache Cache
+	first, err := ParseCached(&cache, []byte(input))
+	if err != nil {
+		t.Fatalf("ParseCached failed: %v", err)
+	}
+	if cache.Len() != 1 {
+		t.Errorf("Cache has %d entries after parsing, want 1", cache.Len())
+	}
+
+	// Mutating the result must not affect the cached copy.
+	first[0].VName.Corpus = "changed"
+	second, err := ParseCached(&cache, []byte(input))
+	if err != nil {
+		t.Fatalf("ParseCached failed: %v", err)
+	}
+	if c := second[0].VName.Corpus; c != "c" {
+		t.Errorf("Cached rules were modified: got corpus %q, want %q", c, "c")
+	}
+	if cache.Len() != 1 {
+		t.Errorf("Cache has %d entries after a repeated parse, want 1", cache.Len())
+	}
+
+	if rs, err := ParseCached(&cache, []byte(`{"type":"wrong"}`)); err == nil {
+		t.Errorf("ParseCached: got %v, wanted error", rs)
+	} else if cache.Len() != 1 {
+		t.Errorf("Cache has %d entries after an error, want 1", cache.Len())
+	}
+
+	if _, ok := cache.Get("nonesuch"); ok {
+		t.Error("Get of a missing key reported success")
+	}
+	cache.Put("k", Rules{{Begin: 5}})
+	if rs, ok := cache.Get("k"); !ok {
+		t.Error("Get after Put failed")
+	} else if err := testutil.DeepEqual(Rules{{Begin: 5}}, rs); err != nil {
+		t.Errorf("Get after Put: %v", err)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	cache := Cache{MaxEntries: 2}
+	cache.Put("a", Rules{{Begin: 1}})
+	cache.Put("b", Rules{{Begin: 2}})
+	cache.Get("a") // b is now the least recently used
+	cache.Put("c", Rules{{Begin: 3}})
+	if cache.Len() != 2 {
+		t.Errorf("Cache has %d entries, want 2", cache.Len())
+	}
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Least recently used entry was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("Entry %q was evicted", key)
+		}
+	}
+
+	// Replacing an entry does not evict another.
+	cache.Put("a", Rules{{Begin: 4}})
+	if rs, ok := cache.Get("a"); !ok || rs[0].Begin != 4 || cache.Len() != 2 {
+		t.Errorf("Get after replacing: got %v, %v with %d entries, want [4] of 2", rs, ok, cache.Len())
+	}
+
+	unbounded := Cache{MaxEntries: -1}
+	for i := 0; i < DefaultCacheEntries+1; i++ {
+		unbounded.Put(fmt.Sprint(i), nil)
+	}
+	if n := unbounded.Len(); n != DefaultCacheEntries+1 {
+		t.Errorf("Unbounded cache has %d entries, want %d", n, DefaultCacheEntries+1)
+	}
+}
+
+// benchInput returns the encoding of a metadata file with n rules.
+func benchInput(n int) []byte {
+	var sb strings.Builder
+	sb.WriteString(`{"type":"kythe0","meta":[`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `{"type":"anchor_defines","begin":%d,"end":%d,"edge":"%%/kythe/edge/generates",`+
+			`"vname":{"corpus":"c","path":"a.proto","signature":"4.%d"}}`, i*10, i*10+5, i)
+	}
+	sb.WriteString(`]}`)
+	return []byte(sb.String())
+}
+
+func BenchmarkParseCached(b *testing.B) {
+	input := benchInput(1000)
+	b.Run("Uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := ParseBytes(input); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Cached", func(b *testing.B) {
+		var cache Cache
+		for i := 0; i < b.N; i++ {
+			if _, err := ParseCached(&cache, input); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
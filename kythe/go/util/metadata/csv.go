@@ -0,0 +1,61 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// csvHeader gives the column names written by WriteCSV.
+var csvHeader = []string{
+	"Begin", "End", "EdgeIn", "EdgeOut", "Reverse",
+	"Corpus", "Root", "Path", "Language", "Signature",
+}
+
+// WriteCSV writes rs to w as CSV, with a header row followed by one row per
+// rule. The EdgeOut and Reverse columns give the edge drawn by the rule, as
+// EffectiveEdge: the kind is a forward kind, including the ordinal of the rule
+// if it has one, and Reverse is true for a kind written with a leading "%".
+// Fields of a missing vname are written as empty cells.
+func (rs Rules) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range rs {
+		v := r.VName
+		kind, reversed := r.EffectiveEdge()
+		if err := cw.Write([]string{
+			strconv.Itoa(r.Begin),
+			strconv.Itoa(r.End),
+			r.EdgeIn,
+			kind,
+			strconv.FormatBool(reversed),
+			v.GetCorpus(),
+			v.GetRoot(),
+			v.GetPath(),
+			v.GetLanguage(),
+			v.GetSignature(),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
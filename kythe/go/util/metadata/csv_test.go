@@ -0,0 +1,55 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/util/schema/edges"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+func TestWriteCSV(t *testing.T) {
+	rs := Rules{
+		{Begin: 0, End: 0},
+		{
+			Begin:   179,
+			End:     182,
+			EdgeIn:  edges.DefinesBinding,
+			EdgeOut: edges.Generates,
+			Reverse: true,
+			VName:   &spb.VName{Corpus: "gcorp", Path: "a,b.proto", Signature: "4.0"},
+		},
+		{Begin: 5, End: 10, EdgeIn: edges.Ref, EdgeOut: edges.Param, Ordinal: intPtr(1)},
+		{Begin: 12, End: 14, EdgeIn: edges.DefinesBinding, EdgeOut: edges.Mirror(edges.Generates)},
+	}
+	var buf strings.Builder
+	if err := rs.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	const want = `Begin,End,EdgeIn,EdgeOut,Reverse,Corpus,Root,Path,Language,Signature
+0,0,,,false,,,,,
+179,182,/kythe/edge/defines/binding,/kythe/edge/generates,true,gcorp,,"a,b.proto",,4.0
+5,10,/kythe/edge/ref,/kythe/edge/param.1,false,,,,,
+12,14,/kythe/edge/defines/binding,/kythe/edge/generates,true,,,,,
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteCSV:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,289 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"kythe.io/kythe/go/util/schema/edges"
+
+	"github.com/golang/protobuf/proto"
+
+	protopb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+// Field numbers of the descriptor messages, as used in the paths of the
+// locations in a SourceCodeInfo message.
+const (
+	fileMessageTypeField = 4 // FileDescriptorProto.message_type
+	fileEnumTypeField    = 5 // FileDescriptorProto.enum_type
+	messageFieldField    = 2 // DescriptorProto.field
+	descriptorNameField  = 1 // the name field of each descriptor
+
+	fileServiceField      = 6 // FileDescriptorProto.service
+	fileExtensionField    = 7 // FileDescriptorProto.extension
+	messageNestedField    = 3 // DescriptorProto.nested_type
+	messageEnumField      = 4 // DescriptorProto.enum_type
+	messageExtensionField = 6 // DescriptorProto.extension
+	messageOneofField     = 8 // DescriptorProto.oneof_decl
+	enumValueField        = 2 // EnumDescriptorProto.value
+	serviceMethodField    = 2 // ServiceDescriptorProto.method
+)
+
+// FromFileDescriptor constructs a set of rules for the .proto file described
+// by fd, relating each top-level message, each field of those messages, and
+// each top-level enum to the code generated for it. For each definition a
+// rule spans its name in the .proto file, as recorded by the SourceCodeInfo
+// of fd, and draws a generates edge from the node it defines to the vname
+// whose signature is the fully-qualified name of the definition, e.g.,
+// "pkg.Message.field", and whose other fields are taken from generated.
+// Definitions without a recorded location are skipped, and an error is
+// reported if fd has no SourceCodeInfo at all.
+//
+// The spans are given by the Position of each rule. Protobuf locations count
+// columns in bytes, except that tabs advance to the next multiple of 8, so the
+// Units of each Position are ColumnExpandedTabs; resolve them against the
+// .proto text with ResolveLineColumns.
+func FromFileDescriptor(fd *protopb.FileDescriptorProto, generated *spb.VName) (Rules, error) {
+	if fd == nil {
+		return nil, errors.New("metadata: nil file descriptor")
+	} else if fd.SourceCodeInfo == nil {
+		return nil, fmt.Errorf("metadata: file descriptor %q has no source code info", fd.GetName())
+	}
+	d := descriptorRules{
+		spans:     make(map[string][]int32),
+		generated: generated,
+	}
+	for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+		// Where a path has several locations, the first is the definition.
+		if key := descriptorPath(loc.Path); d.spans[key] == nil {
+			d.spans[key] = loc.Span
+		}
+	}
+
+	for i, msg := range fd.GetMessageType() {
+		name := qualifiedName(fd.GetPackage(), msg.GetName())
+		if err := d.add(name, fileMessageTypeField, int32(i)); err != nil {
+			return nil, err
+		}
+		for j, field := range msg.GetField() {
+			fname := qualifiedName(name, field.GetName())
+			if err := d.add(fname, fileMessageTypeField, int32(i), messageFieldField, int32(j)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i, enum := range fd.GetEnumType() {
+		name := qualifiedName(fd.GetPackage(), enum.GetName())
+		if err := d.add(name, fileEnumTypeField, int32(i)); err != nil {
+			return nil, err
+		}
+	}
+	return d.rules, nil
+}
+
+// descriptorRules accumulates the rules constructed by FromFileDescriptor.
+type descriptorRules struct {
+	spans     map[string][]int32 // location spans, keyed by descriptorPath
+	generated *spb.VName
+	rules     Rules
+}
+
+// add appends a rule for the definition with the given name, whose location
+// has the given path. The location of its name is preferred, if recorded.
+func (d *descriptorRules) add(name string, path ...int32) error {
+	span := d.spans[descriptorPath(append(path, descriptorNameField))]
+	if span == nil {
+		span = d.spans[descriptorPath(path)]
+	}
+	if span == nil {
+		return nil // no location recorded
+	}
+	pos, err := descriptorSpan(span)
+	if err != nil {
+		return fmt.Errorf("metadata: location of %s: %v", name, err)
+	}
+	d.rules = append(d.rules, Rule{
+		EdgeIn:  edges.DefinesBinding,
+		EdgeOut: edges.Generates,
+		VName: &spb.VName{
+			Corpus:    d.generated.GetCorpus(),
+			Root:      d.generated.GetRoot(),
+			Path:      d.generated.GetPath(),
+			Language:  d.generated.GetLanguage(),
+			Signature: name,
+		},
+		Position: pos,
+	})
+	return nil
+}
+
+// descriptorPath returns a string that is equal for two location paths
+// exactly when the paths are equal.
+func descriptorPath(path []int32) string { return fmt.Sprint(path) }
+
+// descriptorSpan converts a location span, which has the form [begin line,
+// begin column, end line, end column], with the end line omitted if it is the
+// same as the begin line, and lines numbered from 0, into a LineSpan.
+func descriptorSpan(span []int32) (*LineSpan, error) {
+	switch len(span) {
+	case 3:
+		span = []int32{span[0], span[1], span[0], span[2]}
+	case 4:
+	default:
+		return nil, fmt.Errorf("span has %d elements, want 3 or 4", len(span))
+	}
+	for _, v := range span {
+		if v < 0 {
+			return nil, fmt.Errorf("invalid span %v", span)
+		}
+	}
+	return &LineSpan{
+		BeginLine: int(span[0]) + 1,
+		BeginCol:  int(span[1]),
+		EndLine:   int(span[2]) + 1,
+		EndCol:    int(span[3]),
+		Units:     ColumnExpandedTabs,
+	}, nil
+}
+
+// qualifiedName returns the name of the descriptor with the given name,
+// nested within the given scope.
+func qualifiedName(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+// ResolveSignatures rewrites, in place, the signatures of the protobuf vnames
+// of rs that are dotted descriptor paths, as produced by FromGeneratedCodeInfo,
+// into the fully-qualified names of the definitions they denote within fd, as
+// FromFileDescriptor uses for its signatures. For example, in package "pkg",
+// the path "4.0.2.1" of the second field of the first message becomes
+// "pkg.MyMessage.my_field". Vnames whose language is not
+// "protobuf", whose signature is not a path, or whose path names a file other
+// than fd are not changed.
+//
+// Paths that do not denote a definition in fd are left as they are, and
+// reported together in the error returned.
+func ResolveSignatures(rs Rules, fd *protopb.FileDescriptorProto) error {
+	var unresolved []string
+	for i, r := range rs {
+		v := r.VName
+		if v.GetLanguage() != "protobuf" || (v.Path != "" && fd.GetName() != "" && v.Path != fd.GetName()) {
+			continue
+		}
+		path, ok := parseDescriptorPath(v.Signature)
+		if !ok {
+			continue
+		}
+		name, ok := descriptorName(fd, path)
+		if !ok {
+			unresolved = append(unresolved, v.Signature)
+			continue
+		}
+		v = proto.Clone(v).(*spb.VName)
+		v.Signature = name
+		rs[i].VName = v
+	}
+	if len(unresolved) != 0 {
+		return fmt.Errorf("metadata: unresolved descriptor paths: %s", strings.Join(unresolved, ", "))
+	}
+	return nil
+}
+
+// parseDescriptorPath parses a dotted descriptor path, e.g., "4.0.2.1", and
+// reports whether sig has that form.
+func parseDescriptorPath(sig string) ([]int32, bool) {
+	if sig == "" {
+		return nil, false
+	}
+	var path []int32
+	for _, elt := range strings.Split(sig, ".") {
+		v, err := strconv.ParseInt(elt, 10, 32)
+		if err != nil || v < 0 {
+			return nil, false
+		}
+		path = append(path, int32(v))
+	}
+	return path, true
+}
+
+// descriptorName returns the fully-qualified name of the definition denoted by
+// the location path within fd, and reports whether path denotes a definition.
+func descriptorName(fd *protopb.FileDescriptorProto, path []int32) (string, bool) {
+	if len(path) == 0 || len(path)%2 != 0 {
+		return "", false
+	}
+	name := fd.GetPackage()
+	var node interface{} = fd
+	for i := 0; i < len(path); i += 2 {
+		var child string
+		node, child = descriptorChild(node, path[i], int(path[i+1]))
+		if node == nil {
+			return "", false
+		}
+		name = qualifiedName(name, child)
+	}
+	return name, true
+}
+
+// descriptorChild returns the element at index i of the given field of the
+// descriptor node, and its name, or nil if there is no such element.
+func descriptorChild(node interface{}, field int32, i int) (interface{}, string) {
+	in := func(n int) bool { return i >= 0 && i < n }
+	switch n := node.(type) {
+	case *protopb.FileDescriptorProto:
+		switch {
+		case field == fileMessageTypeField && in(len(n.MessageType)):
+			return n.MessageType[i], n.MessageType[i].GetName()
+		case field == fileEnumTypeField && in(len(n.EnumType)):
+			return n.EnumType[i], n.EnumType[i].GetName()
+		case field == fileServiceField && in(len(n.Service)):
+			return n.Service[i], n.Service[i].GetName()
+		case field == fileExtensionField && in(len(n.Extension)):
+			return n.Extension[i], n.Extension[i].GetName()
+		}
+	case *protopb.DescriptorProto:
+		switch {
+		case field == messageFieldField && in(len(n.Field)):
+			return n.Field[i], n.Field[i].GetName()
+		case field == messageNestedField && in(len(n.NestedType)):
+			return n.NestedType[i], n.NestedType[i].GetName()
+		case field == messageEnumField && in(len(n.EnumType)):
+			return n.EnumType[i], n.EnumType[i].GetName()
+		case field == messageExtensionField && in(len(n.Extension)):
+			return n.Extension[i], n.Extension[i].GetName()
+		case field == messageOneofField && in(len(n.OneofDecl)):
+			return n.OneofDecl[i], n.OneofDecl[i].GetName()
+		}
+	case *protopb.EnumDescriptorProto:
+		if field == enumValueField && in(len(n.Value)) {
+			return n.Value[i], n.Value[i].GetName()
+		}
+	case *protopb.ServiceDescriptorProto:
+		if field == serviceMethodField && in(len(n.Method)) {
+			return n.Method[i], n.Method[i].GetName()
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+	"kythe.io/kythe/go/util/schema/edges"
+
+	"github.com/golang/protobuf/proto"
+
+	protopb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+func TestFromFileDescriptor(t *testing.T) {
+	// package pkg;
+	// message Foo {
+	//   int32 bar = 1;
+	//   int32
+	//     baz = 2;
+	// }
+	// enum E { E_UNKNOWN = 0; }
+	fd := &protopb.FileDescriptorProto{
+		Name:    proto.String("foo.proto"),
+		Package: proto.String("pkg"),
+		MessageType: []*protopb.DescriptorProto{{
+			Name: proto.String("Foo"),
+			Field: []*protopb.FieldDescriptorProto{
+				{Name: proto.String("bar")},
+				{Name: proto.String("baz")},
+			},
+		}},
+		EnumType: []*protopb.EnumDescriptorProto{{Name: proto.String("E")}},
+		SourceCodeInfo: &protopb.SourceCodeInfo{
+			Location: []*protopb.SourceCodeInfo_Location{
+				{Path: []int32{4, 0}, Span: []int32{1, 0, 5, 1}},
+				{Path: []int32{4, 0, 1}, Span: []int32{1, 8, 11}},
+				{Path: []int32{4, 0, 1}, Span: []int32{9, 9, 9}}, // not the first
+				{Path: []int32{4, 0, 2, 0, 1}, Span: []int32{2, 8, 11}},
+				{Path: []int32{4, 0, 2, 1}, Span: []int32{3, 2, 4, 11}},
+			},
+		},
+	}
+	gen := &spb.VName{Corpus: "c", Root: "bin", Path: "foo.pb.go", Language: "go"}
+	vname := func(sig string) *spb.VName {
+		return &spb.VName{Corpus: "c", Root: "bin", Path: "foo.pb.go", Language: "go", Signature: sig}
+	}
+	want := Rules{{
+		EdgeIn:   edges.DefinesBinding,
+		EdgeOut:  edges.Generates,
+		VName:    vname("pkg.Foo"),
+		Position: &LineSpan{BeginLine: 2, BeginCol: 8, EndLine: 2, EndCol: 11, Units: ColumnExpandedTabs},
+	}, {
+		EdgeIn:   edges.DefinesBinding,
+		EdgeOut:  edges.Generates,
+		VName:    vname("pkg.Foo.bar"),
+		Position: &LineSpan{BeginLine: 3, BeginCol: 8, EndLine: 3, EndCol: 11, Units: ColumnExpandedTabs},
+	}, {
+		EdgeIn:   edges.DefinesBinding,
+		EdgeOut:  edges.Generates,
+		VName:    vname("pkg.Foo.baz"),
+		Position: &LineSpan{BeginLine: 4, BeginCol: 2, EndLine: 5, EndCol: 11, Units: ColumnExpandedTabs},
+	}}
+	got, err := FromFileDescriptor(fd, gen)
+	if err != nil {
+		t.Fatalf("FromFileDescriptor failed: %v", err)
+	}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("FromFileDescriptor: %v", err)
+	}
+}
+
+func TestFromFileDescriptorTabs(t *testing.T) {
+	// The name of the field follows two tabs, which protoc expands to
+	// columns 8 and 16.
+	const text = "message Foo {\n\tint32\tbar = 1;\n}\n"
+	fd := &protopb.FileDescriptorProto{
+		Name: proto.String("foo.proto"),
+		MessageType: []*protopb.DescriptorProto{{
+			Name:  proto.String("Foo"),
+			Field: []*protopb.FieldDescriptorProto{{Name: proto.String("bar")}},
+		}},
+		SourceCodeInfo: &protopb.SourceCodeInfo{
+			Location: []*protopb.SourceCodeInfo_Location{
+				{Path: []int32{4, 0, 1}, Span: []int32{0, 8, 11}},
+				{Path: []int32{4, 0, 2, 0, 1}, Span: []int32{1, 16, 19}},
+			},
+		},
+	}
+	rs, err := FromFileDescriptor(fd, &spb.VName{Path: "foo.pb.go"})
+	if err != nil {
+		t.Fatalf("FromFileDescriptor failed: %v", err)
+	}
+	if err := rs.ResolveLineColumns([]byte(text)); err != nil {
+		t.Fatalf("ResolveLineColumns failed: %v", err)
+	}
+	var got []string
+	for _, r := range rs {
+		got = append(got, text[r.Begin:r.End])
+	}
+	if err := testutil.DeepEqual([]string{"Foo", "bar"}, got); err != nil {
+		t.Errorf("ResolveLineColumns: %v", err)
+	}
+
+	// A column within the expansion of a tab is not a valid position.
+	bad := Rules{{Position: &LineSpan{BeginLine: 2, BeginCol: 4, EndLine: 2, EndCol: 8, Units: ColumnExpandedTabs}}}
+	if err := bad.ResolveLineColumns([]byte(text)); err == nil {
+		t.Errorf("ResolveLineColumns %+v: got %v, wanted error", bad[0].Position, bad)
+	}
+}
+
+func TestFromFileDescriptorErrors(t *testing.T) {
+	badSpan := &protopb.FileDescriptorProto{
+		MessageType: []*protopb.DescriptorProto{{Name: proto.String("Foo")}},
+		SourceCodeInfo: &protopb.SourceCodeInfo{
+			Location: []*protopb.SourceCodeInfo_Location{
+				{Path: []int32{4, 0}, Span: []int32{1, 2}},
+			},
+		},
+	}
+	tests := []*protopb.FileDescriptorProto{
+		nil,
+		{Name: proto.String("foo.proto")}, // no source code info
+		badSpan,
+	}
+	for _, fd := range tests {
+		if rs, err := FromFileDescriptor(fd, nil); err == nil {
+			t.Errorf("FromFileDescriptor(%v): got %v, wanted error", fd, rs)
+		}
+	}
+}
+
+func TestResolveSignatures(t *testing.T) {
+	fd := &protopb.FileDescriptorProto{
+		Name: proto.String("my.proto"),
+		MessageType: []*protopb.DescriptorProto{{
+			Name: proto.String("MyMessage"),
+			Field: []*protopb.FieldDescriptorProto{
+				{Name: proto.String("id")},
+				{Name: proto.String("my_field")},
+			},
+			NestedType: []*protopb.DescriptorProto{{Name: proto.String("Inner")}},
+		}},
+		EnumType: []*protopb.EnumDescriptorProto{{
+			Name:  proto.String("Kind"),
+			Value: []*protopb.EnumValueDescriptorProto{{Name: proto.String("KIND_UNKNOWN")}},
+		}},
+		Service: []*protopb.ServiceDescriptorProto{{
+			Name:   proto.String("MyService"),
+			Method: []*protopb.MethodDescriptorProto{{Name: proto.String("Get")}},
+		}},
+	}
+	pb := func(path, sig string) *spb.VName {
+		return &spb.VName{Corpus: "c", Path: path, Language: "protobuf", Signature: sig}
+	}
+	shared := pb("my.proto", "4.0.2.1")
+	rs := Rules{
+		{Begin: 1, VName: shared},
+		{Begin: 2, VName: pb("my.proto", "4.0")},
+		{Begin: 3, VName: pb("", "4.0.3.0")},
+		{Begin: 4, VName: pb("my.proto", "5.0.2.0")},
+		{Begin: 5, VName: pb("my.proto", "6.0.2.0")},
+		{Begin: 6, VName: pb("my.proto", "4.7")},   // no such message
+		{Begin: 7, VName: pb("my.proto", "4.0.2")}, // not a definition
+		{Begin: 8, VName: pb("my.proto", "MyMessage")},
+		{Begin: 9, VName: pb("other.proto", "4.0")},
+		{Begin: 10, VName: &spb.VName{Language: "go", Signature: "4.0"}},
+		{Begin: 11},
+	}
+	err := ResolveSignatures(rs, fd)
+	if err == nil {
+		t.Error("ResolveSignatures: got nil, wanted error")
+	} else if msg := err.Error(); !strings.Contains(msg, "4.7") || !strings.Contains(msg, "4.0.2") {
+		t.Errorf("ResolveSignatures: error %q does not report the unresolved paths", msg)
+	}
+	want := Rules{
+		{Begin: 1, VName: pb("my.proto", "MyMessage.my_field")},
+		{Begin: 2, VName: pb("my.proto", "MyMessage")},
+		{Begin: 3, VName: pb("", "MyMessage.Inner")},
+		{Begin: 4, VName: pb("my.proto", "Kind.KIND_UNKNOWN")},
+		{Begin: 5, VName: pb("my.proto", "MyService.Get")},
+		{Begin: 6, VName: pb("my.proto", "4.7")},
+		{Begin: 7, VName: pb("my.proto", "4.0.2")},
+		{Begin: 8, VName: pb("my.proto", "MyMessage")},
+		{Begin: 9, VName: pb("other.proto", "4.0")},
+		{Begin: 10, VName: &spb.VName{Language: "go", Signature: "4.0"}},
+		{Begin: 11},
+	}
+	if err := testutil.DeepEqual(want, rs); err != nil {
+		t.Errorf("ResolveSignatures: %v", err)
+	}
+	if shared.Signature != "4.0.2.1" {
+		t.Errorf("ResolveSignatures modified a shared vname: signature is %q", shared.Signature)
+	}
+
+	ok := Rules{{VName: pb("my.proto", "4.0.2.0")}}
+	if err := ResolveSignatures(ok, fd); err != nil {
+		t.Errorf("ResolveSignatures: unexpected error: %v", err)
+	} else if sig := ok[0].VName.Signature; sig != "MyMessage.id" {
+		t.Errorf("ResolveSignatures: got signature %q, want %q", sig, "MyMessage.id")
+	}
+}
+
+func TestResolveSignaturesQualified(t *testing.T) {
+	fd := &protopb.FileDescriptorProto{
+		Name:    proto.String("foo.proto"),
+		Package: proto.String("pkg"),
+		MessageType: []*protopb.DescriptorProto{{
+			Name:  proto.String("Foo"),
+			Field: []*protopb.FieldDescriptorProto{{Name: proto.String("bar")}},
+		}},
+		SourceCodeInfo: &protopb.SourceCodeInfo{
+			Location: []*protopb.SourceCodeInfo_Location{
+				{Path: []int32{4, 0, 1}, Span: []int32{1, 8, 11}},
+				{Path: []int32{4, 0, 2, 0, 1}, Span: []int32{2, 8, 11}},
+			},
+		},
+	}
+	fromFD, err := FromFileDescriptor(fd, &spb.VName{Path: "foo.pb.go", Language: "go"})
+	if err != nil {
+		t.Fatalf("FromFileDescriptor failed: %v", err)
+	}
+	rs := Rules{
+		{VName: &spb.VName{Path: "foo.proto", Language: "protobuf", Signature: "4.0"}},
+		{VName: &spb.VName{Path: "foo.proto", Language: "protobuf", Signature: "4.0.2.0"}},
+	}
+	if err := ResolveSignatures(rs, fd); err != nil {
+		t.Fatalf("ResolveSignatures failed: %v", err)
+	}
+	if len(fromFD) != len(rs) {
+		t.Fatalf("FromFileDescriptor: got %d rules, want %d", len(fromFD), len(rs))
+	}
+	for i, r := range rs {
+		if got, want := r.VName.Signature, fromFD[i].VName.Signature; got != want {
+			t.Errorf("ResolveSignatures: got signature %q, want %q as FromFileDescriptor", got, want)
+		}
+	}
+	if got, want := rs[1].VName.Signature, "pkg.Foo.bar"; got != want {
+		t.Errorf("ResolveSignatures: got signature %q, want %q", got, want)
+	}
+}
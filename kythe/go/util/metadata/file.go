@@ -0,0 +1,165 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ParseFile reads and parses the metadata file at path. Any error reported
+// includes the path. It is an error if path denotes a directory or an empty
+// file.
+func ParseFile(path string) (Rules, error) { return ParseFileWithOptions(path, nil) }
+
+// ParseFileWithOptions reads and parses the metadata file at path as
+// ParseFile, using the settings from opts.
+func ParseFileWithOptions(path string, opts *ParseOptions) (Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err // os.PathError already mentions path
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil {
+		return nil, err
+	} else if fi.IsDir() {
+		return nil, fmt.Errorf("%s: metadata: is a directory", path)
+	} else if fi.Mode().IsRegular() && fi.Size() == 0 {
+		return nil, fmt.Errorf("%s: metadata: empty file", path)
+	}
+	rs, err := ParseWithOptions(f, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rs, nil
+}
+
+// A FileError records the failure to parse the metadata file at Path.
+type FileError struct {
+	Path string
+	Err  error // as reported by ParseFile, mentioning the path
+}
+
+// FileErrors is returned by ParseFiles to report every file that could not be
+// parsed, in order of path.
+type FileErrors []FileError
+
+// Error satisfies the error interface.
+func (fe FileErrors) Error() string {
+	msgs := make([]string, len(fe))
+	for i, e := range fe {
+		msgs[i] = e.Err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether the error for any of the files matches target, so that,
+// for example, errors.Is(err, ErrMalformed) reports whether any file was
+// malformed.
+func (fe FileErrors) Is(target error) bool {
+	for _, e := range fe {
+		if errors.Is(e.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFiles reads and parses the metadata files at paths as ParseFile, using
+// up to concurrency goroutines, and returns the rules of each file that was
+// parsed successfully, keyed by its path. A path that is listed more than
+// once is parsed once. If any file cannot be parsed, the error has concrete
+// type FileErrors, and lists every such file in order of path, so that the
+// result does not depend on the order in which the files were parsed. If
+// concurrency < 1, the files are parsed one at a time.
+func ParseFiles(paths []string, concurrency int) (map[string]Rules, error) {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if !seen[path] {
+			seen[path] = true
+			unique = append(unique, path)
+		}
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	} else if concurrency > len(unique) {
+		concurrency = len(unique)
+	}
+
+	rules := make([]Rules, len(unique))
+	errs := make([]error, len(unique))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				rules[i], errs[i] = ParseFile(unique[i])
+			}
+		}()
+	}
+	for i := range unique {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	out := make(map[string]Rules, len(unique))
+	var bad FileErrors
+	for i, path := range unique {
+		if errs[i] != nil {
+			bad = append(bad, FileError{Path: path, Err: errs[i]})
+		} else {
+			out[path] = rules[i]
+		}
+	}
+	if bad != nil {
+		sort.Slice(bad, func(i, j int) bool { return bad[i].Path < bad[j].Path })
+		return out, bad
+	}
+	return out, nil
+}
+
+// gzipMagic is the header that begins every gzip stream (RFC 1952).
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ParseMaybeGzip parses a single JSON metadata object from r as Parse. If the
+// input begins with the gzip magic bytes it is decompressed first; otherwise
+// it is parsed as plain JSON. Any error returned has concrete type
+// *ParseError.
+func ParseMaybeGzip(r io.Reader) (Rules, error) {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(gzipMagic)); !bytes.Equal(head, gzipMagic) {
+		return Parse(br)
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, &ParseError{Index: -1, Err: fmt.Errorf("invalid gzip data: %v", err)}
+	}
+	defer zr.Close()
+	return Parse(zr)
+}
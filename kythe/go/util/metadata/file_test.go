@@ -0,0 +1,215 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+)
+
+func TestParseFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadata")
+	if err != nil {
+		t.Fatalf("Creating temp directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"good.meta":  `{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2}]}`,
+		"bad.meta":   `{"type":"kythe0","meta":[{"type":"bogus"}]}`,
+		"empty.meta": "",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("Writing %q: %v", name, err)
+		}
+	}
+
+	good := filepath.Join(dir, "good.meta")
+	got, err := ParseFile(good)
+	if err != nil {
+		t.Errorf("ParseFile(%q) failed: %v", good, err)
+	} else if err := testutil.DeepEqual(Rules{{Begin: 1, End: 2}}, got); err != nil {
+		t.Errorf("ParseFile(%q): %v", good, err)
+	}
+
+	for _, name := range []string{"bad.meta", "empty.meta", "missing.meta", ""} {
+		path := filepath.Join(dir, name)
+		rs, err := ParseFile(path)
+		if err == nil {
+			t.Errorf("ParseFile(%q): got %+v, wanted error", path, rs)
+			continue
+		} else if !strings.Contains(err.Error(), path) {
+			t.Errorf("ParseFile(%q): error %q does not mention the path", path, err)
+		}
+		t.Logf("ParseFile(%q): %v", path, err)
+	}
+
+	bad := filepath.Join(dir, "bad.meta")
+	if _, err := ParseFile(bad); !errors.Is(err, ErrMalformed) {
+		t.Errorf("ParseFile(%q): got error %v, want %v", bad, err, ErrMalformed)
+	}
+}
+
+func TestParseFileWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadata")
+	if err != nil {
+		t.Fatalf("Creating temp directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Strict mode rejects the inverted span, which Parse accepts.
+	path := filepath.Join(dir, "inverted.meta")
+	const data = `{"type":"kythe0","meta":[{"type":"nop","begin":5,"end":2}]}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Writing %q: %v", path, err)
+	}
+	if _, err := ParseFileWithOptions(path, nil); err != nil {
+		t.Errorf("ParseFileWithOptions(%q, nil) failed: %v", path, err)
+	}
+	rs, err := ParseFileWithOptions(path, &ParseOptions{Strict: true})
+	if err == nil {
+		t.Errorf("ParseFileWithOptions(%q, strict): got %+v, wanted error", path, rs)
+	} else if !strings.Contains(err.Error(), path) {
+		t.Errorf("ParseFileWithOptions(%q, strict): error %q does not mention the path", path, err)
+	} else if !errors.Is(err, ErrMalformed) {
+		t.Errorf("ParseFileWithOptions(%q, strict): got error %v, want %v", path, err, ErrMalformed)
+	}
+}
+
+func TestParseFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadata")
+	if err != nil {
+		t.Fatalf("Creating temp directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.meta": `{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2}]}`,
+		"b.meta": `{"type":"kythe0","meta":[{"type":"bogus"}]}`,
+		"c.meta": `{"type":"kythe0","meta":[{"type":"nop","begin":3,"end":4}]}`,
+		"d.meta": "",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("Writing %q: %v", name, err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+	paths := []string{path("d.meta"), path("c.meta"), path("missing.meta"), path("b.meta"), path("a.meta"), path("c.meta")}
+
+	for _, concurrency := range []int{0, 1, 3, 100} {
+		got, err := ParseFiles(paths, concurrency)
+		want := map[string]Rules{
+			path("a.meta"): {{Begin: 1, End: 2}},
+			path("c.meta"): {{Begin: 3, End: 4}},
+		}
+		if err := testutil.DeepEqual(want, got); err != nil {
+			t.Errorf("ParseFiles(%d): %v", concurrency, err)
+		}
+		fe, ok := err.(FileErrors)
+		if !ok {
+			t.Errorf("ParseFiles(%d): got error %v, want FileErrors", concurrency, err)
+			continue
+		}
+		var failed []string
+		for _, e := range fe {
+			failed = append(failed, e.Path)
+		}
+		// The errors are reported in order of path.
+		wantFailed := []string{path("b.meta"), path("d.meta"), path("missing.meta")}
+		if err := testutil.DeepEqual(wantFailed, failed); err != nil {
+			t.Errorf("ParseFiles(%d) failed paths: %v", concurrency, err)
+		}
+		if !errors.Is(err, ErrMalformed) {
+			t.Errorf("ParseFiles(%d): got error %v, want %v", concurrency, err, ErrMalformed)
+		}
+	}
+
+	if got, err := ParseFiles(nil, 4); err != nil || len(got) != 0 {
+		t.Errorf("ParseFiles(nil): got %v, %v; want no rules", got, err)
+	}
+}
+
+func BenchmarkParseFiles(b *testing.B) {
+	dir, err := ioutil.TempDir("", "metadata")
+	if err != nil {
+		b.Fatalf("Creating temp directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := benchInput(20)
+	var paths []string
+	for i := 0; i < 200; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%d.meta", i))
+		if err := ioutil.WriteFile(path, input, 0644); err != nil {
+			b.Fatalf("Writing %q: %v", path, err)
+		}
+		paths = append(paths, path)
+	}
+	for _, concurrency := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("Concurrency%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseFiles(paths, concurrency); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseMaybeGzip(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2}]}`
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	if _, err := zw.Write([]byte(input)); err != nil {
+		t.Fatalf("Compressing input: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Compressing input: %v", err)
+	}
+
+	want := Rules{{Begin: 1, End: 2}}
+	for _, data := range [][]byte{[]byte(input), zipped.Bytes()} {
+		got, err := ParseMaybeGzip(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("ParseMaybeGzip(%q) failed: %v", data, err)
+		} else if err := testutil.DeepEqual(want, got); err != nil {
+			t.Errorf("ParseMaybeGzip(%q): %v", data, err)
+		}
+	}
+
+	// Inputs shorter than the magic number, and corrupt gzip streams, are
+	// reported as malformed.
+	truncated := zipped.Bytes()[:zipped.Len()/2]
+	for _, data := range [][]byte{nil, {0x1f}, {0x1f, 0x8b, 0}, truncated} {
+		if rs, err := ParseMaybeGzip(bytes.NewReader(data)); err == nil {
+			t.Errorf("ParseMaybeGzip(%q): got %+v, wanted error", data, rs)
+		} else if !errors.Is(err, ErrMalformed) {
+			t.Errorf("ParseMaybeGzip(%q): got error %v, want %v", data, err, ErrMalformed)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		`{"type":"kythe0"}`,
+		`{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2}]}`,
+		`{"meta":[{"type":"anchor_defines","begin":1,"end":2,"edge":"%/kythe/edge/generates","vname":{"corpus":"c"}}],"type":"kythe0"}`,
+		`{"type":"kythe0","meta":[{"type":"nop","extra":[[[[[]]]]]}]}`,
+		"\xef\xbb\xbf{\"type\":\"kythe1\",\"meta\":null}",
+	} {
+		f.Add([]byte(seed))
+	}
+	limits := &ParseLimits{MaxRules: 16, MaxBytes: 1024, MaxDepth: 4}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Parse(bytes.NewReader(data)) // must not panic
+
+		rs, err := ParseLimited(bytes.NewReader(data), limits)
+		var lerr *LimitError
+		if errors.As(err, &lerr) {
+			if rs != nil {
+				t.Errorf("ParseLimited: got %d rules with limit error %v", len(rs), err)
+			}
+			return
+		} else if err != nil {
+			return
+		}
+		if len(rs) > limits.MaxRules {
+			t.Errorf("ParseLimited: got %d rules, limit is %d", len(rs), limits.MaxRules)
+		}
+		if int64(len(data)) > limits.MaxBytes {
+			t.Errorf("ParseLimited: accepted %d bytes, limit is %d", len(data), limits.MaxBytes)
+		}
+	})
+}
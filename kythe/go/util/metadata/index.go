@@ -0,0 +1,221 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A RuleIndex supports efficient span queries over a fixed set of rules.
+// Construct a RuleIndex with BuildIndex.
+type RuleIndex struct {
+	rules  Rules // in the order imposed by Rules.Sort
+	maxEnd []int // see buildMaxEnd
+}
+
+// BuildIndex constructs a RuleIndex over a copy of rs. Construction takes
+// O(n log n) time for n rules; thereafter each query takes O((m+1) log n)
+// time for m results.
+func BuildIndex(rs Rules) *RuleIndex {
+	idx := &RuleIndex{
+		rules:  append(Rules(nil), rs...),
+		maxEnd: make([]int, len(rs)),
+	}
+	idx.rules.Sort()
+	idx.buildMaxEnd(0, len(idx.rules))
+	return idx
+}
+
+// The sorted rules are treated as an implicit balanced binary tree, in which
+// the root of the subtree spanning rules[lo:hi] is at mid = (lo+hi)/2.
+// maxEnd[mid] records the largest End offset of any rule in that subtree,
+// which allows a query to skip subtrees containing no candidates.
+func (idx *RuleIndex) buildMaxEnd(lo, hi int) int {
+	if lo >= hi {
+		return -1
+	}
+	mid := (lo + hi) / 2
+	max := idx.rules[mid].End
+	if left := idx.buildMaxEnd(lo, mid); left > max {
+		max = left
+	}
+	if right := idx.buildMaxEnd(mid+1, hi); right > max {
+		max = right
+	}
+	idx.maxEnd[mid] = max
+	return max
+}
+
+// Len returns the number of rules in the index.
+func (idx *RuleIndex) Len() int { return len(idx.rules) }
+
+// Covering returns the rules whose spans contain or equal the span from begin
+// to end, in the order imposed by Rules.Sort. It returns nil if no rules
+// match.
+func (idx *RuleIndex) Covering(begin, end int) Rules { return idx.CoveringInto(begin, end, nil) }
+
+// CoveringInto appends the rules that Covering would return to dst, and
+// returns the extended slice. It does not allocate if dst has room for the
+// matches, so a caller that queries the index for each of many anchors can
+// reuse a single buffer, for example:
+//
+//	var buf []metadata.Rule
+//	for _, a := range anchors {
+//		buf = idx.CoveringInto(a.Begin, a.End, buf[:0])
+//		for _, r := range buf {
+//			// ...
+//		}
+//	}
+//
+// The rules appended are copies, but share their vnames with the index.
+func (idx *RuleIndex) CoveringInto(begin, end int, dst []Rule) []Rule {
+	idx.covering(0, len(idx.rules), begin, end, func(r Rule) { dst = append(dst, r) })
+	return dst
+}
+
+func (idx *RuleIndex) covering(lo, hi, begin, end int, f func(Rule)) {
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if idx.maxEnd[mid] < end {
+			return // no rule in this subtree extends far enough
+		}
+		idx.covering(lo, mid, begin, end, f)
+		r := idx.rules[mid]
+		if r.Begin > begin {
+			return // this rule and all those after it begin too late
+		}
+		if r.End >= end {
+			f(r)
+		}
+		lo = mid + 1
+	}
+}
+
+// Exact returns the rules whose spans are exactly the span from begin to end,
+// in the order imposed by Rules.Sort. This is the matching rule used by the
+// C++ metadata reader, in which a rule applies to an anchor only if their
+// offsets are equal.
+func (idx *RuleIndex) Exact(begin, end int) Rules {
+	i := sort.Search(len(idx.rules), func(i int) bool {
+		r := idx.rules[i]
+		return r.Begin > begin || (r.Begin == begin && r.End >= end)
+	})
+	var out Rules
+	for ; i < len(idx.rules); i++ {
+		if r := idx.rules[i]; r.Begin == begin && r.End == end {
+			out = append(out, r)
+		} else {
+			break
+		}
+	}
+	return out
+}
+
+// Explain returns a human-readable account of how the rules of idx match the
+// span from begin to end, for debugging. It lists every rule in the index, in
+// the order imposed by Rules.Sort, and says whether the rule covers the span,
+// as Covering, and if so whether the offsets are equal, as Exact; or else why
+// the rule does not match. Explain examines every rule, so it is much slower
+// than a query and should not be used when indexing.
+func (idx *RuleIndex) Explain(begin, end int) string {
+	var sb strings.Builder
+	var n int
+	for _, r := range idx.rules {
+		verdict := explainMatch(r, begin, end)
+		if strings.HasPrefix(verdict, "match") {
+			n++
+		}
+		fmt.Fprintf(&sb, "  %-30s %s\n", verdict, r)
+	}
+	return fmt.Sprintf("query [%d,%d): %d of %d rules match\n", begin, end, n, len(idx.rules)) + sb.String()
+}
+
+// explainMatch describes whether and why r matches the span from begin to
+// end, for Explain.
+func explainMatch(r Rule, begin, end int) string {
+	switch {
+	case r.Begin > r.End:
+		return "reject (invalid span)"
+	case r.Begin == begin && r.End == end:
+		return "match (offsets equal)"
+	case r.Begin <= begin && r.End >= end:
+		return "match (contains query)"
+	case r.Begin > begin:
+		return "reject (begins after query)"
+	default:
+		return "reject (ends before query)"
+	}
+}
+
+// An IntervalIndex is an incrementally constructed index of rules supporting
+// covering queries. Add rules with Insert, then call Build before querying.
+// The zero value is an empty index ready for use.
+//
+// Build takes O(n log n) time for n rules, and each query thereafter takes
+// O((m+1) log n) time for m results, compared with O(n) for a linear scan of
+// the rules. The index is therefore worthwhile when more than a few queries
+// are made against the same rules.
+type IntervalIndex struct {
+	pending Rules
+	idx     *RuleIndex
+}
+
+// Insert adds r to the index. The rule is not visible to Query until the next
+// call to Build.
+func (x *IntervalIndex) Insert(r Rule) { x.pending = append(x.pending, r) }
+
+// Build constructs the index over all the rules inserted so far.
+func (x *IntervalIndex) Build() { x.idx = BuildIndex(x.pending) }
+
+// Query returns the rules whose spans contain or equal the span from begin to
+// end, as RuleIndex.Covering, as of the most recent call to Build. It returns
+// nil if no rules match or if Build has not been called.
+func (x *IntervalIndex) Query(begin, end int) []Rule {
+	if x.idx == nil {
+		return nil
+	}
+	return x.idx.Covering(begin, end)
+}
+
+// A SafeIndex is a RuleIndex that may be shared by multiple goroutines. Call
+// Build once to construct the index; thereafter Query is safe for concurrent
+// use without further synchronization, since the index is never modified
+// after Build returns. Build must not be called concurrently with Query.
+//
+// The index holds its own copy of the rules, so the caller may modify the
+// rules passed to Build afterward. Rules returned by Query share their VName
+// and other referenced values with the index, and must be treated as
+// read-only; use Rules.Clone to obtain a copy that may be modified.
+type SafeIndex struct {
+	idx *RuleIndex
+}
+
+// Build constructs the index over a copy of rs, replacing any previous
+// contents.
+func (x *SafeIndex) Build(rs Rules) { x.idx = BuildIndex(rs.Clone()) }
+
+// Query returns the rules whose spans contain or equal the span from begin to
+// end, as RuleIndex.Covering. It returns nil if no rules match or if Build has
+// not been called.
+func (x *SafeIndex) Query(begin, end int) Rules {
+	if x.idx == nil {
+		return nil
+	}
+	return x.idx.Covering(begin, end)
+}
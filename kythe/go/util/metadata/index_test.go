@@ -0,0 +1,267 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+)
+
+func TestRuleIndex(t *testing.T) {
+	rules := Rules{
+		{Begin: 10, End: 20, EdgeOut: "a"},
+		{Begin: 0, End: 100, EdgeOut: "b"},
+		{Begin: 10, End: 20, EdgeOut: "c"},
+		{Begin: 12, End: 15, EdgeOut: "d"},
+		{Begin: 15, End: 15, EdgeOut: "e"},
+		{Begin: 50, End: 60, EdgeOut: "f"},
+	}
+	idx := BuildIndex(rules)
+	tests := []struct {
+		begin, end int
+		covering   string // EdgeOut values
+		exact      string
+	}{
+		{10, 20, "bac", "ac"},
+		{12, 15, "bacd", "d"},
+		{15, 15, "bacde", "e"},
+		{0, 100, "b", "b"},
+		{55, 56, "bf", ""},
+		{99, 101, "", ""},
+		{200, 300, "", ""},
+	}
+	kinds := func(rs Rules) string {
+		var s string
+		for _, r := range rs {
+			s += r.EdgeOut
+		}
+		return s
+	}
+	for _, test := range tests {
+		if got := kinds(idx.Covering(test.begin, test.end)); got != test.covering {
+			t.Errorf("Covering(%d, %d): got %q, want %q", test.begin, test.end, got, test.covering)
+		}
+		if got := kinds(idx.Exact(test.begin, test.end)); got != test.exact {
+			t.Errorf("Exact(%d, %d): got %q, want %q", test.begin, test.end, got, test.exact)
+		}
+	}
+}
+
+func TestCoveringInto(t *testing.T) {
+	idx := BuildIndex(Rules{
+		{Begin: 0, End: 10},
+		{Begin: 2, End: 5},
+		{Begin: 3, End: 4},
+		{Begin: 6, End: 8},
+	})
+	buf := make([]Rule, 0, 4)
+	for _, q := range [][2]int{{3, 4}, {6, 7}, {11, 12}, {0, 10}} {
+		want := idx.Covering(q[0], q[1])
+		got := idx.CoveringInto(q[0], q[1], buf[:0])
+		if len(want) == 0 && len(got) == 0 {
+			continue
+		}
+		if err := testutil.DeepEqual([]Rule(want), got); err != nil {
+			t.Errorf("CoveringInto(%d, %d): %v", q[0], q[1], err)
+		}
+	}
+
+	// Matches are appended to the existing contents of dst.
+	prefix := []Rule{{Begin: 99, End: 99}}
+	got := idx.CoveringInto(6, 7, prefix)
+	want := []Rule{{Begin: 99, End: 99}, {Begin: 0, End: 10}, {Begin: 6, End: 8}}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("CoveringInto with prefix: %v", err)
+	}
+
+	if n := testing.AllocsPerRun(100, func() { buf = idx.CoveringInto(3, 4, buf[:0]) }); n != 0 {
+		t.Errorf("CoveringInto with capacity: got %v allocations, want 0", n)
+	}
+}
+
+func TestRuleIndexLinear(t *testing.T) {
+	// Compare the index against a linear scan over random rules.
+	rng := rand.New(rand.NewSource(1))
+	rules := make(Rules, 500)
+	for i := range rules {
+		b := rng.Intn(1000)
+		rules[i] = Rule{Begin: b, End: b + rng.Intn(50)}
+	}
+	idx := BuildIndex(rules)
+	for i := 0; i < 1000; i++ {
+		b := rng.Intn(1000)
+		e := b + rng.Intn(10)
+		var want Rules
+		for _, r := range rules {
+			if r.Begin <= b && r.End >= e {
+				want = append(want, r)
+			}
+		}
+		want.Sort()
+		if err := testutil.DeepEqual(want, idx.Covering(b, e)); err != nil {
+			t.Fatalf("Covering(%d, %d): %v", b, e, err)
+		}
+	}
+}
+
+func TestRuleIndexExplain(t *testing.T) {
+	idx := BuildIndex(Rules{
+		{Begin: 12, End: 13},
+		{Begin: 10, End: 20},
+		{Begin: 30, End: 40},
+		{Begin: 0, End: 100},
+		{Begin: 5, End: 8},
+		{Begin: 9, End: 3},
+	})
+	const want = `query [10,20): 2 of 6 rules match
+  match (contains query)         [0,100) nop
+  reject (ends before query)     [5,8) nop
+  reject (invalid span)          [9,3) nop
+  match (offsets equal)          [10,20) nop
+  reject (begins after query)    [12,13) nop
+  reject (begins after query)    [30,40) nop
+`
+	if got := idx.Explain(10, 20); got != want {
+		t.Errorf("Explain(10, 20): got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestIntervalIndex(t *testing.T) {
+	var idx IntervalIndex
+	if got := idx.Query(0, 1); got != nil {
+		t.Errorf("Query before Build: got %v, want nil", got)
+	}
+	idx.Insert(Rule{Begin: 0, End: 100, EdgeOut: "a"})
+	idx.Insert(Rule{Begin: 10, End: 20, EdgeOut: "b"})
+	idx.Build()
+	idx.Insert(Rule{Begin: 12, End: 14, EdgeOut: "c"})
+	want := []Rule{{Begin: 0, End: 100, EdgeOut: "a"}, {Begin: 10, End: 20, EdgeOut: "b"}}
+	if err := testutil.DeepEqual(want, idx.Query(12, 14)); err != nil {
+		t.Errorf("Query before rebuild: %v", err)
+	}
+	idx.Build()
+	want = append(want, Rule{Begin: 12, End: 14, EdgeOut: "c"})
+	if err := testutil.DeepEqual(want, idx.Query(12, 14)); err != nil {
+		t.Errorf("Query after rebuild: %v", err)
+	}
+}
+
+func TestSafeIndexConcurrent(t *testing.T) {
+	rules, queries := benchRules(1000)
+	check := BuildIndex(rules)
+	want := make([]Rules, len(queries))
+	for i, q := range queries {
+		want[i] = check.Covering(q[0], q[1])
+	}
+
+	var idx SafeIndex
+	if got := idx.Query(0, 1); got != nil {
+		t.Errorf("Query before Build: got %v, want nil", got)
+	}
+	idx.Build(rules)
+	for i := range rules {
+		rules[i].Begin = -1 // the index holds its own copy
+	}
+
+	// Run with -race to check that concurrent queries do not conflict.
+	const workers = 64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w % 8; i < len(queries); i += 8 {
+				q := queries[i]
+				if err := testutil.DeepEqual(want[i], idx.Query(q[0], q[1])); err != nil {
+					t.Errorf("Query(%d, %d): %v", q[0], q[1], err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// benchRules returns n random rules over a file of about 10n bytes, along
+// with query spans drawn from the same range.
+func benchRules(n int) (Rules, [][2]int) {
+	rng := rand.New(rand.NewSource(1))
+	rules := make(Rules, n)
+	for i := range rules {
+		b := rng.Intn(10 * n)
+		rules[i] = Rule{Begin: b, End: b + rng.Intn(20)}
+	}
+	queries := make([][2]int, 1000)
+	for i := range queries {
+		b := rng.Intn(10 * n)
+		queries[i] = [2]int{b, b + rng.Intn(5)}
+	}
+	return rules, queries
+}
+
+func BenchmarkCoveringInto(b *testing.B) {
+	rules, queries := benchRules(1000)
+	idx := BuildIndex(rules)
+	buf := make([]Rule, 0, len(rules))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q := queries[i%len(queries)]
+		buf = idx.CoveringInto(q[0], q[1], buf[:0])
+	}
+	b.StopTimer()
+	if n := testing.AllocsPerRun(10, func() {
+		for _, q := range queries {
+			buf = idx.CoveringInto(q[0], q[1], buf[:0])
+		}
+	}); n != 0 {
+		b.Errorf("CoveringInto: got %v allocations per run, want 0", n)
+	}
+}
+
+func BenchmarkQuery(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		rules, queries := benchRules(n)
+		b.Run(fmt.Sprintf("Index/%d", n), func(b *testing.B) {
+			var idx IntervalIndex
+			for _, r := range rules {
+				idx.Insert(r)
+			}
+			idx.Build()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				q := queries[i%len(queries)]
+				idx.Query(q[0], q[1])
+			}
+		})
+		b.Run(fmt.Sprintf("Linear/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				q := queries[i%len(queries)]
+				var out []Rule
+				for _, r := range rules {
+					if r.Begin <= q[0] && r.End >= q[1] {
+						out = append(out, r)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ExtractInline finds each occurrence of marker in source, and parses the
+// base64-encoded metadata object that follows it, as in the comment
+//
+//	// kythe-inline-metadata:eyJ0eXBlIjoia3l0aGUwIn0=
+//
+// where marker is "kythe-inline-metadata:". The payload extends from the end
+// of the marker to the next whitespace or the end of source. The rules from
+// all the blocks are returned together, in the order the blocks appear. It
+// returns nil if source contains no markers.
+func ExtractInline(source []byte, marker string) (Rules, error) {
+	if marker == "" {
+		return nil, errors.New("metadata: empty inline marker")
+	}
+	var rs Rules
+	for block := 0; ; block++ {
+		i := bytes.Index(source, []byte(marker))
+		if i < 0 {
+			break
+		}
+		source = source[i+len(marker):]
+		end := bytes.IndexAny(source, " \t\r\n")
+		if end < 0 {
+			end = len(source)
+		}
+		payload := source[:end]
+		source = source[end:]
+
+		more, err := decodeInline(block, payload)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, more...)
+	}
+	return rs, nil
+}
+
+// goDirective is the comment prefix that marks inline metadata in Go source.
+const goDirective = "//kythe:metadata "
+
+// ExtractGoInline parses the metadata carried by directive comments in the Go
+// source file src, as in
+//
+//	//kythe:metadata eyJ0eXBlIjoia3l0aGUwIn0=
+//
+// As with other Go directives, the comment must begin a line, with no space
+// between the slashes and the directive name. The rest of the line is the
+// base64-encoded metadata object. The offsets of the rules are relative to
+// the start of src, not to the comment, so directives may appear anywhere in
+// the file; by convention they follow the code they describe. The rules from
+// all the directives are returned together, in the order the directives
+// appear. It returns nil if src contains no directives.
+func ExtractGoInline(src []byte) (Rules, error) {
+	var rs Rules
+	for block := 0; len(src) > 0; {
+		line := src
+		if i := bytes.IndexByte(src, '\n'); i >= 0 {
+			line, src = src[:i], src[i+1:]
+		} else {
+			src = nil
+		}
+		if !bytes.HasPrefix(line, []byte(goDirective)) {
+			continue
+		}
+		more, err := decodeInline(block, bytes.TrimSpace(line[len(goDirective):]))
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, more...)
+		block++
+	}
+	return rs, nil
+}
+
+// decodeInline decodes and parses the base64-encoded metadata object in
+// payload, the given block of inline metadata in some file.
+func decodeInline(block int, payload []byte) (Rules, error) {
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(payload)))
+	n, err := base64.StdEncoding.Decode(data, payload)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: inline block %d: invalid base64: %v", block, err)
+	}
+	rs, err := ParseBytes(data[:n])
+	if err != nil {
+		return nil, &inlineError{block: block, err: err}
+	}
+	return rs, nil
+}
+
+// An inlineError reports a problem parsing a block of inline metadata. Its
+// message gives the block before the details of the underlying error, which
+// already carries the package prefix.
+type inlineError struct {
+	block int
+	err   error
+}
+
+func (e *inlineError) Error() string {
+	return fmt.Sprintf("metadata: inline block %d: %s", e.block, strings.TrimPrefix(e.err.Error(), "metadata: "))
+}
+
+// Unwrap returns the underlying error.
+func (e *inlineError) Unwrap() error { return e.err }
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+)
+
+func TestExtractInline(t *testing.T) {
+	const marker = "kythe-inline-metadata:"
+	block := func(input string) string {
+		return "// " + marker + base64.StdEncoding.EncodeToString([]byte(input)) + "\n"
+	}
+	first := block(`{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2}]}`)
+	second := block(`{"type":"kythe0","meta":[{"type":"nop","begin":3,"end":4},{"type":"nop","begin":5,"end":6}]}`)
+	tests := []struct {
+		source string
+		want   Rules
+	}{
+		{"package foo\n", nil},
+		{"package foo\n" + first + "var x int\n", Rules{{Begin: 1, End: 2}}},
+		{first + "package foo\n" + second, Rules{{Begin: 1, End: 2}, {Begin: 3, End: 4}, {Begin: 5, End: 6}}},
+	}
+	for _, test := range tests {
+		got, err := ExtractInline([]byte(test.source), marker)
+		if err != nil {
+			t.Errorf("ExtractInline(%q) failed: %v", test.source, err)
+			continue
+		}
+		if err := testutil.DeepEqual(test.want, got); err != nil {
+			t.Errorf("ExtractInline(%q): %v", test.source, err)
+		}
+	}
+
+	for _, bad := range []string{
+		"// " + marker + "not base64!\n",
+		first + block(`{"type":"wrong"}`),
+	} {
+		if rs, err := ExtractInline([]byte(bad), marker); err == nil {
+			t.Errorf("ExtractInline(%q): got %v, wanted error", bad, rs)
+		} else {
+			t.Logf("ExtractInline(%q): %v", bad, err)
+		}
+	}
+	_, err := ExtractInline([]byte(first+block(`{"type":"kythe0","meta":[{"type":"bogus"}]}`)), marker)
+	if want := "metadata: inline block 1: rule 0: "; err == nil || !errors.Is(err, ErrMalformed) || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("ExtractInline: got error %v, want %q prefix and %v", err, want, ErrMalformed)
+	}
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Index != 0 {
+		t.Errorf("ExtractInline: got error %v, want *ParseError for rule 0", err)
+	}
+	if _, err := ExtractInline([]byte(first), ""); err == nil {
+		t.Error("ExtractInline with empty marker: got nil error")
+	}
+}
+
+func TestExtractGoInline(t *testing.T) {
+	const code = `// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: foo.proto
+
+package foopb
+
+type Foo struct {
+	Name string
+}
+
+func (x *Foo) GetName() string { return x.Name }
+`
+	span := func(ident string) (int, int) {
+		i := strings.Index(code, ident)
+		return i, i + len(ident)
+	}
+	directive := func(ident, sig string) string {
+		begin, end := span(ident)
+		meta := fmt.Sprintf(`{"type":"kythe0","meta":[{"type":"anchor_defines","begin":%d,"end":%d,`+
+			`"edge":"%%/kythe/edge/generates","vname":{"corpus":"c","path":"foo.proto","signature":%q}}]}`,
+			begin, end, sig)
+		return "//kythe:metadata " + base64.StdEncoding.EncodeToString([]byte(meta)) + "\n"
+	}
+	src := code + "\n" + directive("Foo struct", "4.0") + directive("GetName", "4.0.2.0")
+	// Neither an indented directive nor one in an ordinary comment counts.
+	src += "\t" + directive("Name string", "x") + "// " + directive("Name string", "y")
+
+	rs, err := ExtractGoInline([]byte(src))
+	if err != nil {
+		t.Fatalf("ExtractGoInline failed: %v", err)
+	}
+	var got []string
+	for _, r := range rs {
+		got = append(got, src[r.Begin:r.End]+"="+r.VName.Signature)
+	}
+	if err := testutil.DeepEqual([]string{"Foo struct=4.0", "GetName=4.0.2.0"}, got); err != nil {
+		t.Errorf("ExtractGoInline: %v", err)
+	}
+
+	if rs, err := ExtractGoInline([]byte(code)); rs != nil || err != nil {
+		t.Errorf("ExtractGoInline without directives: got (%v, %v), want (nil, nil)", rs, err)
+	}
+	for _, bad := range []string{
+		"//kythe:metadata !!!\n",
+		"//kythe:metadata " + base64.StdEncoding.EncodeToString([]byte(`{"type":"bogus"}`)) + "\n",
+	} {
+		if rs, err := ExtractGoInline([]byte(code + bad)); err == nil {
+			t.Errorf("ExtractGoInline(%q): got %v, wanted error", bad, rs)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+//go:build go1.23
+// +build go1.23
+
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// The iterators in this file require the iter package of Go 1.23. The module
+// declares an older language version, for the Go SDK used by the Bazel build,
+// so this file is built only by toolchains that have the package.
+
+package metadata
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+)
+
+// All returns an iterator over the rules of rs, in order.
+func (rs Rules) All() iter.Seq[Rule] {
+	return func(yield func(Rule) bool) {
+		for _, r := range rs {
+			if !yield(r) {
+				return
+			}
+		}
+	}
+}
+
+// Indexed returns an iterator over the indices and rules of rs, in order.
+func (rs Rules) Indexed() iter.Seq2[int, Rule] {
+	return func(yield func(int, Rule) bool) {
+		for i, r := range rs {
+			if !yield(i, r) {
+				return
+			}
+		}
+	}
+}
+
+// errStopped is reported by the callback of ParseSeq to end decoding when the
+// consumer stops iterating.
+var errStopped = errors.New("iteration stopped")
+
+// ParseSeq returns an iterator over the rules of the metadata object read from
+// r as ParseEach. The rules are decoded as they are requested. If decoding
+// fails, the iterator yields the error, as reported by Parse, and stops.
+// Reading from r begins when iteration does, and the iterator may be used
+// only once.
+func ParseSeq(r io.Reader) iter.Seq2[Rule, error] {
+	return func(yield func(Rule, error) bool) {
+		d, err := newDecoder(context.Background(), r, nil)
+		if err != nil {
+			yield(Rule{}, err)
+			return
+		}
+		err = d.decode(func(_ int, rule Rule) error {
+			if !yield(rule, nil) {
+				return errStopped
+			}
+			return nil
+		})
+		if err != nil && err != errStopped {
+			yield(Rule{}, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+//go:build go1.23
+// +build go1.23
+
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+)
+
+func TestIterators(t *testing.T) {
+	rs := Rules{{Begin: 1}, {Begin: 2}, {Begin: 3}}
+	var got Rules
+	for r := range rs.All() {
+		if r.Begin == 3 {
+			break
+		}
+		got = append(got, r)
+	}
+	if err := testutil.DeepEqual(rs[:2], got); err != nil {
+		t.Errorf("All: %v", err)
+	}
+
+	var idx []int
+	for i, r := range rs.Indexed() {
+		if r.Begin != i+1 {
+			t.Errorf("Indexed: rule %d is %v", i, r)
+		}
+		idx = append(idx, i)
+	}
+	if err := testutil.DeepEqual([]int{0, 1, 2}, idx); err != nil {
+		t.Errorf("Indexed: %v", err)
+	}
+}
+
+func TestParseSeq(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[{"type":"nop","begin":1},{"type":"nop","begin":2},{"type":"bogus"}]}`
+
+	// Stopping early does not report the error in the unread rule.
+	var got Rules
+	for r, err := range ParseSeq(strings.NewReader(input)) {
+		if err != nil {
+			t.Fatalf("ParseSeq: unexpected error: %v", err)
+		}
+		got = append(got, r)
+		if len(got) == 2 {
+			break
+		}
+	}
+	if err := testutil.DeepEqual(Rules{{Begin: 1}, {Begin: 2}}, got); err != nil {
+		t.Errorf("ParseSeq: %v", err)
+	}
+
+	// Reading to the end reports the error, after the good rules.
+	var n int
+	var last error
+	for _, err := range ParseSeq(strings.NewReader(input)) {
+		n++
+		last = err
+	}
+	if n != 3 || !errors.Is(last, ErrMalformed) {
+		t.Errorf("ParseSeq: got %d values ending with error %v, want 3 ending with %v", n, last, ErrMalformed)
+	}
+}
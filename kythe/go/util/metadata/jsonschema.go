@@ -0,0 +1,110 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+// JSONSchema returns a JSON Schema (draft-07) document describing the JSON
+// encoding of metadata files, for producers not written in Go to validate
+// their output against. The schema is generated from the types and rule type
+// registry used by the decoder, so it describes exactly the fields that Parse
+// understands, including any rule types registered by RegisterRuleType.
+//
+// The schema checks the structure of a file, not the meaning of its rules:
+// for example, it does not require the offsets of a span to be ordered, nor
+// reject unknown fields, which Parse preserves as Rule.Extra.
+func JSONSchema() []byte {
+	var types []string
+	for tag := range ruleDecoders {
+		types = append(types, tag)
+	}
+	sort.Strings(types)
+
+	// Rules are distinguished by type, so the rule types known to the
+	// decoder are enumerated.
+	ruleSchema := objectSchema(reflect.TypeOf(rule{}))
+	ruleSchema["required"] = []string{"type"}
+	ruleSchema["properties"].(map[string]interface{})["type"] = map[string]interface{}{
+		"enum": append([]string(nil), KnownRuleTypes...),
+	}
+
+	schema := map[string]interface{}{
+		"$schema":  "http://json-schema.org/draft-07/schema#",
+		"title":    "Kythe metadata",
+		"type":     "object",
+		"required": []string{keyType},
+		"properties": map[string]interface{}{
+			keyType: map[string]interface{}{"enum": types},
+			keyMeta: map[string]interface{}{
+				"type":  []string{"array", "null"},
+				"items": map[string]interface{}{"$ref": "#/definitions/rule"},
+			},
+			keyOffsetEncoding: map[string]interface{}{"enum": []string{"bytes", RuneOffsetEncoding}},
+			keyEncoding:       map[string]interface{}{"enum": []string{EncodingUTF8Bytes, EncodingUTF16, EncodingRunes}},
+			keyOffsetBase:     map[string]interface{}{"type": "integer", "minimum": 0},
+		},
+		"definitions": map[string]interface{}{
+			"rule":  ruleSchema,
+			"vname": objectSchema(reflect.TypeOf(spb.VName{})),
+		},
+	}
+	bits, _ := json.MarshalIndent(schema, "", "  ") // cannot fail for these values
+	return bits
+}
+
+// objectSchema returns the schema of a JSON object with the fields of struct
+// type t that have JSON names.
+func objectSchema(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		props[name] = fieldSchema(f.Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// fieldSchema returns the schema of the value of a field of Go type t.
+func fieldSchema(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf((*spb.VName)(nil)) {
+		return map[string]interface{}{"$ref": "#/definitions/vname"}
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	}
+	return map[string]interface{}{} // any value
+}
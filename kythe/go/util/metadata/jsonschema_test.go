@@ -0,0 +1,96 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+)
+
+func TestJSONSchema(t *testing.T) {
+	var schema struct {
+		Required   []string
+		Properties map[string]struct {
+			Enum []string
+		}
+		Definitions map[string]struct {
+			Required   []string
+			Properties map[string]struct {
+				Type string
+				Ref  string `json:"$ref"`
+				Enum []string
+			}
+		}
+	}
+	if err := json.Unmarshal(JSONSchema(), &schema); err != nil {
+		t.Fatalf("JSONSchema is not valid JSON: %v", err)
+	}
+
+	if err := testutil.DeepEqual([]string{"type"}, schema.Required); err != nil {
+		t.Errorf("Required header fields: %v", err)
+	}
+	if got := schema.Properties["type"].Enum; !contains(got, MetadataType) {
+		t.Errorf("File types %q do not include %q", got, MetadataType)
+	}
+	for _, key := range []string{"meta", "offset_encoding", "encoding", "offset_base"} {
+		if _, ok := schema.Properties[key]; !ok {
+			t.Errorf("Header field %q is missing", key)
+		}
+	}
+
+	// Every field the decoder understands is described, and no others.
+	rule := schema.Definitions["rule"]
+	var names []string
+	for name := range rule.Properties {
+		names = append(names, name)
+	}
+	var want []string
+	for name := range ruleFields {
+		want = append(want, name)
+	}
+	sort.Strings(names)
+	sort.Strings(want)
+	if err := testutil.DeepEqual(want, names); err != nil {
+		t.Errorf("Rule fields: %v", err)
+	}
+	if err := testutil.DeepEqual(KnownRuleTypes, rule.Properties["type"].Enum); err != nil {
+		t.Errorf("Rule types: %v", err)
+	}
+	for name, want := range map[string]string{"begin": "integer", "edge": "string", "score": "number"} {
+		if got := rule.Properties[name].Type; got != want {
+			t.Errorf("Rule field %q: got type %q, want %q", name, got, want)
+		}
+	}
+	if got := rule.Properties["vname"].Ref; got != "#/definitions/vname" {
+		t.Errorf("Rule vname: got $ref %q", got)
+	}
+	if _, ok := schema.Definitions["vname"].Properties["signature"]; !ok {
+		t.Error("VName field \"signature\" is missing")
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
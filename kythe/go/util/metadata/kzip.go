@@ -0,0 +1,73 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"kythe.io/kythe/go/platform/kzip"
+)
+
+// FromKzipInput parses the metadata file with the given digest, as stored in
+// the files directory of the kzip archive read from r, whose length in bytes
+// is size. The digest is that recorded for the file among the required inputs
+// of a compilation. If the archive has no file with the digest, the error
+// satisfies errors.Is(err, kzip.ErrDigestNotFound).
+func FromKzipInput(r io.ReaderAt, size int64, digest string) (Rules, error) {
+	kr, err := kzip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: reading kzip: %w", err)
+	}
+	bits, err := kr.ReadAll(digest)
+	if errors.Is(err, kzip.ErrDigestNotFound) {
+		return nil, fmt.Errorf("metadata: kzip file %q: %w", digest, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("metadata: reading kzip file %q: %w", digest, err)
+	}
+	rs, err := ParseBytes(bits)
+	if err != nil {
+		return nil, fmt.Errorf("kzip file %q: %w", digest, err)
+	}
+	return rs, nil
+}
+
+// A KzipWriter adds files to a kzip archive, returning the digest of each.
+// It is the part of the interface of a *kzip.Writer used by WriteKzipEntry.
+type KzipWriter interface {
+	AddFile(r io.Reader) (string, error)
+}
+
+var _ KzipWriter = (*kzip.Writer)(nil)
+
+// WriteKzipEntry adds the JSON encoding of rs to the archive written by w as
+// a new file entry, and returns the digest of its contents. The digest may be
+// recorded among the required inputs of a compilation, and read back with
+// FromKzipInput.
+func (rs Rules) WriteKzipEntry(w KzipWriter) (digest string, err error) {
+	bits, err := rs.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	digest, err = w.AddFile(bytes.NewReader(bits))
+	if err != nil {
+		return "", fmt.Errorf("metadata: writing kzip file: %w", err)
+	}
+	return digest, nil
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/platform/kzip"
+	"kythe.io/kythe/go/test/testutil"
+
+	apb "kythe.io/kythe/proto/analysis_go_proto"
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+func TestFromKzipInput(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := kzip.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("Creating kzip writer: %v", err)
+	}
+	good, err := w.AddFile(strings.NewReader(`{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2}]}`))
+	if err != nil {
+		t.Fatalf("Adding metadata: %v", err)
+	}
+	bad, err := w.AddFile(strings.NewReader(`{"type":"kythe0","meta":[{"type":"bogus"}]}`))
+	if err != nil {
+		t.Fatalf("Adding metadata: %v", err)
+	}
+	if _, err := w.AddUnit(&apb.CompilationUnit{}, nil); err != nil {
+		t.Fatalf("Adding unit: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Closing kzip writer: %v", err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+	size := int64(buf.Len())
+
+	rs, err := FromKzipInput(r, size, good)
+	if err != nil {
+		t.Errorf("FromKzipInput(%q) failed: %v", good, err)
+	} else if err := testutil.DeepEqual(Rules{{Begin: 1, End: 2}}, rs); err != nil {
+		t.Errorf("FromKzipInput(%q): %v", good, err)
+	}
+
+	if rs, err := FromKzipInput(r, size, "nonesuch"); !errors.Is(err, kzip.ErrDigestNotFound) {
+		t.Errorf("FromKzipInput of a missing digest: got (%v, %v), want %v", rs, err, kzip.ErrDigestNotFound)
+	}
+	if rs, err := FromKzipInput(r, size, bad); !errors.Is(err, ErrMalformed) {
+		t.Errorf("FromKzipInput of malformed metadata: got (%v, %v), want %v", rs, err, ErrMalformed)
+	}
+	if rs, err := FromKzipInput(strings.NewReader("not a zip"), 9, good); err == nil {
+		t.Errorf("FromKzipInput of a non-kzip: got %v, wanted error", rs)
+	}
+}
+
+func TestWriteKzipEntry(t *testing.T) {
+	rs := Rules{
+		{Begin: 1, End: 2},
+		{
+			Begin:   3,
+			End:     5,
+			EdgeIn:  "/kythe/edge/defines/binding",
+			EdgeOut: "/kythe/edge/generates",
+			Reverse: true,
+			VName:   &spb.VName{Corpus: "c", Language: "protobuf", Signature: "s"},
+		},
+	}
+	var buf bytes.Buffer
+	w, err := kzip.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("Creating kzip writer: %v", err)
+	}
+	digest, err := rs.WriteKzipEntry(w)
+	if err != nil {
+		t.Fatalf("WriteKzipEntry failed: %v", err)
+	}
+	if _, err := w.AddUnit(&apb.CompilationUnit{
+		RequiredInput: []*apb.CompilationUnit_FileInput{{
+			Info: &apb.FileInfo{Path: "foo.pb.go.meta", Digest: digest},
+		}},
+	}, nil); err != nil {
+		t.Fatalf("Adding unit: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Closing kzip writer: %v", err)
+	}
+
+	got, err := FromKzipInput(bytes.NewReader(buf.Bytes()), int64(buf.Len()), digest)
+	if err != nil {
+		t.Fatalf("FromKzipInput(%q) failed: %v", digest, err)
+	}
+	if err := testutil.DeepEqual(rs, got); err != nil {
+		t.Errorf("Round trip through kzip: %v", err)
+	}
+}
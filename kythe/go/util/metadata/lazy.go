@@ -0,0 +1,145 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+// LazyRules are the rules of a metadata file parsed by ParseLazy. The vname of
+// each rule is decoded only when the rule is first accessed with At or Rules,
+// or returned by a query such as Covering, so a consumer that examines the
+// other fields of a rule, for example to skip nop rules, with Peek, does not
+// pay for decoding its vname. A LazyRules is not safe for concurrent use.
+type LazyRules struct {
+	rules  []Rule
+	vnames []json.RawMessage // the undecoded vname of each rule, or nil
+	langs  []string          // the language override of each rule
+}
+
+// ParseLazy parses a single JSON metadata object from r as Parse, but defers
+// decoding the vname of each rule until it is accessed. The rules are checked
+// as by Parse, except that an invalid vname is not reported until its rule is
+// accessed.
+//
+// The saving is modest, since most of the cost of parsing a rule lies in
+// decoding its other fields: for a file of 1000 rules, nine in ten of them a
+// nop rule with a vname, ParseLazy followed by a Peek at each rule performs
+// about 6% fewer allocations than Parse, roughly one fewer per rule skipped
+// (see BenchmarkParseLazy).
+func ParseLazy(r io.Reader) (*LazyRules, error) {
+	d, err := newDecoder(context.Background(), r, nil)
+	if err != nil {
+		return nil, err
+	}
+	lr := new(LazyRules)
+	d.lazy = func(vname json.RawMessage, language string) {
+		lr.vnames = append(lr.vnames, vname)
+		lr.langs = append(lr.langs, language)
+	}
+	if err := d.decode(func(_ int, rule Rule) error {
+		lr.rules = append(lr.rules, rule)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if lr.rules == nil && d.sawMeta {
+		lr.rules = []Rule{}
+	}
+	return lr, nil
+}
+
+// Len returns the number of rules in lr.
+func (lr *LazyRules) Len() int { return len(lr.rules) }
+
+// Peek returns the rule at index i without decoding its vname. The VName
+// field of the result is nil unless the rule has already been accessed with
+// At or Rules.
+func (lr *LazyRules) Peek(i int) Rule { return lr.rules[i] }
+
+// At returns the rule at index i, decoding its vname if that has not been
+// done already. An invalid vname is reported as a *ParseError for the rule.
+func (lr *LazyRules) At(i int) (Rule, error) {
+	if raw := lr.vnames[i]; raw != nil {
+		v := new(spb.VName)
+		if err := json.Unmarshal(raw, v); err != nil {
+			return Rule{}, &ParseError{Index: i, Err: fmt.Errorf("invalid vname: %v", err)}
+		}
+		if lang := lr.langs[i]; lang != "" {
+			v.Language = lang
+		}
+		lr.rules[i].VName = v
+		lr.vnames[i] = nil
+	}
+	return lr.rules[i], nil
+}
+
+// Rules returns all the rules of lr, in order, decoding any vnames that have
+// not been decoded already. The result is the same as that of Parse for the
+// same input.
+func (lr *LazyRules) Rules() (Rules, error) {
+	if lr.rules == nil {
+		return nil, nil
+	}
+	rs := make(Rules, len(lr.rules))
+	for i := range lr.rules {
+		r, err := lr.At(i)
+		if err != nil {
+			return nil, err
+		}
+		rs[i] = r
+	}
+	return rs, nil
+}
+
+// Filter returns the rules of lr for which pred reports true, in order, as
+// Rules.Filter. The predicate is passed each rule as returned by Peek, so only
+// the vnames of the rules that match are decoded. An invalid vname of a
+// matching rule is reported as by At.
+func (lr *LazyRules) Filter(pred func(Rule) bool) (Rules, error) {
+	var out Rules
+	for i := range lr.rules {
+		if !pred(lr.rules[i]) {
+			continue
+		}
+		r, err := lr.At(i)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// WithEdge returns the rules of lr that match or emit edges of the given kind,
+// as Rules.WithEdge, decoding only their vnames.
+func (lr *LazyRules) WithEdge(kind string) (Rules, error) {
+	return lr.Filter(func(r Rule) bool { return r.EdgeIn == kind || r.EdgeOut == kind })
+}
+
+// Covering returns the rules of lr whose spans contain or equal the span from
+// begin to end, as RuleIndex.Covering, decoding only their vnames. Unlike
+// RuleIndex.Covering, it examines every rule, and returns the matches in the
+// order of the file.
+func (lr *LazyRules) Covering(begin, end int) (Rules, error) {
+	return lr.Filter(func(r Rule) bool { return r.Begin <= begin && r.End >= end })
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+	"kythe.io/kythe/go/util/schema/edges"
+)
+
+func TestParseLazy(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+           {"type":"nop","begin":1,"end":2,"vname":{"signature":"n"}},
+           {"type":"anchor_defines","begin":3,"end":5,"edge":"%/kythe/edge/generates",
+            "vname":{"corpus":"c","signature":"s"},"language":"go"},
+           {"type":"anchor_anchor","target_begin":7,"target_end":9,"source_begin":1,"source_end":3,"edge":"/kythe/edge/imputes"},
+           {"type":"anchor_anchor","target_begin":7,"target_end":9,"source_begin":1,"source_end":3,"edge":"/kythe/edge/imputes",
+            "source_vname":{"path":"src.ts"},"vname":{"path":"ignored"},"language":"typescript"}
+        ]}`
+	want, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	lr, err := ParseLazy(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseLazy failed: %v", err)
+	}
+	if n := lr.Len(); n != len(want) {
+		t.Fatalf("Len: got %d, want %d", n, len(want))
+	}
+
+	// Peeking at a rule does not decode its vname, but accessing it does.
+	for i := 0; i < lr.Len(); i++ {
+		peek := lr.Peek(i)
+		if peek.VName != nil {
+			t.Errorf("Peek(%d): got vname %v, want nil", i, peek.VName)
+		}
+		peek.VName = want[i].VName
+		if err := testutil.DeepEqual(want[i], peek); err != nil {
+			t.Errorf("Peek(%d): %v", i, err)
+		}
+	}
+	if r, err := lr.At(1); err != nil {
+		t.Errorf("At(1) failed: %v", err)
+	} else if err := testutil.DeepEqual(want[1], r); err != nil {
+		t.Errorf("At(1): %v", err)
+	}
+	if v := lr.Peek(1).VName; v == nil {
+		t.Error("Peek(1) after At(1): vname not retained")
+	}
+	// The vname of an anchor_anchor rule is its source vname, as for Parse.
+	if r, err := lr.At(3); err != nil {
+		t.Errorf("At(3) failed: %v", err)
+	} else if err := testutil.DeepEqual(want[3], r); err != nil {
+		t.Errorf("At(3): %v", err)
+	}
+	got, err := lr.Rules()
+	if err != nil {
+		t.Fatalf("Rules failed: %v", err)
+	}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("Rules: %v", err)
+	}
+
+	// An invalid vname is reported when its rule is accessed.
+	lr, err = ParseLazy(strings.NewReader(`{"type":"kythe0","meta":[{"type":"nop"},{"type":"nop","vname":{"path":3}}]}`))
+	if err != nil {
+		t.Fatalf("ParseLazy failed: %v", err)
+	}
+	if _, err := lr.At(0); err != nil {
+		t.Errorf("At(0) failed: %v", err)
+	}
+	if _, err := lr.Rules(); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Rules: got error %v, want %v", err, ErrMalformed)
+	}
+
+	// Other problems are reported by ParseLazy itself.
+	if _, err := ParseLazy(strings.NewReader(`{"type":"kythe0","meta":[{"type":"bogus"}]}`)); !errors.Is(err, ErrMalformed) {
+		t.Errorf("ParseLazy: got error %v, want %v", err, ErrMalformed)
+	}
+
+	for _, input := range []string{`{"type":"kythe0"}`, `{"type":"kythe0","meta":[]}`} {
+		want, _ := Parse(strings.NewReader(input))
+		lr, err := ParseLazy(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("ParseLazy %q failed: %v", input, err)
+			continue
+		}
+		if got, err := lr.Rules(); err != nil {
+			t.Errorf("Rules %q failed: %v", input, err)
+		} else if (got == nil) != (want == nil) || len(got) != 0 {
+			t.Errorf("Rules %q: got %#v, want %#v", input, got, want)
+		}
+	}
+}
+
+func TestLazyRulesQueries(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+           {"type":"nop","begin":0,"end":20,"vname":{"signature":"n"}},
+           {"type":"anchor_defines","begin":3,"end":8,"edge":"%/kythe/edge/generates","vname":{"signature":"d"}},
+           {"type":"ref","begin":4,"end":6,"edge":"%/kythe/edge/generates","vname":{"path":3}}
+        ]}`
+	want, err := Parse(strings.NewReader(`{"type":"kythe0","meta":[
+           {"type":"nop","begin":0,"end":20,"vname":{"signature":"n"}},
+           {"type":"anchor_defines","begin":3,"end":8,"edge":"%/kythe/edge/generates","vname":{"signature":"d"}}
+        ]}`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	lr, err := ParseLazy(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseLazy failed: %v", err)
+	}
+
+	// Only the vnames of matching rules are decoded, so the invalid vname of
+	// the last rule is reported only by the queries that match it.
+	if got, err := lr.Covering(4, 8); err != nil {
+		t.Errorf("Covering failed: %v", err)
+	} else if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("Covering: %v", err)
+	}
+	if got, err := lr.WithEdge(edges.DefinesBinding); err != nil {
+		t.Errorf("WithEdge failed: %v", err)
+	} else if err := testutil.DeepEqual(want[1:], got); err != nil {
+		t.Errorf("WithEdge: %v", err)
+	}
+	if lr.Peek(2).VName != nil {
+		t.Error("Queries decoded the vname of a rule that did not match")
+	}
+	if got, err := lr.Covering(30, 40); err != nil || got != nil {
+		t.Errorf("Covering with no matches: got %v, %v, want nil, nil", got, err)
+	}
+	if _, err := lr.WithEdge(edges.Ref); !errors.Is(err, ErrMalformed) {
+		t.Errorf("WithEdge: got error %v, want %v", err, ErrMalformed)
+	}
+}
+
+// BenchmarkParseLazy compares Parse with ParseLazy on nop-heavy metadata, in
+// which nine rules in ten are nop rules with vnames that the consumer skips.
+func BenchmarkParseLazy(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString(`{"type":"kythe0","meta":[`)
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		typ := "nop"
+		if i%10 == 0 {
+			typ = "anchor_defines"
+		}
+		fmt.Fprintf(&sb, `{"type":%q,"begin":%d,"end":%d,"edge":"%%/kythe/edge/generates",`+
+			`"vname":{"corpus":"c","path":"a.proto","signature":"4.%d"}}`, typ, i*10, i*10+5, i)
+	}
+	sb.WriteString(`]}`)
+	input := sb.String()
+
+	b.Run("Parse", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := Parse(strings.NewReader(input)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ParseLazy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			lr, err := ParseLazy(strings.NewReader(input))
+			if err != nil {
+				b.Fatal(err)
+			}
+			for j := 0; j < lr.Len(); j++ {
+				if lr.Peek(j).EdgeIn == "" {
+					continue // skip nop rules
+				}
+				if _, err := lr.At(j); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ParseLimits bound the resources used by ParseLimited to decode an input.
+// A limit that is zero or negative is not enforced. A nil *ParseLimits
+// enforces no limits.
+type ParseLimits struct {
+	// The maximum number of rules in the meta array.
+	MaxRules int
+
+	// The maximum total length of the input, in bytes.
+	MaxBytes int64
+
+	// The maximum nesting depth of the objects and arrays in a single rule,
+	// counting the rule object itself as depth 1. This bounds the nesting of
+	// values such as the vname and any extra fields of the rule.
+	MaxDepth int
+}
+
+func (l *ParseLimits) maxRules() int {
+	if l == nil {
+		return 0
+	}
+	return l.MaxRules
+}
+
+func (l *ParseLimits) maxBytes() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.MaxBytes
+}
+
+func (l *ParseLimits) maxDepth() int {
+	if l == nil {
+		return 0
+	}
+	return l.MaxDepth
+}
+
+// A LimitError reports that an input exceeded one of the ParseLimits. It is
+// returned as the underlying error of a *ParseError.
+type LimitError struct {
+	Limit string // the name of the limit, e.g., "MaxRules"
+	Max   int64  // the value of the limit
+}
+
+// Error satisfies the error interface.
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("input exceeds %s limit of %d", e.Limit, e.Max)
+}
+
+// ParseLimited parses a single JSON metadata object from r as Parse, but
+// stops with an error as soon as the input exceeds any of the given limits,
+// so that inputs from untrusted sources can be decoded safely. An input that
+// exceeds a limit produces no rules; the error is a *ParseError whose
+// underlying error is a *LimitError.
+func ParseLimited(r io.Reader, limits *ParseLimits) (Rules, error) {
+	var lr *limitReader
+	if max := limits.maxBytes(); max > 0 {
+		lr = &limitReader{r: r, n: max}
+		r = lr
+	}
+	d, err := newDecoder(context.Background(), r, nil)
+	if err == nil {
+		d.limits = limits
+		var rs Rules
+		if err = d.decode(func(_ int, rule Rule) error {
+			rs = append(rs, rule)
+			return nil
+		}); err == nil {
+			if rs == nil && d.sawMeta {
+				rs = Rules{}
+			}
+			return rs, nil
+		}
+	}
+	if lr != nil && lr.exceeded {
+		return nil, &ParseError{Offset: limits.MaxBytes, Index: -1, Err: &LimitError{Limit: "MaxBytes", Max: limits.MaxBytes}}
+	}
+	return nil, err
+}
+
+var errInputTooLong = errors.New("input too long")
+
+// A limitReader reads from r until more than n bytes have been read, after
+// which it reports an error.
+type limitReader struct {
+	r        io.Reader
+	n        int64 // bytes remaining before the limit is exceeded
+	exceeded bool
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, errInputTooLong
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1] // read one byte past the limit to detect overflow
+	}
+	n, err := l.r.Read(p)
+	if l.n -= int64(n); l.n < 0 {
+		l.exceeded = true
+		return 0, errInputTooLong
+	}
+	return n, err
+}
+
+// jsonDepth returns the maximum nesting depth of objects and arrays in the
+// encoded JSON value data, which is assumed to be well-formed.
+func jsonDepth(data []byte) int {
+	var depth, max int
+	var inString, escaped bool
+	for _, c := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			if depth++; depth > max {
+				max = depth
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return max
+}
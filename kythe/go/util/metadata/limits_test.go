@@ -0,0 +1,85 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseLimited(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+{"type":"nop","begin":1,"end":2},
+{"type":"nop","begin":3,"end":4,"vname":{"corpus":"c"}},
+{"type":"nop","begin":5,"end":6,"nested":[[{"a":[1]}]]}
+]}`
+	tests := []struct {
+		limits *ParseLimits
+		limit  string // the limit exceeded, or "" for success
+	}{
+		{nil, ""},
+		{&ParseLimits{}, ""},
+		{&ParseLimits{MaxRules: 3, MaxBytes: int64(len(input)), MaxDepth: 5}, ""},
+		{&ParseLimits{MaxRules: 2}, "MaxRules"},
+		{&ParseLimits{MaxBytes: int64(len(input)) - 1}, "MaxBytes"},
+		{&ParseLimits{MaxBytes: 10}, "MaxBytes"},
+		{&ParseLimits{MaxDepth: 4}, "MaxDepth"},
+		{&ParseLimits{MaxDepth: 1}, "MaxDepth"},
+	}
+	for _, test := range tests {
+		rs, err := ParseLimited(strings.NewReader(input), test.limits)
+		if test.limit == "" {
+			if err != nil {
+				t.Errorf("ParseLimited(%+v): unexpected error: %v", test.limits, err)
+			} else if len(rs) != 3 {
+				t.Errorf("ParseLimited(%+v): got %d rules, want 3", test.limits, len(rs))
+			}
+			continue
+		}
+		var lerr *LimitError
+		if !errors.As(err, &lerr) {
+			t.Errorf("ParseLimited(%+v): got %v, want a *LimitError", test.limits, err)
+		} else if lerr.Limit != test.limit {
+			t.Errorf("ParseLimited(%+v): exceeded %s, want %s", test.limits, lerr.Limit, test.limit)
+		}
+		if !errors.Is(err, ErrMalformed) {
+			t.Errorf("ParseLimited(%+v): error %v is not ErrMalformed", test.limits, err)
+		}
+		if rs != nil {
+			t.Errorf("ParseLimited(%+v): got rules %v with error", test.limits, rs)
+		}
+	}
+}
+
+func TestJSONDepth(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{`1`, 0},
+		{`"[{"`, 0},
+		{`{}`, 1},
+		{`{"a":[1,{"b":"\"]"}]}`, 3},
+		{`[[],[[]]]`, 3},
+	}
+	for _, test := range tests {
+		if got := jsonDepth([]byte(test.input)); got != test.want {
+			t.Errorf("jsonDepth(%s): got %d, want %d", test.input, got, test.want)
+		}
+	}
+}
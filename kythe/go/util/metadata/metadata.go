@@ -20,131 +20,1791 @@
 package metadata // import "kythe.io/kythe/go/util/metadata"
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"path"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
 	"kythe.io/kythe/go/util/schema/edges"
 
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+
 	protopb "github.com/golang/protobuf/protoc-gen-go/descriptor"
 	spb "kythe.io/kythe/proto/storage_go_proto"
 )
 
 // TODO(fromberger): Add a link to the format documentation here.
 
+// RuneOffsetEncoding is the value of the offset_encoding header of a metadata
+// file whose offsets count runes rather than bytes.
+const RuneOffsetEncoding = "runes"
+
+// Offset encodings that may be declared by the encoding header of a metadata
+// file; see ParseResult.Normalize.
+const (
+	EncodingUTF8Bytes = "utf-8-bytes" // bytes of UTF-8 text; the default
+	EncodingUTF16     = "utf-16"      // UTF-16 code units, as in JavaScript
+	EncodingRunes     = "runes"       // runes; see Rule.RuneOffsets
+)
+
 // Rules are a collection of metadata rules.
 type Rules []Rule
 
 // MarshalJSON encodes the specified rule set as a JSON file.
 func (rs Rules) MarshalJSON() ([]byte, error) {
+	enc, err := rs.offsetEncoding()
+	if err != nil {
+		return nil, err
+	}
 	f := file{
-		Type: fileType,
-		Meta: make([]rule, len(rs)),
+		Type:           MetadataType,
+		OffsetEncoding: enc,
+		Meta:           make([]rule, len(rs)),
 	}
 	for i, r := range rs {
-		kind := r.EdgeOut
-		if r.Reverse {
-			kind = edges.Mirror(kind)
-		}
-		rtype := "nop"
-		if r.EdgeIn == edges.DefinesBinding {
-			rtype = "anchor_defines"
+		f.Meta[i] = encodeRule(r)
+	}
+	return json.Marshal(f)
+}
+
+// MarshalIndent encodes rs as a JSON file as MarshalJSON, but with each
+// element on a new line beginning with prefix and indented by copies of indent
+// according to its nesting, as json.MarshalIndent. An empty rule set encodes
+// as a file with no meta array.
+func (rs Rules) MarshalIndent(prefix, indent string) ([]byte, error) {
+	return json.MarshalIndent(rs, prefix, indent)
+}
+
+// MarshalCanonical encodes rs as a JSON file in a canonical form, so that
+// equivalent rule sets produced by different versions of a generator encode
+// identically. The rules are written in the order imposed by Rules.Sort, and
+// the keys of every object, including vnames, are written in lexicographic
+// order. The receiver is not modified.
+func (rs Rules) MarshalCanonical() ([]byte, error) {
+	sorted := append(Rules(nil), rs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return ruleLess(sorted[i], sorted[j]) })
+	bits, err := json.Marshal(sorted)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decoding into generic values and re-encoding them orders the keys of
+	// each object, since encoding/json sorts map keys.
+	dec := json.NewDecoder(bytes.NewReader(bits))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// encodeRule converts r into its encoded format.
+func encodeRule(r Rule) rule {
+	kind, reversed := r.effectiveBase()
+	if reversed {
+		kind = edges.Mirror(kind)
+	}
+	rtype := "nop"
+	switch r.EdgeIn {
+	case edges.DefinesBinding:
+		rtype = "anchor_defines"
+	case edges.Ref:
+		rtype = "ref"
+	}
+	meta := rule{
+		Type:  rtype,
+		Begin: int64(r.Begin),
+		End:   int64(r.End),
+		VName: r.VName,
+		Edge:  kind,
+		Extra: r.Extra,
+
+		Semantic: r.Semantic.String(),
+		Ordinal:  r.Ordinal,
+		Score:    r.Score,
+	}
+	if t := r.TargetSpan; t != nil {
+		// The edge of an anchor_anchor rule is required, and is written from
+		// the source anchor; see the decoder in registry.go.
+		kind, reversed := r.effectiveBase()
+		if kind == "" {
+			kind = AnchorAnchorEdge
 		}
-		f.Meta[i] = rule{
-			Type:  rtype,
-			Begin: r.Begin,
-			End:   r.End,
-			VName: r.VName,
-			Edge:  kind,
+		if !reversed {
+			kind = edges.Mirror(kind)
 		}
+		meta.Type = "anchor_anchor"
+		meta.Edge = kind
+		meta.VName, meta.SourceVName = nil, r.VName
+		begin, end := meta.Begin, meta.End
+		meta.TargetBegin, meta.TargetEnd = &begin, &end
+		meta.Begin, meta.End = 0, 0
+		meta.SourceBegin = &t.Begin
+		meta.SourceEnd = &t.End
 	}
-	return json.Marshal(f)
+	if p := r.Position; p != nil {
+		meta.BeginLine, meta.BeginCol = &p.BeginLine, &p.BeginCol
+		meta.EndLine, meta.EndCol = &p.EndLine, &p.EndCol
+		meta.ColumnUnits = p.Units.String()
+	}
+	meta.noBegin, meta.noEnd = r.WholeFile, r.WholeFile
+	if r.TargetSpan != nil {
+		meta.noBegin, meta.noEnd = true, true
+	}
+	return meta
 }
 
 // A Rule denotes a single metadata rule, associating type linkage information
 // for an anchor spanning a given range of text.
 type Rule struct {
 	// The Begin and End fields represent a half-closed interval of byte
-	// positions to match. Begin is inclusive, End is exclusive.
+	// positions to match. Begin is inclusive, End is exclusive. Offsets are
+	// encoded as 64-bit values, but are decoded into int; see Begin64 and
+	// End64.
 	Begin, End int
 
 	EdgeIn  string     // edge kind to match over the anchor spanned
 	EdgeOut string     // outbound edge kind to emit
 	VName   *spb.VName // the vname to create an edge to or from
 	Reverse bool       // whether to draw to vname (false) or from it (true)
+
+	// If non-nil, the rule links the anchor it matches to another anchor
+	// (an anchor_anchor rule), rather than to a semantic node. The target
+	// anchor has this span in the file denoted by VName.
+	TargetSpan *Span
+
+	// How the generated entity relates to the source entity, as described by
+	// the annotation the rule was derived from.
+	Semantic Semantic
+
+	// If non-nil, the ordinal of the outbound edge, as in param.N.
+	Ordinal *int
+
+	// If non-nil, the producer's confidence in the rule, from 0 (none) to 1
+	// (certain), for heuristic producers. See Rules.FilterByScore.
+	Score *float64
+
+	// If true, Begin and End count runes (Unicode code points) rather than
+	// bytes, as declared by the offset_encoding header of the file the rule
+	// was read from. Convert them with Rules.RunesToBytes before the rule is
+	// applied.
+	RuneOffsets bool
+
+	// If non-nil, the rule's span was given as lines and columns rather than
+	// byte offsets, and Begin and End are not meaningful until the position
+	// has been resolved against the file by Rules.ResolveLineColumns.
+	Position *LineSpan
+
+	// If true, the rule applies to the generated file as a whole rather than
+	// to an anchor, and Begin and End are zero. Such a rule is encoded by
+	// omitting both offsets; see Rule.Apply for its meaning.
+	WholeFile bool
+
+	// Any fields of the encoded rule not understood by this package, keyed by
+	// their JSON field name. These are preserved when the rule is encoded, so
+	// that rules written by newer producers can be passed through.
+	Extra map[string]json.RawMessage
+}
+
+// Begin64 returns the Begin offset of r as an int64, for callers that store
+// offsets in a platform-independent type.
+func (r Rule) Begin64() int64 { return int64(r.Begin) }
+
+// End64 returns the End offset of r as an int64, for callers that store
+// offsets in a platform-independent type.
+func (r Rule) End64() int64 { return int64(r.End) }
+
+// EdgeKind returns the outbound edge kind of r, including its ordinal if it
+// has one. This is the kind of the edge emitted by Apply. Param ordinals are
+// formatted by edges.ParamIndex; other kinds use the same "kind.N" form.
+func (r Rule) EdgeKind() string {
+	switch {
+	case r.Ordinal == nil:
+		return r.EdgeOut
+	case r.EdgeOut == edges.Param:
+		return edges.ParamIndex(*r.Ordinal)
+	}
+	return r.EdgeOut + "." + strconv.Itoa(*r.Ordinal)
+}
+
+// EffectiveEdge returns the kind of the edge drawn by r, including its ordinal
+// if it has one, and reports whether the edge is drawn from the vname of r to
+// the anchor rather than the other way. The kind is always a forward kind: as
+// in the encoded format, a leading "%" on EdgeOut marks the edge as reversed,
+// the same as setting Reverse. It returns "" for a rule with no outbound edge.
+func (r Rule) EffectiveEdge() (kind string, reversed bool) {
+	r.EdgeOut, reversed = r.effectiveBase()
+	return r.EdgeKind(), reversed
+}
+
+// effectiveBase returns the forward kind of the outbound edge of r, without
+// its ordinal, and reports whether it is reversed, as EffectiveEdge.
+func (r Rule) effectiveBase() (kind string, reversed bool) {
+	return edges.Canonical(r.EdgeOut), r.Reverse || edges.IsReverse(r.EdgeOut)
+}
+
+// String returns a compact human-readable representation of r, for example:
+//
+//	[179,182) defines/binding <- generates @ gcorp:gpath:gsig
+//
+// The arrow points from the source of the emitted edge to its target, where
+// the right-hand side denotes r.VName. A whole-file rule shows "[file]" in
+// place of its span.
+func (r Rule) String() string {
+	var sb strings.Builder
+	if r.WholeFile {
+		sb.WriteString("[file] ")
+	} else {
+		fmt.Fprintf(&sb, "[%d,%d) ", r.Begin, r.End)
+	}
+	if r.EdgeIn == "" {
+		sb.WriteString("nop")
+	} else {
+		sb.WriteString(shortEdge(r.EdgeIn))
+	}
+	if kind, reversed := r.EffectiveEdge(); kind != "" {
+		if reversed {
+			sb.WriteString(" <- ")
+		} else {
+			sb.WriteString(" -> ")
+		}
+		sb.WriteString(shortEdge(kind))
+	}
+	if t := r.TargetSpan; t != nil {
+		fmt.Fprintf(&sb, " [%d,%d)", t.Begin, t.End)
+	}
+	if v := r.VName; v != nil {
+		fmt.Fprintf(&sb, " @ %s:%s:%s", v.Corpus, v.Path, v.Signature)
+		if v.Language != "" {
+			fmt.Fprintf(&sb, " (%s)", v.Language)
+		}
+	}
+	return sb.String()
+}
+
+// shortEdge returns kind without the common Kythe edge prefix.
+func shortEdge(kind string) string { return strings.TrimPrefix(edges.Canonical(kind), edges.Prefix) }
+
+// String returns a summary of rs giving the number of rules and the number of
+// rules having each outbound edge kind, for example:
+//
+//	3 rules: generates=2, nop=1
+func (rs Rules) String() string {
+	count := make(map[string]int)
+	for _, r := range rs {
+		kind := "nop"
+		if r.EdgeIn != "" {
+			kind = shortEdge(r.EdgeKind())
+		}
+		count[kind]++
+	}
+	kinds := make([]string, 0, len(count))
+	for kind := range count {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d rules", len(rs))
+	for i, kind := range kinds {
+		if i == 0 {
+			sb.WriteString(": ")
+		} else {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "%s=%d", kind, count[kind])
+	}
+	return sb.String()
+}
+
+// Sort sorts rs in place by ascending Begin offset, then by End offset, then
+// by outbound edge kind. Ties are broken by the remaining fields, so the
+// result is deterministic regardless of the input order. Rules with zero
+// offsets, such as nop rules, sort first.
+func (rs Rules) Sort() { sort.Slice(rs, func(i, j int) bool { return ruleLess(rs[i], rs[j]) }) }
+
+// Clone returns a deep copy of rs, which can be modified without affecting
+// rs. The vname, target span, ordinal, position, and extra fields of each rule
+// are copied.
+func (rs Rules) Clone() Rules {
+	if rs == nil {
+		return nil
+	}
+	out := make(Rules, len(rs))
+	for i, r := range rs {
+		out[i] = r.clone()
+	}
+	return out
+}
+
+// Canonicalize returns a copy of rs in a canonical form, so that rule sets
+// that differ only in how they were written compare equal. In the result:
+//
+//   - Leading and trailing whitespace is removed from each vname field.
+//   - An anchor_anchor rule without an outbound edge kind has the implied
+//     AnchorAnchorEdge, and an empty Extra map is nil.
+//   - The rules are sorted as Rules.Sort, with any remaining ties broken by
+//     comparing the encodings of the rules, so the order does not depend on
+//     the order of rs.
+//
+// The receiver is not modified.
+func (rs Rules) Canonicalize() Rules {
+	if rs == nil {
+		return nil
+	}
+	ks := canonicalRules(rs)
+	out := make(Rules, len(ks))
+	for i, k := range ks {
+		out[i] = k.rule
+	}
+	return out
+}
+
+// A keyedRule is a rule in canonical form along with its encoding, which is
+// equal for two rules exactly when they are equal in canonical form.
+type keyedRule struct {
+	rule Rule
+	key  string
+}
+
+// canonicalRules returns the canonical forms of the rules of rs, with their
+// keys, in the order given by Canonicalize.
+func canonicalRules(rs Rules) []keyedRule {
+	ks := make([]keyedRule, len(rs))
+	for i, r := range rs {
+		r = r.clone()
+		if v := r.VName; v != nil {
+			v.Corpus = strings.TrimSpace(v.Corpus)
+			v.Root = strings.TrimSpace(v.Root)
+			v.Path = strings.TrimSpace(v.Path)
+			v.Language = strings.TrimSpace(v.Language)
+			v.Signature = strings.TrimSpace(v.Signature)
+		}
+		if r.TargetSpan != nil && r.EdgeOut == "" {
+			r.EdgeOut = AnchorAnchorEdge
+		}
+		if len(r.Extra) == 0 {
+			r.Extra = nil
+		}
+		key, _ := json.Marshal(encodeRule(r)) // errors only for bad Extra values
+		ks[i] = keyedRule{rule: r, key: string(key)}
+	}
+	sort.Slice(ks, func(i, j int) bool {
+		if ruleLess(ks[i].rule, ks[j].rule) {
+			return true
+		} else if ruleLess(ks[j].rule, ks[i].rule) {
+			return false
+		}
+		return ks[i].key < ks[j].key
+	})
+	return ks
 }
 
+// clone returns a deep copy of r.
+func (r Rule) clone() Rule {
+	if r.VName != nil {
+		r.VName = proto.Clone(r.VName).(*spb.VName)
+	}
+	if r.TargetSpan != nil {
+		t := *r.TargetSpan
+		r.TargetSpan = &t
+	}
+	if r.Ordinal != nil {
+		n := *r.Ordinal
+		r.Ordinal = &n
+	}
+	if r.Position != nil {
+		p := *r.Position
+		r.Position = &p
+	}
+	if r.Score != nil {
+		v := *r.Score
+		r.Score = &v
+	}
+	if r.Extra != nil {
+		extra := make(map[string]json.RawMessage, len(r.Extra))
+		for key, val := range r.Extra {
+			extra[key] = append(json.RawMessage(nil), val...)
+		}
+		r.Extra = extra
+	}
+	return r
+}
+
+// Equal reports whether r and o have the same value. Vnames are compared as
+// messages by proto.Equal, so distinct but equal vnames are equal, and a nil
+// vname is equal to an empty one. Likewise, a nil Extra map is equal to an
+// empty one. The other pointer fields are compared by the values they point
+// to, but nil is equal only to nil, since an unset ordinal, score, target
+// span, or position means something different from a zero one. Fields are
+// compared as they are, so two spellings of the same edge, such as a reverse
+// EdgeOut and Reverse, are not equal; compare the results of Canonicalize to
+// ignore such differences.
+func (r Rule) Equal(o Rule) bool {
+	if r.Begin != o.Begin || r.End != o.End || r.EdgeIn != o.EdgeIn || r.EdgeOut != o.EdgeOut ||
+		r.Reverse != o.Reverse || r.Semantic != o.Semantic || r.RuneOffsets != o.RuneOffsets || r.WholeFile != o.WholeFile {
+		return false
+	}
+	av, bv := r.VName, o.VName
+	if av == nil {
+		av = new(spb.VName)
+	}
+	if bv == nil {
+		bv = new(spb.VName)
+	}
+	if !proto.Equal(av, bv) {
+		return false
+	}
+	if (r.TargetSpan == nil) != (o.TargetSpan == nil) || r.TargetSpan != nil && *r.TargetSpan != *o.TargetSpan {
+		return false
+	} else if (r.Ordinal == nil) != (o.Ordinal == nil) || r.Ordinal != nil && *r.Ordinal != *o.Ordinal {
+		return false
+	} else if (r.Score == nil) != (o.Score == nil) || r.Score != nil && *r.Score != *o.Score {
+		return false
+	} else if (r.Position == nil) != (o.Position == nil) || r.Position != nil && *r.Position != *o.Position {
+		return false
+	}
+	if len(r.Extra) != len(o.Extra) {
+		return false
+	}
+	for key, val := range r.Extra {
+		if other, ok := o.Extra[key]; !ok || !bytes.Equal(val, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// ruleLess reports whether a precedes b in the order imposed by Rules.Sort.
+// The order is total: ruleLess reports false both ways only for rules that
+// are identical in every field, except that a nil Extra map is the same as an
+// empty one.
+func ruleLess(a, b Rule) bool {
+	if a.Begin != b.Begin {
+		return a.Begin < b.Begin
+	} else if a.End != b.End {
+		return a.End < b.End
+	} else if a.WholeFile != b.WholeFile {
+		return a.WholeFile
+	} else if a.EdgeOut != b.EdgeOut {
+		return a.EdgeOut < b.EdgeOut
+	} else if a.EdgeIn != b.EdgeIn {
+		return a.EdgeIn < b.EdgeIn
+	} else if a.Reverse != b.Reverse {
+		return !a.Reverse
+	} else if vnameLess(a.VName, b.VName) || vnameLess(b.VName, a.VName) {
+		return vnameLess(a.VName, b.VName)
+	} else if a.Semantic != b.Semantic {
+		return a.Semantic < b.Semantic
+	} else if a.RuneOffsets != b.RuneOffsets {
+		return !a.RuneOffsets
+	}
+	if c := compareInts(a.Ordinal, b.Ordinal); c != 0 {
+		return c < 0
+	} else if c := compareSpans(a.TargetSpan, b.TargetSpan); c != 0 {
+		return c < 0
+	} else if c := comparePositions(a.Position, b.Position); c != 0 {
+		return c < 0
+	} else if c := compareFloats(a.Score, b.Score); c != 0 {
+		return c < 0
+	}
+	return compareExtra(a.Extra, b.Extra) < 0
+}
+
+// compareFloats compares the values of a and b, with nil first.
+func compareFloats(a, b *float64) int {
+	switch {
+	case a == nil || b == nil:
+		return compareBools(a != nil, b != nil)
+	case *a < *b:
+		return -1
+	case *a > *b:
+		return 1
+	}
+	return 0
+}
+
+// compareInts compares the values of a and b, with nil first.
+func compareInts(a, b *int) int {
+	switch {
+	case a == nil || b == nil:
+		return compareBools(a != nil, b != nil)
+	case *a < *b:
+		return -1
+	case *a > *b:
+		return 1
+	}
+	return 0
+}
+
+// compareBools compares a and b, with false first.
+func compareBools(a, b bool) int {
+	if a == b {
+		return 0
+	} else if a {
+		return 1
+	}
+	return -1
+}
+
+// compareSpans compares a and b by their offsets, with nil first.
+func compareSpans(a, b *Span) int {
+	if a == nil || b == nil {
+		return compareBools(a != nil, b != nil)
+	}
+	return compareIntSlices([]int{a.Begin, a.End}, []int{b.Begin, b.End})
+}
+
+// comparePositions compares a and b by their lines, columns, and units, with
+// nil first.
+func comparePositions(a, b *LineSpan) int {
+	if a == nil || b == nil {
+		return compareBools(a != nil, b != nil)
+	}
+	return compareIntSlices(
+		[]int{a.BeginLine, a.BeginCol, a.EndLine, a.EndCol, int(a.Units)},
+		[]int{b.BeginLine, b.BeginCol, b.EndLine, b.EndCol, int(b.Units)})
+}
+
+// compareIntSlices compares a and b, which have the same length,
+// lexicographically.
+func compareIntSlices(a, b []int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// compareExtra compares a and b as sorted lists of key-value pairs, compared
+// lexicographically by key and then by the bytes of the value.
+func compareExtra(a, b map[string]json.RawMessage) int {
+	ak, bk := sortedKeys(a), sortedKeys(b)
+	for i := 0; i < len(ak) && i < len(bk); i++ {
+		if ak[i] != bk[i] {
+			return strings.Compare(ak[i], bk[i])
+		} else if c := bytes.Compare(a[ak[i]], b[bk[i]]); c != 0 {
+			return c
+		}
+	}
+	return len(ak) - len(bk)
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// vnameLess orders vnames lexicographically by field, with nil first.
+func vnameLess(a, b *spb.VName) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+	for _, f := range [][2]string{
+		{a.Corpus, b.Corpus},
+		{a.Root, b.Root},
+		{a.Path, b.Path},
+		{a.Language, b.Language},
+		{a.Signature, b.Signature},
+	} {
+		if f[0] != f[1] {
+			return f[0] < f[1]
+		}
+	}
+	return false
+}
+
+// A Span is a half-open interval of byte offsets. Begin is inclusive, End is
+// exclusive.
+type Span struct {
+	Begin, End int
+}
+
+// A Semantic describes how a generated entity relates to the source entity
+// it was generated from. The values correspond to those of the semantic field
+// of a GeneratedCodeInfo annotation.
+type Semantic int
+
+// The defined values of Semantic.
+const (
+	SemanticNone  Semantic = iota // the generated entity is generated from the source entity
+	SemanticSet                   // the generated entity sets the source entity
+	SemanticAlias                 // the generated entity is an alias of the source entity
+)
+
+var semanticNames = map[Semantic]string{
+	SemanticSet:   "set",
+	SemanticAlias: "alias",
+}
+
+// String returns the encoded name of the semantic, or "" for SemanticNone.
+func (s Semantic) String() string { return semanticNames[s] }
+
+// parseSemantic returns the Semantic whose encoded name is s.
+func parseSemantic(s string) (Semantic, error) {
+	if s == "" {
+		return SemanticNone, nil
+	}
+	for sem, name := range semanticNames {
+		if s == name {
+			return sem, nil
+		}
+	}
+	return SemanticNone, fmt.Errorf("unknown semantic %q", s)
+}
+
+// AnchorAnchorEdge is the outbound edge kind implied for anchor_anchor rules
+// that do not specify one. The encoded format requires an edge, so it is
+// written explicitly by MarshalJSON.
+var AnchorAnchorEdge = edges.Prefix + "imputes"
+
 // The types below are intermediate structures used for JSON marshaling.
 
-const fileType = "kythe0" // protocol marker
+// MetadataType is the type tag written by this package to identify the
+// format of a metadata object, as the value of its "type" field.
+const MetadataType = "kythe0"
+
+// ValidType reports whether s is a type tag accepted by Parse. Besides
+// MetadataType, this includes "kythe1", which is reserved for extensions.
+func ValidType(s string) bool {
+	_, ok := ruleDecoders[s]
+	return ok
+}
+
+// ruleDecoders maps each supported protocol marker to the function that
+// converts its encoded rules. All versions produce the same Rule values, so
+// callers do not need to know which version a file was written in.
+var ruleDecoders = map[string]func(rule) (Rule, error){
+	MetadataType: decodeKythe0,
+	"kythe1":     decodeKythe1,
+}
 
 // A file represents an encoded set of rules in JSON notation.
 type file struct {
-	Type string `json:"type"` // required: must equal fileType
-	Meta []rule `json:"meta,omitempty"`
+	Type           string `json:"type"`                      // required: must be a ValidType
+	OffsetEncoding string `json:"offset_encoding,omitempty"` // "bytes" if empty
+	Meta           []rule `json:"meta,omitempty"`
+}
+
+// The keys of the top-level object of a metadata file that are understood by
+// the decoder; others are ignored.
+const (
+	keyType           = "type"
+	keyMeta           = "meta"
+	keyOffsetEncoding = "offset_encoding"
+	keyEncoding       = "encoding"
+	keyOffsetBase     = "offset_base"
+)
+
+// offsetEncoding returns the offset_encoding header for rs, which is empty
+// unless every rule has rune offsets. The offsets of a file share one
+// encoding, so it is an error for rs to mix rune and byte offsets.
+func (rs Rules) offsetEncoding() (string, error) {
+	var runes int
+	for _, r := range rs {
+		if r.RuneOffsets {
+			runes++
+		}
+	}
+	switch runes {
+	case 0:
+		return "", nil
+	case len(rs):
+		return RuneOffsetEncoding, nil
+	}
+	return "", errors.New("metadata: rules mix rune and byte offsets")
+}
+
+// runeOffsets reports whether enc, the value of an offset_encoding header,
+// declares rune offsets.
+func runeOffsets(enc string) (bool, error) {
+	switch enc {
+	case "", "bytes":
+		return false, nil
+	case RuneOffsetEncoding:
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown offset encoding %q", enc)
 }
 
 // A rule is the encoded format of a single rule.
 type rule struct {
 	Type  string     `json:"type"`
-	Begin int        `json:"begin"`
-	End   int        `json:"end"`
+	Begin int64      `json:"begin"` // see checkOffset
+	End   int64      `json:"end"`
 	Edge  string     `json:"edge,omitempty"`
 	VName *spb.VName `json:"vname,omitempty"`
+
+	// For anchor_anchor rules, the span of the generated anchor, and the
+	// vname and span of the anchor it is linked to, as in MappingRule.
+	TargetBegin *int64     `json:"target_begin,omitempty"`
+	TargetEnd   *int64     `json:"target_end,omitempty"`
+	SourceVName *spb.VName `json:"source_vname,omitempty"`
+	SourceBegin *int       `json:"source_begin,omitempty"`
+	SourceEnd   *int       `json:"source_end,omitempty"`
+
+	Semantic string   `json:"semantic,omitempty"`
+	Ordinal  *int     `json:"ordinal,omitempty"`
+	Score    *float64 `json:"score,omitempty"`
+
+	// If set, overrides the language of the vname.
+	Language string `json:"language,omitempty"`
+
+	// An alternative to begin and end for hand-written metadata.
+	BeginLine *int `json:"begin_line,omitempty"`
+	BeginCol  *int `json:"begin_col,omitempty"`
+	EndLine   *int `json:"end_line,omitempty"`
+	EndCol    *int `json:"end_col,omitempty"`
+
+	// The units of begin_col and end_col, if not bytes; see ColumnUnit.
+	ColumnUnits string `json:"column_units,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"` // unrecognized fields
+
+	// For rules of a type registered by RegisterRuleType, the complete
+	// encoded rule.
+	raw json.RawMessage
+
+	// Whether the begin and end offsets were omitted from the encoding.
+	noBegin, noEnd bool
+
+	// If lazy is set before decoding, the vname (for an anchor_anchor rule,
+	// the source vname) is not decoded, and its encoding is kept in rawVName
+	// instead; see ParseLazy.
+	lazy     bool
+	rawVName json.RawMessage
+}
+
+// ruleFields is the set of JSON field names decoded into a rule.
+var ruleFields = make(map[string]bool)
+
+func init() {
+	t := reflect.TypeOf(rule{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			ruleFields[name] = true
+		}
+	}
+}
+
+// ruleAlias has the same fields as rule but not its methods, for use in
+// marshaling the known fields.
+type ruleAlias rule
+
+// MarshalJSON encodes the known fields of meta along with any extra fields.
+func (meta rule) MarshalJSON() ([]byte, error) {
+	bits, err := json.Marshal(ruleAlias(meta))
+	if err != nil || (len(meta.Extra) == 0 && !meta.noBegin && !meta.noEnd) {
+		return bits, err
+	}
+	fields := make(map[string]json.RawMessage)
+	for key, val := range meta.Extra {
+		fields[key] = val
+	}
+	if err := json.Unmarshal(bits, &fields); err != nil {
+		return nil, err
+	}
+	if meta.noBegin {
+		delete(fields, "begin")
+	}
+	if meta.noEnd {
+		delete(fields, "end")
+	}
+	return json.Marshal(fields)
+}
+
+// lazyRuleAlias decodes the fields of a rule other than its vnames, which
+// shadow the vname fields of ruleAlias and are skipped.
+type lazyRuleAlias struct {
+	*ruleAlias
+	VName       skipValue `json:"vname"`
+	SourceVName skipValue `json:"source_vname"`
+}
+
+// A skipValue accepts any JSON value, and discards it.
+type skipValue struct{}
+
+func (skipValue) UnmarshalJSON([]byte) error { return nil }
+
+// UnmarshalJSON decodes the known fields of meta, and saves any others.
+func (meta *rule) UnmarshalJSON(data []byte) error {
+	if meta.lazy {
+		if err := json.Unmarshal(data, &lazyRuleAlias{ruleAlias: (*ruleAlias)(meta)}); err != nil {
+			return err
+		}
+	} else if err := json.Unmarshal(data, (*ruleAlias)(meta)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	meta.Extra = nil
+	_, hasBegin := fields["begin"]
+	_, hasEnd := fields["end"]
+	meta.noBegin, meta.noEnd = !hasBegin, !hasEnd
+	if meta.lazy {
+		// The vname of an anchor_anchor rule is its source vname, as for
+		// decodeKythe0; its vname field, if any, is ignored.
+		key := "vname"
+		if meta.Type == "anchor_anchor" {
+			key = "source_vname"
+		}
+		if v := fields[key]; string(v) != "null" {
+			meta.rawVName = v
+		}
+	}
+	if ruleTypes[meta.Type].custom != nil {
+		meta.raw = append(json.RawMessage(nil), data...)
+		meta.rawVName = nil // decoded by the registered decoder
+	}
+	for key, val := range fields {
+		if !ruleFields[key] {
+			if meta.Extra == nil {
+				meta.Extra = make(map[string]json.RawMessage)
+			}
+			meta.Extra[key] = val
+		}
+	}
+	return nil
+}
+
+// ErrMalformed is the sentinel error reported for metadata that cannot be
+// decoded. Errors returned by Parse satisfy errors.Is(err, ErrMalformed).
+var ErrMalformed = errors.New("malformed metadata")
+
+// A ParseError describes a problem decoding a metadata file.
+type ParseError struct {
+	Offset int64 // byte offset in the input where decoding failed
+	Index  int   // index of the offending rule in the meta array, or -1
+	Err    error // the underlying error
+}
+
+// Error satisfies the error interface.
+func (e *ParseError) Error() string {
+	if e.Index >= 0 {
+		return fmt.Sprintf("metadata: rule %d: %v", e.Index, e.Err)
+	}
+	return fmt.Sprintf("metadata: offset %d: %v", e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrMalformed.
+func (e *ParseError) Is(target error) bool { return target == ErrMalformed }
+
+// Pointer returns a JSON pointer (RFC 6901) to the offending rule, for
+// example "/meta/42", or "" if the error does not concern a single rule.
+func (e *ParseError) Pointer() string {
+	if e.Index < 0 {
+		return ""
+	}
+	return "/meta/" + strconv.Itoa(e.Index)
 }
 
 // Parse parses a single JSON metadata object from r and returns the
 // corresponding rules. It is an error if there are extra data after the
-// metadata object, or if the type tag of the object does not match the current
-// format code.
-func Parse(r io.Reader) (Rules, error) {
-	dec := json.NewDecoder(r)
-	var f file
-	if err := dec.Decode(&f); err != nil {
-		return nil, fmt.Errorf("metadata: invalid file: %v", err)
-	} else if _, err := dec.Token(); err != io.EOF {
-		return nil, errors.New("metadata: extra junk at end of input")
-	} else if f.Type != fileType {
-		return nil, fmt.Errorf("metadata: wrong type tag: %q", f.Type)
-	}
-
-	rs := make(Rules, len(f.Meta))
-	for i, meta := range f.Meta {
-		rs[i] = Rule{
-			Begin:   meta.Begin,
-			End:     meta.End,
-			EdgeOut: edges.Canonical(meta.Edge),
-			Reverse: edges.IsReverse(meta.Edge),
-			VName:   meta.VName,
-		}
-		switch t := meta.Type; t {
-		case "nop":
-			// ok, no special behaviour
-		case "anchor_defines":
-			rs[i].EdgeIn = edges.DefinesBinding
-		default:
-			return nil, fmt.Errorf("metadata: unknown rule type: %q", t)
+// metadata object, or if the type tag of the object does not match one of the
+// supported format codes ("kythe0" or "kythe1"). A leading UTF-8 byte-order
+// mark and trailing whitespace are ignored. Any error returned has concrete
+// type *ParseError.
+func Parse(r io.Reader) (Rules, error) { return ParseContext(context.Background(), r) }
+
+// ParseContext behaves as Parse, but stops and returns ctx.Err() if ctx ends
+// before parsing is complete. The rules are decoded incrementally, so even a
+// very large input can be abandoned promptly. The error from ctx is returned
+// unwrapped, and is not a *ParseError, since the input is not at fault; every
+// other error has concrete type *ParseError, as for Parse.
+func ParseContext(ctx context.Context, r io.Reader) (Rules, error) { return parse(ctx, r, nil) }
+
+// ParseStrict behaves as Parse, but checks the type of every rule against
+// KnownRuleTypes and reports all the rules whose type is not known, rather
+// than stopping at the first.
+func ParseStrict(r io.Reader) (Rules, error) {
+	return ParseWithOptions(r, &ParseOptions{Strict: true})
+}
+
+// ParseOptions control the behaviour of ParseWithOptions. A nil *ParseOptions
+// provides default values.
+type ParseOptions struct {
+	// If true, check every rule and report all problems found, rather than
+	// stopping at the first. In addition to unknown rule types, strict mode
+	// rejects negative offsets, inverted spans, and scores outside [0, 1].
+	Strict bool
+
+	// If set, the corpus and root of this vname are used for rule vnames
+	// that do not set them.
+	Defaults *spb.VName
+
+	// If true, clean the path of each rule vname, as path.Clean, so that
+	// equivalent spellings such as "./gen//foo.go" and "gen/foo.go" agree.
+	// Other vname fields, including the corpus and signature, are not
+	// affected.
+	NormalizePaths bool
+}
+
+func (o *ParseOptions) strict() bool { return o != nil && o.Strict }
+
+// fixVName updates v in place according to the settings of o.
+func (o *ParseOptions) fixVName(v *spb.VName) {
+	if o == nil || v == nil {
+		return
+	}
+	if o.Defaults != nil {
+		if v.Corpus == "" {
+			v.Corpus = o.Defaults.Corpus
+		}
+		if v.Root == "" {
+			v.Root = o.Defaults.Root
 		}
 	}
+	if o.NormalizePaths && v.Path != "" {
+		v.Path = strings.TrimPrefix(path.Clean(v.Path), "./")
+	}
+}
+
+// ParseWithDefaults behaves as Parse, but fills in the corpus and root of each
+// rule vname that does not set them from base. Values set explicitly by a
+// rule are not overwritten.
+func ParseWithDefaults(r io.Reader, base *spb.VName) (Rules, error) {
+	return ParseWithOptions(r, &ParseOptions{Defaults: base})
+}
+
+// ParseEach parses a single JSON metadata object from r as Parse, but calls
+// fn for each rule as it is decoded rather than accumulating a slice of them.
+// The type tag of the object is checked before fn is called. If fn reports an
+// error, parsing stops and ParseEach returns that error.
+func ParseEach(r io.Reader, fn func(Rule) error) error {
+	d, err := newDecoder(context.Background(), r, nil)
+	if err != nil {
+		return err
+	}
+	return d.decode(func(_ int, rule Rule) error { return fn(rule) })
+}
+
+// ParseWithOptions parses a single JSON metadata object from r as Parse, using
+// the settings from opts.
+func ParseWithOptions(r io.Reader, opts *ParseOptions) (Rules, error) {
+	return parse(context.Background(), r, opts)
+}
+
+func parse(ctx context.Context, r io.Reader, opts *ParseOptions) (Rules, error) {
+	d, err := newDecoder(ctx, r, opts)
+	if err != nil {
+		return nil, err
+	}
+	return d.rules()
+}
+
+// rules decodes a complete metadata object with d and returns its rules, as
+// Parse.
+func (d *decoder) rules() (Rules, error) {
+	var rs Rules
+	if err := d.decode(func(_ int, rule Rule) error {
+		rs = append(rs, rule)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if rs == nil && d.sawMeta {
+		rs = Rules{}
+	}
 	return rs, nil
 }
 
+// A ParseResult is the result of a successful call to ParseWithWarnings or
+// ParseVerbose.
+type ParseResult struct {
+	rules    Rules
+	warnings []Warning
+	encoding string // the declared offset encoding, if any
+}
+
+// Rules returns the rules that were parsed.
+func (p *ParseResult) Rules() Rules { return p.rules }
+
+// Warnings returns the non-fatal problems found while parsing, in the order
+// they were found.
+func (p *ParseResult) Warnings() []Warning { return p.warnings }
+
+// Encoding returns the offset encoding of the rules, as declared by the
+// encoding or offset_encoding header of the file, or EncodingUTF8Bytes if
+// neither was given or the offsets have been normalized. Outside strict mode,
+// an unknown encoding is returned as it was declared.
+func (p *ParseResult) Encoding() string {
+	if p.encoding == "" {
+		return EncodingUTF8Bytes
+	}
+	return p.encoding
+}
+
+// Normalize converts the offsets of the rules, in place, from the declared
+// encoding to byte offsets in fileContents, which must be the UTF-8 encoded
+// text of the file the offsets refer to, as RunesToBytes or
+// RemapUTF16ToBytes. Afterward, Encoding reports EncodingUTF8Bytes. It is an
+// error if the declared encoding is unknown, or if any offset is not valid
+// for fileContents; in that case the rules are not modified.
+func (p *ParseResult) Normalize(fileContents []byte) error {
+	var err error
+	switch enc := p.Encoding(); enc {
+	case EncodingUTF8Bytes, EncodingRunes:
+		err = p.rules.RunesToBytes(fileContents) // only rules with RuneOffsets
+	case EncodingUTF16:
+		err = p.rules.RemapUTF16ToBytes(fileContents)
+	default:
+		return fmt.Errorf("metadata: unknown offset encoding %q", enc)
+	}
+	if err != nil {
+		return err
+	}
+	p.encoding = EncodingUTF8Bytes
+	return nil
+}
+
+// A Warning describes a non-fatal problem found while parsing a rule.
+type Warning struct {
+	Index    int             // index of the rule in the meta array
+	Category WarningCategory // the kind of problem
+	Message  string          // a description of the problem
+}
+
+// A WarningCategory classifies the problem described by a Warning, so that
+// callers can choose which kinds of problem to treat as errors.
+type WarningCategory int
+
+// The categories of Warning.
+const (
+	WarnNone           WarningCategory = iota // uncategorized
+	WarnClampedOffset                         // a negative offset was clamped to 0
+	WarnSwappedOffsets                        // inverted offsets were swapped
+	WarnUnknownType                           // a rule of unknown type was dropped
+)
+
+var warningCategoryNames = [...]string{
+	WarnNone:           "none",
+	WarnClampedOffset:  "clamped offset",
+	WarnSwappedOffsets: "swapped offsets",
+	WarnUnknownType:    "unknown type",
+}
+
+// String returns a human-readable name for the category.
+func (c WarningCategory) String() string {
+	if c >= 0 && int(c) < len(warningCategoryNames) {
+		return warningCategoryNames[c]
+	}
+	return fmt.Sprintf("WarningCategory(%d)", int(c))
+}
+
+// String returns a human-readable description of the warning.
+func (w Warning) String() string { return fmt.Sprintf("rule %d: %s", w.Index, w.Message) }
+
+// ParseWithWarnings parses a single JSON metadata object from r as
+// ParseWithOptions. Unless opts requests strict mode, negative offsets are
+// clamped to zero and inverted spans have their offsets swapped, and a
+// warning describing each such change is added to the result.
+func ParseWithWarnings(r io.Reader, opts *ParseOptions) (*ParseResult, error) {
+	return parseWithWarnings(r, opts, false)
+}
+
+// ParseVerbose parses a single JSON metadata object from r as Parse, but
+// repairs what problems it can rather than failing. Spans are repaired as by
+// ParseWithWarnings, and rules of unknown type are dropped. Each change is
+// described by a warning in the result, whose category may be used to decide
+// which problems should nevertheless be treated as errors.
+func ParseVerbose(r io.Reader) (*ParseResult, error) {
+	return parseWithWarnings(r, nil, true)
+}
+
+func parseWithWarnings(r io.Reader, opts *ParseOptions, dropUnknown bool) (*ParseResult, error) {
+	d, err := newDecoder(context.Background(), r, opts)
+	if err != nil {
+		return nil, err
+	}
+	res := new(ParseResult)
+	if !opts.strict() {
+		d.warn = func(w Warning) { res.warnings = append(res.warnings, w) }
+		d.dropUnknown = dropUnknown
+	}
+	if err := d.decode(func(_ int, rule Rule) error {
+		res.rules = append(res.rules, rule)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	res.encoding = d.encoding
+	return res, nil
+}
+
+// ParseLenient parses a single JSON metadata object from r as Parse, but a
+// rule that cannot be decoded does not prevent the others from being returned.
+// Instead, the error for each such rule is reported, and the rule is omitted
+// from the result. Errors that prevent any further decoding, such as an
+// incorrect type tag or invalid JSON syntax, are reported as the last error,
+// and the rules decoded up to that point are returned. Each error has concrete
+// type *ParseError.
+func ParseLenient(r io.Reader) (Rules, []error) {
+	d, err := newDecoder(context.Background(), r, nil)
+	if err != nil {
+		return nil, []error{err}
+	}
+	var errs []error
+	d.skip = func(err error) { errs = append(errs, err) }
+	var rs Rules
+	if err := d.decode(func(_ int, rule Rule) error {
+		rs = append(rs, rule)
+		return nil
+	}); err != nil {
+		errs = append(errs, err)
+	}
+	return rs, errs
+}
+
+// ParseBytes behaves as Parse, but reads the metadata object from data. It
+// returns the same rules and errors as Parse for the same input, but does not
+// need to wrap data in a reader, nor to buffer its start to detect a byte
+// order mark.
+func ParseBytes(data []byte) (Rules, error) {
+	return newBytesDecoder(context.Background(), data, nil).rules()
+}
+
+// ParseTolerant parses a single JSON metadata object from r as Parse, but
+// accepts a trailing comma after the last element of any array or object, as
+// is often left in hand-edited files. Each such comma is replaced by a space
+// before decoding, so the offsets reported in errors match the input.
+func ParseTolerant(r io.Reader) (Rules, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, &ParseError{Index: -1, Err: fmt.Errorf("invalid file: %v", err)}
+	}
+	return Parse(bytes.NewReader(blankTrailingCommas(data)))
+}
+
+// blankTrailingCommas replaces with spaces, in place, each comma in the JSON
+// text data that is followed, apart from whitespace, by a closing bracket or
+// brace. Commas within strings are not affected. It returns data.
+func blankTrailingCommas(data []byte) []byte {
+	comma := -1   // the offset of a comma not yet followed by a value
+	var last byte // the last character outside a string, other than whitespace
+	inString, escaped := false, false
+	for i, c := range data {
+		switch {
+		case inString:
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			// whitespace does not affect a pending comma
+		case c == ']' || c == '}':
+			if comma >= 0 {
+				data[comma] = ' '
+			}
+			comma = -1
+		case c == ',' && last != ',' && last != '[' && last != '{':
+			comma = i
+		default:
+			inString = c == '"'
+			comma = -1
+		}
+		if !inString && c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			last = c
+		}
+	}
+	return data
+}
+
+// utf8BOM is the UTF-8 encoding of the byte-order mark, U+FEFF.
+const utf8BOM = "\xef\xbb\xbf"
+
+// skipBOM returns a reader for the contents of r following the leading UTF-8
+// byte-order mark, if it has one, along with the number of bytes skipped.
+func skipBOM(r io.Reader) (io.Reader, int64, error) {
+	var head [len(utf8BOM)]byte
+	n, err := io.ReadFull(r, head[:])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return bytes.NewReader(head[:n]), 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	} else if string(head[:]) == utf8BOM {
+		return r, int64(n), nil
+	}
+	return io.MultiReader(bytes.NewReader(head[:]), r), 0, nil
+}
+
+// A decoder reads a single metadata object from a JSON stream, decoding the
+// meta array one rule at a time so that errors can be attributed to the rule
+// that caused them.
+type decoder struct {
+	ctx        context.Context
+	dec        *json.Decoder
+	src        bytes.Reader // the input of dec, if created by newBytesDecoder
+	opts       *ParseOptions
+	base       int64  // offset of dec relative to the original input
+	sawMeta    bool   // whether a non-null meta array was found
+	runes      bool   // whether the offset encoding is EncodingRunes
+	encoding   string // the declared offset encoding, if any
+	offsetBase int    // the offset_base header, added to each rule's offsets
+
+	// The rule decoder for the format version named by the type tag.
+	decodeRule func(rule) (Rule, error)
+
+	// If set, problems with rules that can be repaired are fixed, and
+	// reported to this function, rather than being passed through.
+	warn func(Warning)
+
+	// If set, rules that cannot be decoded are reported to this function
+	// and skipped, rather than ending decoding.
+	skip func(error)
+
+	// If set, along with warn, rules of unknown type are reported as
+	// warnings and dropped, rather than being rejected.
+	dropUnknown bool
+
+	// If set, the limits on the size of the input; see ParseLimited.
+	limits *ParseLimits
+
+	// If set, rule vnames are not decoded; instead, this function is called
+	// with the encoded vname and language override of each rule, just before
+	// the rule itself is reported.
+	lazy func(vname json.RawMessage, language string)
+}
+
+// newDecoder constructs a decoder for the metadata object in r.
+func newDecoder(ctx context.Context, r io.Reader, opts *ParseOptions) (*decoder, error) {
+	r, skip, err := skipBOM(r)
+	if err != nil {
+		return nil, &ParseError{Index: -1, Err: fmt.Errorf("invalid file: %v", err)}
+	}
+	return &decoder{ctx: ctx, dec: json.NewDecoder(r), opts: opts, base: skip}, nil
+}
+
+// newBytesDecoder returns a decoder reading the metadata object in data, as
+// newDecoder would for a reader of data.
+func newBytesDecoder(ctx context.Context, data []byte, opts *ParseOptions) *decoder {
+	d := &decoder{ctx: ctx, opts: opts}
+	if bytes.HasPrefix(data, []byte(utf8BOM)) {
+		data, d.base = data[len(utf8BOM):], int64(len(utf8BOM))
+	}
+	d.src.Reset(data)
+	d.dec = json.NewDecoder(&d.src)
+	return d
+}
+
+// checkInterval is the number of rules decoded between checks for
+// cancellation of the decoder's context.
+const checkInterval = 64
+
+// fail returns a *ParseError for the current input position.
+func (d *decoder) fail(index int, err error) error {
+	return &ParseError{Offset: d.base + d.dec.InputOffset(), Index: index, Err: err}
+}
+
+// decode reads a complete metadata object and calls f for each rule in order.
+// If f reports an error, decoding stops and that error is returned.
+func (d *decoder) decode(f func(int, Rule) error) error {
+	if err := d.ctx.Err(); err != nil {
+		return err
+	}
+	if tok, err := d.dec.Token(); err != nil {
+		return d.fail(-1, fmt.Errorf("invalid file: %v", err))
+	} else if tok != json.Delim('{') {
+		return d.fail(-1, fmt.Errorf("invalid file: got %v, want object", tok))
+	}
+
+	// The type tag must be checked before any rules are reported.  In the
+	// usual case it precedes the meta array; if not, buffer the array until
+	// the type has been seen.
+	var ftype string
+	var haveType bool
+	var meta json.RawMessage
+	var metaBase int64
+	for d.dec.More() {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return d.fail(-1, fmt.Errorf("invalid file: %v", err))
+		}
+		switch key, _ := tok.(string); key {
+		case keyType:
+			if err := d.dec.Decode(&ftype); err != nil {
+				return d.fail(-1, fmt.Errorf("invalid type tag: %v", err))
+			}
+			if !ValidType(ftype) {
+				return d.fail(-1, fmt.Errorf("wrong type tag: %q", ftype))
+			}
+			d.decodeRule = ruleDecoders[ftype]
+			haveType = true
+		case keyOffsetEncoding:
+			var enc string
+			if err := d.dec.Decode(&enc); err != nil {
+				return d.fail(-1, fmt.Errorf("invalid offset encoding: %v", err))
+			}
+			if d.sawMeta {
+				// The rules have already been reported with byte offsets.
+				return d.fail(-1, errors.New("offset_encoding header follows the meta array"))
+			}
+			runes, err := runeOffsets(enc)
+			if err != nil {
+				return d.fail(-1, err)
+			}
+			enc = EncodingUTF8Bytes
+			if runes {
+				enc = EncodingRunes
+			}
+			if err := d.setEncoding(enc); err != nil {
+				return d.fail(-1, err)
+			}
+		case keyEncoding:
+			var enc string
+			if err := d.dec.Decode(&enc); err != nil {
+				return d.fail(-1, fmt.Errorf("invalid encoding: %v", err))
+			} else if d.sawMeta {
+				return d.fail(-1, errors.New("encoding header follows the meta array"))
+			}
+			switch enc {
+			case "":
+				enc = EncodingUTF8Bytes
+			case EncodingUTF8Bytes, EncodingUTF16, EncodingRunes:
+			default:
+				if d.opts.strict() {
+					return d.fail(-1, fmt.Errorf("unknown encoding %q", enc))
+				}
+			}
+			if err := d.setEncoding(enc); err != nil {
+				return d.fail(-1, err)
+			}
+		case keyOffsetBase:
+			var base int64
+			if err := d.dec.Decode(&base); err != nil {
+				return d.fail(-1, fmt.Errorf("invalid offset base: %v", err))
+			} else if d.sawMeta {
+				return d.fail(-1, errors.New("offset_base header follows the meta array"))
+			} else if base < 0 {
+				return d.fail(-1, fmt.Errorf("negative offset base %d", base))
+			}
+			if d.offsetBase, err = checkOffset(base); err != nil {
+				return d.fail(-1, err)
+			}
+		case keyMeta:
+			if haveType {
+				if err := d.decodeMeta(f); err != nil {
+					return err
+				}
+				continue
+			}
+			metaBase = d.base + d.dec.InputOffset()
+			if err := d.dec.Decode(&meta); err != nil {
+				return d.fail(-1, fmt.Errorf("invalid meta: %v", err))
+			}
+		default:
+			var skip json.RawMessage
+			if err := d.dec.Decode(&skip); err != nil {
+				return d.fail(-1, fmt.Errorf("invalid file: %v", err))
+			}
+		}
+	}
+	if _, err := d.dec.Token(); err != nil {
+		return d.fail(-1, fmt.Errorf("invalid file: %v", err))
+	} else if _, err := d.dec.Token(); err != io.EOF {
+		return d.fail(-1, errors.New("extra junk at end of input"))
+	} else if !haveType {
+		return d.fail(-1, fmt.Errorf("wrong type tag: %q", ftype))
+	}
+	if meta != nil {
+		sub := &decoder{
+			ctx:        d.ctx,
+			dec:        json.NewDecoder(bytes.NewReader(meta)),
+			opts:       d.opts,
+			base:       metaBase,
+			runes:      d.runes,
+			offsetBase: d.offsetBase,
+
+			decodeRule:  d.decodeRule,
+			warn:        d.warn,
+			skip:        d.skip,
+			dropUnknown: d.dropUnknown,
+			limits:      d.limits,
+			lazy:        d.lazy,
+		}
+		err := sub.decodeMeta(f)
+		d.sawMeta = sub.sawMeta
+		return err
+	}
+	return nil
+}
+
+// setEncoding records the offset encoding declared by a header of the file.
+// Both the offset_encoding and the encoding headers may be given, but they
+// must agree.
+func (d *decoder) setEncoding(enc string) error {
+	if d.encoding != "" && d.encoding != enc {
+		return fmt.Errorf("conflicting offset encodings %q and %q", d.encoding, enc)
+	}
+	d.encoding = enc
+	d.runes = enc == EncodingRunes
+	return nil
+}
+
+// decodeMeta reads the value of the meta array and calls f for each rule.
+func (d *decoder) decodeMeta(f func(int, Rule) error) error {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return d.fail(-1, fmt.Errorf("invalid meta: %v", err))
+	} else if tok == nil {
+		return nil // "meta": null
+	} else if tok != json.Delim('[') {
+		return d.fail(-1, fmt.Errorf("invalid meta: got %v, want array", tok))
+	}
+	d.sawMeta = true
+	var problems []string // strict mode: problems found so far
+	for i := 0; d.dec.More(); i++ {
+		if i%checkInterval == 0 {
+			if err := d.ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if max := d.limits.maxRules(); max > 0 && i >= max {
+			return d.fail(i, &LimitError{Limit: "MaxRules", Max: int64(max)})
+		}
+		meta := rule{lazy: d.lazy != nil}
+		if err := d.decodeOne(i, &meta); err != nil {
+			if _, ok := err.(*ParseError); ok {
+				return err
+			}
+			if d.skip == nil || !isRuleError(err) {
+				return d.fail(i, err)
+			}
+			d.skip(d.fail(i, err))
+			continue
+		}
+		if d.opts.strict() {
+			for _, p := range strictProblems(meta) {
+				problems = append(problems, fmt.Sprintf("rule %d: %s", i, p))
+			}
+		}
+		if problems != nil {
+			continue // don't report rules once we know we will fail
+		}
+		if d.dropUnknown && !isKnownRuleType(meta.Type) {
+			d.warn(Warning{Index: i, Category: WarnUnknownType, Message: fmt.Sprintf("unknown rule type %q dropped", meta.Type)})
+			continue
+		}
+		r, err := d.decodeRule(meta)
+		if err != nil {
+			if d.skip == nil {
+				return d.fail(i, err)
+			}
+			d.skip(d.fail(i, err))
+			continue
+		}
+		if d.warn != nil {
+			r = d.repairSpan(i, r)
+		}
+		d.opts.fixVName(r.VName)
+		r.RuneOffsets = d.runes
+		if r, err = rebase(r, d.offsetBase); err != nil {
+			return d.fail(i, err)
+		}
+		if d.lazy != nil {
+			d.lazy(meta.rawVName, meta.Language)
+		}
+		if err := f(i, r); err != nil {
+			return err
+		}
+	}
+	if _, err := d.dec.Token(); err != nil {
+		return d.fail(-1, fmt.Errorf("invalid meta: %v", err))
+	} else if problems != nil {
+		return d.fail(-1, fmt.Errorf("invalid rules: %s", strings.Join(problems, "; ")))
+	}
+	return nil
+}
+
+// decodeOne decodes the next rule from the meta array into meta, enforcing the
+// nesting limit if one is set. A violation of the limit is reported as a
+// *ParseError.
+func (d *decoder) decodeOne(i int, meta *rule) error {
+	max := d.limits.maxDepth()
+	if max <= 0 {
+		return d.dec.Decode(meta)
+	}
+	var raw json.RawMessage
+	if err := d.dec.Decode(&raw); err != nil {
+		return err
+	} else if jsonDepth(raw) > max {
+		return d.fail(i, &LimitError{Limit: "MaxDepth", Max: int64(max)})
+	}
+	return json.Unmarshal(raw, meta)
+}
+
+// isRuleError reports whether err, reported by the JSON decoder for a single
+// rule, concerns only the content of that rule. Other errors, such as syntax
+// errors, leave the decoder unable to continue.
+func isRuleError(err error) bool {
+	var serr *json.SyntaxError
+	return !errors.As(err, &serr) && err != io.ErrUnexpectedEOF && err != io.EOF
+}
+
+// strictProblems returns descriptions of the problems with meta that are
+// rejected in strict mode.
+func strictProblems(meta rule) []string {
+	var ps []string
+	if !isKnownRuleType(meta.Type) {
+		ps = append(ps, fmt.Sprintf("unknown rule type %q", meta.Type))
+	}
+	if meta.Begin < 0 {
+		ps = append(ps, fmt.Sprintf("negative begin offset %d", meta.Begin))
+	}
+	if meta.End < 0 {
+		ps = append(ps, fmt.Sprintf("negative end offset %d", meta.End))
+	}
+	if meta.End < meta.Begin {
+		ps = append(ps, fmt.Sprintf("end offset %d < begin offset %d", meta.End, meta.Begin))
+	}
+	if s := meta.Score; s != nil && !(*s >= 0 && *s <= 1) {
+		ps = append(ps, fmt.Sprintf("score %v out of range [0, 1]", *s))
+	}
+	if meta.noBegin && !meta.noEnd {
+		ps = append(ps, "end offset without begin offset")
+	} else if meta.noEnd && !meta.noBegin {
+		ps = append(ps, "begin offset without end offset")
+	}
+	return ps
+}
+
+// repairSpan clamps negative offsets in r to zero and swaps its offsets if
+// they are inverted, reporting a warning for each change.
+func (d *decoder) repairSpan(i int, r Rule) Rule {
+	if r.Begin < 0 {
+		d.warn(Warning{Index: i, Category: WarnClampedOffset, Message: fmt.Sprintf("negative begin offset %d clamped to 0", r.Begin)})
+		r.Begin = 0
+	}
+	if r.End < 0 {
+		d.warn(Warning{Index: i, Category: WarnClampedOffset, Message: fmt.Sprintf("negative end offset %d clamped to 0", r.End)})
+		r.End = 0
+	}
+	if r.End < r.Begin {
+		d.warn(Warning{Index: i, Category: WarnSwappedOffsets, Message: fmt.Sprintf("inverted offsets [%d, %d) swapped", r.Begin, r.End)})
+		r.Begin, r.End = r.End, r.Begin
+	}
+	return r
+}
+
+// decodeKythe0 converts an encoded kythe0 rule into its Rule equivalent,
+// using the decoder registered for its type.
+func decodeKythe0(meta rule) (Rule, error) {
+	t, ok := ruleTypes[meta.Type]
+	if !ok {
+		return Rule{}, fmt.Errorf("unknown rule type: %q", meta.Type)
+	} else if t.custom != nil {
+		return t.custom(meta.raw)
+	}
+	r, err := decodeCommon(meta)
+	if err != nil {
+		return Rule{}, err
+	}
+	if err := t.builtin(&r, meta); err != nil {
+		return Rule{}, err
+	}
+	return r, nil
+}
+
+// decodeCommon converts the fields of meta shared by all the built-in rule
+// types into their Rule equivalents.
+func decodeCommon(meta rule) (Rule, error) {
+	begin, err := checkOffset(meta.Begin)
+	if err != nil {
+		return Rule{}, err
+	}
+	end, err := checkOffset(meta.End)
+	if err != nil {
+		return Rule{}, err
+	}
+	r := Rule{
+		Begin:   begin,
+		End:     end,
+		EdgeOut: edges.Canonical(meta.Edge),
+		Reverse: edges.IsReverse(meta.Edge),
+		VName:   meta.VName,
+		Extra:   meta.Extra,
+		Ordinal: meta.Ordinal,
+		Score:   meta.Score,
+	}
+	sem, err := parseSemantic(meta.Semantic)
+	if err != nil {
+		return Rule{}, err
+	}
+	r.Semantic = sem
+	if meta.Language != "" && r.VName != nil {
+		r.VName.Language = meta.Language
+	}
+	if r.Position, err = decodePosition(meta); err != nil {
+		return Rule{}, err
+	}
+	// A rule that gives no span at all applies to the whole file. Nop rules
+	// have no effect either way, so their offsets are left alone.
+	r.WholeFile = meta.noBegin && meta.noEnd && r.Position == nil && meta.Type != "nop"
+	return r, nil
+}
+
+// maxInt is the largest value of type int.
+const maxInt = int64(^uint(0) >> 1)
+
+// checkOffset converts v, an offset decoded from the encoded format, to an int.
+// Offsets are encoded as 64-bit values; on platforms where int is narrower,
+// an offset beyond the range of int is reported as an error rather than being
+// truncated.
+func checkOffset(v int64) (int, error) {
+	if v > maxInt || v < -maxInt-1 {
+		return 0, fmt.Errorf("offset %d out of range", v)
+	}
+	return int(v), nil
+}
+
+// rebase returns r with base added to its offsets, as declared by the
+// offset_base header of a file whose rules were written relative to the start
+// of a file that has since been concatenated with others. Whole-file rules,
+// and rules whose span is given by a Position, are returned unchanged, as are
+// target spans, which refer to another file.
+func rebase(r Rule, base int) (Rule, error) {
+	if base == 0 || r.WholeFile || r.Position != nil {
+		return r, nil
+	}
+	for _, v := range []int{r.Begin, r.End} {
+		if int64(v) > maxInt-int64(base) {
+			return r, fmt.Errorf("offset %d out of range with base %d", v, base)
+		}
+	}
+	r.Begin += base
+	r.End += base
+	return r, nil
+}
+
+// decodePosition returns the line and column span of meta, or nil if it does
+// not have one. If any of the line or column fields is set, all must be.
+func decodePosition(meta rule) (*LineSpan, error) {
+	fields := []*int{meta.BeginLine, meta.BeginCol, meta.EndLine, meta.EndCol}
+	var n int
+	for _, f := range fields {
+		if f != nil {
+			n++
+		}
+	}
+	if n == 0 {
+		if meta.ColumnUnits != "" {
+			return nil, errors.New("column_units without a position")
+		}
+		return nil, nil
+	} else if n != len(fields) {
+		return nil, errors.New("incomplete position: begin_line, begin_col, end_line, and end_col are all required")
+	}
+	units, err := parseColumnUnit(meta.ColumnUnits)
+	if err != nil {
+		return nil, err
+	}
+	return &LineSpan{
+		BeginLine: *meta.BeginLine,
+		BeginCol:  *meta.BeginCol,
+		EndLine:   *meta.EndLine,
+		EndCol:    *meta.EndCol,
+		Units:     units,
+	}, nil
+}
+
+// decodeKythe1 converts an encoded kythe1 rule into its Rule equivalent.
+//
+// The kythe1 format is reserved for extensions to kythe0; at present the two
+// are identical. New rule fields should be decoded here, so that kythe0
+// readers are unaffected.
+func decodeKythe1(meta rule) (Rule, error) { return decodeKythe0(meta) }
+
 // FromGeneratedCodeInfo constructs a set of rules from the corresponding
 // protobuf descriptor message and the vname of the metadata file from which
-// the generated descriptor was loaded.
+// the generated descriptor was loaded. Annotations that set neither a begin
+// nor an end offset do not denote a span, and are skipped, as are nil
+// annotations. Duplicate annotations, which agree in every field, produce
+// only a single rule.
+//
+// The rules are ordered by Begin offset, then End offset, then signature,
+// regardless of the order of the annotations in msg, so the result is stable
+// across protoc runs. Rules that agree on all three keep their annotation
+// order.
 func FromGeneratedCodeInfo(msg *protopb.GeneratedCodeInfo, vname *spb.VName) Rules {
-	rs := make(Rules, len(msg.Annotation))
-	for i, anno := range msg.Annotation {
-		// Convert the path to a dot-separated string, e.g., 1.0.3.2,
-		// for use in the vname signature.
-		sig := make([]string, len(anno.Path))
-		for i, elt := range anno.Path {
-			sig[i] = strconv.Itoa(int(elt))
-		}
+	return FromGeneratedCodeInfoOpts(msg, vname, nil)
+}
+
+// GeneratedCodeOptions control the behaviour of FromGeneratedCodeInfoOpts. A
+// nil *GeneratedCodeOptions provides default values.
+type GeneratedCodeOptions struct {
+	// If set, this function is used to convert the path of each annotation
+	// into the signature of its rule vname, for example to name fields as
+	// resolved from a FileDescriptorProto. If nil, the path elements are
+	// joined with dots, e.g., "4.0.2.1".
+	//
+	// Rules with signatures not in the dotted form cannot be converted by
+	// ToGeneratedCodeInfo.
+	FormatPath func(path []int32) string
 
+	// The language of the rule vnames. If empty, "protobuf" is used.
+	Language string
+}
+
+func (o *GeneratedCodeOptions) language() string {
+	if o == nil || o.Language == "" {
+		return "protobuf"
+	}
+	return o.Language
+}
+
+func (o *GeneratedCodeOptions) formatPath(path []int32) string {
+	if o != nil && o.FormatPath != nil {
+		return o.FormatPath(path)
+	}
+	// Convert the path to a dot-separated string, e.g., 1.0.3.2, for use in
+	// the vname signature.
+	sig := make([]string, len(path))
+	for i, elt := range path {
+		sig[i] = strconv.Itoa(int(elt))
+	}
+	return strings.Join(sig, ".")
+}
+
+// FromGeneratedCodeInfoOpts behaves as FromGeneratedCodeInfo, using the
+// settings from opts.
+func FromGeneratedCodeInfoOpts(msg *protopb.GeneratedCodeInfo, vname *spb.VName, opts *GeneratedCodeOptions) Rules {
+	rs := make(Rules, 0, len(msg.GetAnnotation()))
+	seen := make(map[string]bool)
+	for _, anno := range msg.GetAnnotation() {
+		if anno == nil || (anno.Begin == nil && anno.End == nil) {
+			continue
+		} else if key := annotationKey(anno); seen[key] {
+			continue
+		} else {
+			seen[key] = true
+		}
 		// TODO(fromberger): Work out how to derive the correct corpus and root
 		// labels. When the protobuf source file is in the same corpus as its
 		// metadata, this will work as-is.
@@ -160,17 +1820,163 @@ func FromGeneratedCodeInfo(msg *protopb.GeneratedCodeInfo, vname *spb.VName) Rul
 			Corpus:    vname.GetCorpus(),
 			Root:      vname.GetRoot(),
 			Path:      anno.GetSourceFile(),
-			Language:  "protobuf",
-			Signature: strings.Join(sig, "."),
+			Language:  opts.language(),
+			Signature: opts.formatPath(anno.Path),
+		}
+		r := Rule{
+			EdgeIn:   edges.DefinesBinding,
+			EdgeOut:  edges.Generates,
+			Reverse:  true,
+			Begin:    int(anno.GetBegin()),
+			End:      int(anno.GetEnd()),
+			VName:    vname,
+			Semantic: annotationSemantic(anno),
 		}
-		rs[i] = Rule{
-			EdgeIn:  edges.DefinesBinding,
-			EdgeOut: edges.Generates,
-			Reverse: true,
-			Begin:   int(anno.GetBegin()),
-			End:     int(anno.GetEnd()),
-			VName:   vname,
+		if r.Semantic == SemanticAlias {
+			// The generated entity is an alias of the source entity, rather
+			// than something generated from it.
+			r.EdgeOut = aliasesEdge
+			r.Reverse = false
 		}
+		rs = append(rs, r)
 	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		a, b := rs[i], rs[j]
+		if a.Begin != b.Begin {
+			return a.Begin < b.Begin
+		} else if a.End != b.End {
+			return a.End < b.End
+		}
+		return a.VName.Signature < b.VName.Signature
+	})
 	return rs
 }
+
+// annotationKey returns a string that is equal for two annotations exactly
+// when their encodings are identical. Annotations have no map fields, so their
+// encoding is deterministic.
+func annotationKey(anno *protopb.GeneratedCodeInfo_Annotation) string {
+	bits, _ := proto.Marshal(anno) // an annotation has no required fields
+	return string(bits)
+}
+
+// FromGeneratedCodeInfoByFile constructs rules from msg as
+// FromGeneratedCodeInfo, and groups them by the source file of the annotation
+// each was derived from. The path of each rule vname is the source file, and
+// within each group the rules are in the order given by FromGeneratedCodeInfo.
+func FromGeneratedCodeInfoByFile(msg *protopb.GeneratedCodeInfo, base *spb.VName) map[string]Rules {
+	byFile := make(map[string]Rules)
+	for _, r := range FromGeneratedCodeInfo(msg, base) {
+		byFile[r.VName.Path] = append(byFile[r.VName.Path], r)
+	}
+	return byFile
+}
+
+// aliasesEdge is the outbound edge kind of rules derived from annotations
+// having the alias semantic.
+const aliasesEdge = edges.Prefix + "aliases"
+
+// ToGeneratedCodeInfo converts rs into the equivalent protobuf descriptor
+// message. It is the inverse of FromGeneratedCodeInfo: each rule becomes an
+// annotation whose path is parsed from the dotted signature of the rule vname
+// and whose source file is the vname path. Nop rules are skipped.
+//
+// Only rules of the shape produced by FromGeneratedCodeInfo can be converted;
+// ToGeneratedCodeInfo reports an error for any other rule, such as one with a
+// custom edge kind, an ordinal, or a non-numeric signature.
+func (rs Rules) ToGeneratedCodeInfo() (*protopb.GeneratedCodeInfo, error) {
+	msg := new(protopb.GeneratedCodeInfo)
+	for i, r := range rs {
+		if r.EdgeIn == "" {
+			continue // nop
+		}
+		anno, err := r.toAnnotation()
+		if err != nil {
+			return nil, fmt.Errorf("metadata: rule %d: %v", i, err)
+		}
+		msg.Annotation = append(msg.Annotation, anno)
+	}
+	return msg, nil
+}
+
+// toAnnotation converts r into a GeneratedCodeInfo annotation.
+func (r Rule) toAnnotation() (*protopb.GeneratedCodeInfo_Annotation, error) {
+	sem := r.Semantic
+	kind, reversed := r.effectiveBase()
+	switch {
+	case r.EdgeIn != edges.DefinesBinding:
+		return nil, fmt.Errorf("cannot convert %s rule to an annotation", r.EdgeIn)
+	case r.TargetSpan != nil || r.Ordinal != nil:
+		return nil, errors.New("cannot convert anchor_anchor or ordinal rule to an annotation")
+	case r.WholeFile:
+		return nil, errors.New("cannot convert whole-file rule to an annotation")
+	case r.VName == nil:
+		return nil, errors.New("missing vname")
+	case kind == edges.Generates && reversed && sem != SemanticAlias:
+		// ok
+	case kind == aliasesEdge && !reversed:
+		sem = SemanticAlias
+	default:
+		return nil, fmt.Errorf("cannot convert edge %q (reverse=%v) to an annotation", kind, reversed)
+	}
+	if r.Begin < 0 || r.End < 0 || r.Begin > math.MaxInt32 || r.End > math.MaxInt32 {
+		return nil, fmt.Errorf("span [%d, %d) out of range", r.Begin, r.End)
+	}
+
+	var path []int32
+	if sig := r.VName.Signature; sig != "" {
+		for _, elt := range strings.Split(sig, ".") {
+			v, err := strconv.ParseInt(elt, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path signature %q", sig)
+			}
+			path = append(path, int32(v))
+		}
+	}
+	anno := &protopb.GeneratedCodeInfo_Annotation{
+		Path:       path,
+		SourceFile: proto.String(r.VName.Path),
+		Begin:      proto.Int32(int32(r.Begin)),
+		End:        proto.Int32(int32(r.End)),
+	}
+	if sem != SemanticNone {
+		b := protowire.AppendTag(nil, annoSemanticField, protowire.VarintType)
+		anno.ProtoReflect().SetUnknown(protowire.AppendVarint(b, uint64(sem)))
+	}
+	return anno, nil
+}
+
+// annoSemanticField is the field number of the semantic field of a
+// GeneratedCodeInfo annotation.
+const annoSemanticField = 5
+
+// annotationSemantic returns the semantic recorded in anno. The semantic field
+// postdates the descriptor package in use, so it is read from the unknown
+// fields of the message.
+func annotationSemantic(anno *protopb.GeneratedCodeInfo_Annotation) Semantic {
+	sem := SemanticNone
+	b := anno.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			break
+		}
+		b = b[n:]
+		if num == annoSemanticField && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				break
+			}
+			if s := Semantic(v); s == SemanticSet || s == SemanticAlias {
+				sem = s
+			}
+			b = b[n:]
+			continue
+		}
+		if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+			break
+		}
+		b = b[n:]
+	}
+	return sem
+}
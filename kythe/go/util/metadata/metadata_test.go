@@ -18,11 +18,17 @@ package metadata
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
 
 	"kythe.io/kythe/go/test/testutil"
 	"kythe.io/kythe/go/util/schema/edges"
@@ -39,6 +45,11 @@ func TestParse(t *testing.T) {
 		// Minimal value: Just a plain type tag.
 		{`{"type":"kythe0"}`, nil},
 
+		// Leading byte-order mark and trailing whitespace.
+		{"\uFEFF{\"type\":\"kythe0\"}", nil},
+		{"{\"type\":\"kythe0\"}\n\n", nil},
+		{"\uFEFF{\"type\":\"kythe0\",\"meta\":[{\"type\":\"nop\",\"begin\":1}]} \n\n  ", Rules{{Begin: 1}}},
+
 		// NOP values, multiple rules.
 		{`{"type":"kythe0","meta":[
              {"type":"nop"},
@@ -74,6 +85,69 @@ func TestParse(t *testing.T) {
 				Root:      "groot",
 			},
 		}}},
+
+		// As above, but for a reference.
+		{`{"type":"kythe0","meta":[{"type":"ref","begin":179,"end":182,
+           "edge":"%/kythe/edge/generates",
+           "vname":{
+                 "signature":"gsig",
+                 "corpus":"gcorp",
+                 "path":"gpath",
+                 "language":"glang",
+                 "root":"groot"}
+          }]}`, Rules{{
+			Begin:   179,
+			End:     182,
+			EdgeIn:  edges.Ref,
+			EdgeOut: "/kythe/edge/generates",
+			Reverse: true,
+			VName: &spb.VName{
+				Signature: "gsig",
+				Corpus:    "gcorp",
+				Path:      "gpath",
+				Language:  "glang",
+				Root:      "groot",
+			},
+		}}},
+
+		// Anchor-to-anchor links, whose edges are written from the source
+		// anchor. Omitted offsets are zero, as in the proto3 JSON encoding.
+		{`{"type":"kythe0","meta":[
+             {"type":"anchor_anchor","target_begin":10,"target_end":15,
+              "source_begin":100,"source_end":105,
+              "edge":"/kythe/edge/imputes",
+              "source_vname":{"corpus":"c","path":"src.ts"}},
+             {"type":"anchor_anchor","target_end":25,"source_end":205,
+              "edge":"%/kythe/edge/generates",
+              "source_vname":{"corpus":"c","path":"src.ts"},"language":"ts"}
+          ]}`, Rules{{
+			Begin:      10,
+			End:        15,
+			EdgeIn:     edges.DefinesBinding,
+			EdgeOut:    AnchorAnchorEdge,
+			Reverse:    true,
+			VName:      &spb.VName{Corpus: "c", Path: "src.ts"},
+			TargetSpan: &Span{Begin: 100, End: 105},
+		}, {
+			End:        25,
+			EdgeIn:     edges.DefinesBinding,
+			EdgeOut:    edges.Generates,
+			VName:      &spb.VName{Corpus: "c", Path: "src.ts", Language: "ts"},
+			TargetSpan: &Span{End: 205},
+		}}},
+
+		// An ordinal-qualified edge.
+		{`{"type":"kythe0","meta":[
+             {"type":"anchor_defines","begin":5,"end":6,"edge":"/kythe/edge/param",
+              "ordinal":2,"vname":{"signature":"p"}}
+          ]}`, Rules{{
+			Begin:   5,
+			End:     6,
+			EdgeIn:  edges.DefinesBinding,
+			EdgeOut: edges.Param,
+			Ordinal: intPtr(2),
+			VName:   &spb.VName{Signature: "p"},
+		}}},
 	}
 	for _, test := range tests {
 		got, err := Parse(strings.NewReader(test.input))
@@ -88,83 +162,1733 @@ func TestParse(t *testing.T) {
 	}
 }
 
-func TestRoundTrip(t *testing.T) {
-	tests := []Rules{
-		nil,
-		Rules{},
-		Rules{{}},
-		Rules{
-			{},
-			{Begin: 25, End: 37, EdgeOut: "blah"},
-		},
-		Rules{{
-			VName: &spb.VName{
-				Signature: "gsig",
-				Corpus:    "gcorp",
-				Path:      "gpath",
-				Language:  "glang",
-				Root:      "groot",
-			},
-			Reverse: true,
-			EdgeIn:  edges.DefinesBinding,
-			EdgeOut: edges.Generates,
-			Begin:   179,
-			End:     182,
-		}},
+func TestParseLanguage(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+{"type":"anchor_defines","begin":1,"end":2,"edge":"%/kythe/edge/generates",
+ "vname":{"corpus":"c","language":"protobuf","signature":"m"}},
+{"type":"anchor_defines","begin":3,"end":4,"edge":"%/kythe/edge/generates",
+ "vname":{"corpus":"c","language":"protobuf","signature":"s"},"language":"grpc"},
+{"type":"anchor_defines","begin":5,"end":6,"edge":"%/kythe/edge/generates",
+ "vname":{"corpus":"c","signature":"t"},"language":"go"},
+{"type":"nop","begin":7,"end":8,"language":"go"}
+]}`
+	rs, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	var got []string
+	for _, r := range rs {
+		got = append(got, r.VName.GetLanguage())
+		if r.Extra != nil {
+			t.Errorf("Rule %v: unexpected extra fields %v", r, r.Extra)
+		}
+	}
+	if err := testutil.DeepEqual([]string{"protobuf", "grpc", "go", ""}, got); err != nil {
+		t.Errorf("Parse: wrong languages: %v", err)
+	}
+
+	// The overridden language survives a round trip in each encoding.
+	enc, err := json.Marshal(rs)
+	if err != nil {
+		t.Fatalf("Encoding failed: %v", err)
+	}
+	dec, err := Parse(bytes.NewReader(enc))
+	if err != nil {
+		t.Fatalf("Parse %q failed: %v", enc, err)
+	}
+	if err := testutil.DeepEqual(rs, dec); err != nil {
+		t.Errorf("JSON round trip: %v", err)
+	}
+	bin, err := rs.MarshalProto()
+	if err != nil {
+		t.Fatalf("MarshalProto failed: %v", err)
+	}
+	if dec, err = ParseProto(bin); err != nil {
+		t.Fatalf("ParseProto failed: %v", err)
+	}
+	if err := testutil.DeepEqual(rs, dec); err != nil {
+		t.Errorf("Proto round trip: %v", err)
+	}
+}
+
+func TestParseWholeFile(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+{"type":"anchor_defines","edge":"%/kythe/edge/generates","vname":{"corpus":"c","path":"a.proto"}},
+{"type":"anchor_defines","begin":0,"end":0,"edge":"%/kythe/edge/generates","vname":{"corpus":"c","path":"b.proto"}},
+{"type":"nop"}
+]}`
+	rs, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	var got []bool
+	for _, r := range rs {
+		got = append(got, r.WholeFile)
+	}
+	if err := testutil.DeepEqual([]bool{true, false, false}, got); err != nil {
+		t.Errorf("Parse: wrong whole-file flags: %v", err)
+	}
+	if s := rs[0].String(); !strings.HasPrefix(s, "[file] ") {
+		t.Errorf("String: got %q, want a [file] prefix", s)
+	}
+
+	// The offsets of a whole-file rule are omitted from its encoding, and
+	// the rule survives a round trip in each encoding.
+	enc, err := json.Marshal(rs)
+	if err != nil {
+		t.Fatalf("Encoding failed: %v", err)
+	}
+	if n := strings.Count(string(enc), `"begin"`); n != 2 {
+		t.Errorf("Encoding %s: got %d begin offsets, want 2", enc, n)
+	}
+	dec, err := Parse(bytes.NewReader(enc))
+	if err != nil {
+		t.Fatalf("Parse %s failed: %v", enc, err)
+	}
+	if err := testutil.DeepEqual(rs, dec); err != nil {
+		t.Errorf("JSON round trip: %v", err)
+	}
+	bin, err := rs.MarshalProto()
+	if err != nil {
+		t.Fatalf("MarshalProto failed: %v", err)
+	}
+	if dec, err = ParseProto(bin); err != nil {
+		t.Fatalf("ParseProto failed: %v", err)
+	}
+	if err := testutil.DeepEqual(rs, dec); err != nil {
+		t.Errorf("Proto round trip: %v", err)
+	}
+}
+
+func TestParsePartialSpan(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // the problem reported in strict mode
+	}{
+		{`{"type":"kythe0","meta":[{"type":"anchor_defines","begin":3,"edge":"%/kythe/edge/generates"}]}`,
+			"rule 0: begin offset without end offset"},
+		{`{"type":"kythe0","meta":[{"type":"ref","end":3,"edge":"/kythe/edge/ref"}]}`,
+			"rule 0: end offset without begin offset"},
 	}
 	for _, test := range tests {
-		enc, err := json.Marshal(test)
+		if rs, err := ParseStrict(strings.NewReader(test.input)); err == nil {
+			t.Errorf("ParseStrict(%s): got %v, wanted error", test.input, rs)
+		} else if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("ParseStrict(%s): error %q does not mention %q", test.input, err, test.want)
+		}
+
+		// Outside strict mode, the missing offset is zero.
+		rs, err := Parse(strings.NewReader(test.input))
 		if err != nil {
-			t.Errorf("Encoding %+v failed: %v", test, err)
+			t.Errorf("Parse(%s) failed: %v", test.input, err)
+		} else if rs[0].WholeFile {
+			t.Errorf("Parse(%s): partial span decoded as a whole-file rule", test.input)
+		}
+	}
+}
+
+func TestValidType(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{MetadataType, true},
+		{"kythe1", true},
+		{"", false},
+		{"kythe2", false},
+		{"Kythe0", false},
+	}
+	for _, test := range tests {
+		if got := ValidType(test.tag); got != test.want {
+			t.Errorf("ValidType(%q): got %v, want %v", test.tag, got, test.want)
+		}
+	}
+
+	// Encoded rules carry the exported type tag.
+	enc, err := json.Marshal(Rules{})
+	if err != nil {
+		t.Fatalf("Encoding failed: %v", err)
+	}
+	if want := `"type":"` + MetadataType + `"`; !strings.Contains(string(enc), want) {
+		t.Errorf("Encoding %q: missing %s", enc, want)
+	}
+}
+
+func TestParseTolerant(t *testing.T) {
+	want := Rules{
+		{Begin: 1, End: 2},
+		{
+			Begin:   3,
+			End:     4,
+			EdgeIn:  edges.Ref,
+			EdgeOut: edges.Generates,
+			VName:   &spb.VName{Corpus: "c", Signature: "a,]"},
+		},
+	}
+	tests := []string{
+		// No trailing commas.
+		`{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2},
+		  {"type":"ref","begin":3,"end":4,"edge":"/kythe/edge/generates","vname":{"corpus":"c","signature":"a,]"}}]}`,
+
+		// A trailing comma in the meta array.
+		`{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2},
+		  {"type":"ref","begin":3,"end":4,"edge":"/kythe/edge/generates","vname":{"corpus":"c","signature":"a,]"}},
+		]}`,
+
+		// Trailing commas in a vname, a rule, the array, and the file.
+		`{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2,},
+		  {"type":"ref","begin":3,"end":4,"edge":"/kythe/edge/generates","vname":{
+		     "corpus":"c",
+		     "signature":"a,]",
+		  }} ,
+		],}`,
+	}
+	for _, input := range tests {
+		got, err := ParseTolerant(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("ParseTolerant(%q) failed: %v", input, err)
+		} else if err := testutil.DeepEqual(want, got); err != nil {
+			t.Errorf("ParseTolerant(%q): %v", input, err)
+		}
+	}
+
+	// Parse rejects trailing commas.
+	for _, input := range tests[1:] {
+		if rs, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("Parse(%q): got %+v, wanted error", input, rs)
+		}
+	}
+
+	// Commas that do not end an array or object are still errors, and the
+	// error offsets match the input.
+	for _, input := range []string{
+		`{"type":"kythe0","meta":[,]}`,
+		`{"type":"kythe0","meta":[{"type":"nop"},,]}`,
+		`{"type":"kythe0","meta":[{"type":"nop",,}]}`,
+	} {
+		if rs, err := ParseTolerant(strings.NewReader(input)); err == nil {
+			t.Errorf("ParseTolerant(%q): got %+v, wanted error", input, rs)
+		}
+	}
+	const bad = `{"type":"kythe0","meta":[{"type":"nop",},{"type":"bogus"},]}`
+	const blanked = `{"type":"kythe0","meta":[{"type":"nop" },{"type":"bogus"} ]}`
+	_, perr := Parse(strings.NewReader(blanked))
+	_, terr := ParseTolerant(strings.NewReader(bad))
+	if perr == nil || terr == nil || perr.Error() != terr.Error() {
+		t.Errorf("ParseTolerant(%q): got error %v, want %v", bad, terr, perr)
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	tests := []string{
+		`{"type":"kythe0"}`,
+		` {"type":"kythe0","meta":[]} `,
+		`{"type":"kythe0","meta":[{"type":"nop","begin":42,"end":99}]}`,
+		`{"type":"kythe0","meta":[{"type":"anchor_defines","begin":1,"end":2,
+             "edge":"%/kythe/edge/generates","vname":{"signature":"s"}}]}`,
+	}
+	for _, input := range tests {
+		want, err := Parse(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("Parse %q failed: %v", input, err)
+			continue
+		}
+		got, err := ParseBytes([]byte(input))
+		if err != nil {
+			t.Errorf("ParseBytes %q failed: %v", input, err)
+			continue
+		}
+		if err := testutil.DeepEqual(want, got); err != nil {
+			t.Errorf("ParseBytes %q: %v", input, err)
+		}
+	}
+
+	// Errors should be identical to those of Parse, including their offsets.
+	for _, input := range []string{
+		"", " ", "  \n", "{}", "[]", `{"type":1}`, `{"type":"x"}`, `{"type":"kythe0"`,
+		"\uFEFF", "\uFEFF[]", "\uFEFF\uFEFF{}",
+		`{"type":"kythe0","meta":[{"type":"nop"},{"type":"bogus"}]}`,
+	} {
+		rs, got := ParseBytes([]byte(input))
+		if !errors.Is(got, ErrMalformed) {
+			t.Errorf("ParseBytes %q: got (%+v, %v), want %v", input, rs, got, ErrMalformed)
 			continue
 		}
+		var gotErr, wantErr *ParseError
+		_, want := Parse(strings.NewReader(input))
+		if !errors.As(got, &gotErr) || !errors.As(want, &wantErr) {
+			t.Errorf("ParseBytes %q: got error %T, Parse %T; want *ParseError", input, got, want)
+		} else if got.Error() != want.Error() {
+			t.Errorf("ParseBytes %q: got error %v, want %v as from Parse", input, got, want)
+		} else if gotErr.Offset != wantErr.Offset || gotErr.Index != wantErr.Index {
+			t.Errorf("ParseBytes %q: got error at offset %d, index %d; want %d, %d",
+				input, gotErr.Offset, gotErr.Index, wantErr.Offset, wantErr.Index)
+		}
+	}
 
-		dec, err := Parse(bytes.NewReader(enc))
+	// ParseBytes should allocate less than Parse of a reader of the same data.
+	data := []byte(tests[2])
+	parseAllocs := testing.AllocsPerRun(100, func() { Parse(bytes.NewReader(data)) })
+	bytesAllocs := testing.AllocsPerRun(100, func() { ParseBytes(data) })
+	if bytesAllocs >= parseAllocs {
+		t.Errorf("ParseBytes: %v allocations per call, want fewer than the %v of Parse", bytesAllocs, parseAllocs)
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"type":"kythe0","meta":[`)
+	const numRules = 10000
+	for i := 0; i < numRules; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`{"type":"nop","begin":1,"end":2}`)
+	}
+	buf.WriteString(`]}`)
+	input := buf.Bytes()
+
+	rs, err := ParseContext(context.Background(), bytes.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseContext failed: %v", err)
+	} else if len(rs) != numRules {
+		t.Errorf("ParseContext: got %d rules, want %d", len(rs), numRules)
+	}
+
+	// Cancel the context partway through reading the input.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := &cancelReader{r: bytes.NewReader(input), n: len(input) / 2, cancel: cancel}
+	if rs, err := ParseContext(ctx, r); err != context.Canceled {
+		t.Errorf("ParseContext: got (%d rules, %v), want %v", len(rs), err, context.Canceled)
+	}
+}
+
+// cancelReader calls cancel once n bytes have been read from r.
+type cancelReader struct {
+	r      io.Reader
+	n      int
+	cancel func()
+}
+
+func (c *cancelReader) Read(data []byte) (int, error) {
+	if len(data) > 64 {
+		data = data[:64]
+	}
+	nr, err := c.r.Read(data)
+	if c.n -= nr; c.n <= 0 {
+		c.cancel()
+	}
+	return nr, err
+}
+
+func TestParseEach(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+           {"type":"nop","begin":1,"end":2},
+           {"type":"nop","begin":3,"end":4},
+           {"type":"nop","begin":5,"end":6}
+        ]}`
+	var got Rules
+	if err := ParseEach(strings.NewReader(input), func(r Rule) error {
+		got = append(got, r)
+		return nil
+	}); err != nil {
+		t.Fatalf("ParseEach failed: %v", err)
+	}
+	want, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("ParseEach: %v", err)
+	}
+
+	// An error from the callback stops the parse.
+	stop := errors.New("stop")
+	var n int
+	if err := ParseEach(strings.NewReader(input), func(Rule) error {
+		if n++; n == 2 {
+			return stop
+		}
+		return nil
+	}); err != stop {
+		t.Errorf("ParseEach: got error %v, want %v", err, stop)
+	} else if n != 2 {
+		t.Errorf("ParseEach: callback invoked %d times, want 2", n)
+	}
+
+	// No rules are reported if the type tag is invalid.
+	const wrongType = `{"meta":[{"type":"nop"}],"type":"wrong"}`
+	if err := ParseEach(strings.NewReader(wrongType), func(r Rule) error {
+		t.Errorf("ParseEach: unexpected rule %+v", r)
+		return nil
+	}); !errors.Is(err, ErrMalformed) {
+		t.Errorf("ParseEach: got error %v, want %v", err, ErrMalformed)
+	}
+}
+
+func TestParseWithDefaults(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+           {"type":"nop"},
+           {"type":"anchor_defines","begin":1,"end":2,"vname":{"signature":"a"}},
+           {"type":"anchor_defines","begin":3,"end":4,"vname":{"signature":"b","corpus":"mine"}},
+           {"type":"anchor_defines","begin":5,"end":6,"vname":{"signature":"c","root":"r"}}
+        ]}`
+	base := &spb.VName{Corpus: "base", Root: "broot", Path: "ignored", Signature: "ignored"}
+	got, err := ParseWithDefaults(strings.NewReader(input), base)
+	if err != nil {
+		t.Fatalf("ParseWithDefaults failed: %v", err)
+	}
+	want := []*spb.VName{
+		nil,
+		{Signature: "a", Corpus: "base", Root: "broot"},
+		{Signature: "b", Corpus: "mine", Root: "broot"},
+		{Signature: "c", Corpus: "base", Root: "r"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ParseWithDefaults: got %d rules, want %d", len(got), len(want))
+	}
+	for i, r := range got {
+		if err := testutil.DeepEqual(want[i], r.VName); err != nil {
+			t.Errorf("ParseWithDefaults rule %d: %v", i, err)
+		}
+	}
+}
+
+func TestNormalizePaths(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"", ""},
+		{"gen/foo.go", "gen/foo.go"},
+		{"./gen/foo.go", "gen/foo.go"},
+		{"gen//foo.go", "gen/foo.go"},
+		{"gen/./x/../foo.go", "gen/foo.go"},
+		{".//gen/foo.go/", "gen/foo.go"},
+		{"/abs//path", "/abs/path"},
+		{".", "."},
+	}
+	opts := &ParseOptions{NormalizePaths: true}
+	for _, test := range tests {
+		input := fmt.Sprintf(`{"type":"kythe0","meta":[{"type":"anchor_defines",
+                   "vname":{"corpus":"./c//","path":%q,"signature":"a//b"}}]}`, test.path)
+		rs, err := ParseWithOptions(strings.NewReader(input), opts)
 		if err != nil {
-			t.Errorf("Decoding %q failed: %v", string(enc), err)
+			t.Errorf("Parse %q failed: %v", input, err)
 			continue
 		}
+		want := &spb.VName{Corpus: "./c//", Path: test.want, Signature: "a//b"}
+		if err := testutil.DeepEqual(want, rs[0].VName); err != nil {
+			t.Errorf("Parse path %q: %v", test.path, err)
+		}
+	}
+}
 
-		if err := testutil.DeepEqual(test, dec); err != nil {
-			t.Errorf("Round-trip of %+v failed: %v", test, err)
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		index int    // expected rule index, or -1
+		ptr   string // expected JSON pointer
+	}{
+		{``, -1, ""},
+		{`[]`, -1, ""},
+		{`{"type":"kythe0"} junk`, -1, ""},
+		{"\uFEFF", -1, ""},
+		{"\uFEFF\uFEFF{\"type\":\"kythe0\"}", -1, ""},
+		{`{"type":"wrong"}`, -1, ""},
+		{`{"meta":[]}`, -1, ""},
+		{`{"type":"kythe0","meta":{}}`, -1, ""},
+		{`{"type":"kythe0","meta":[{"type":"nop"},{"type":"bogus"}]}`, 1, "/meta/1"},
+		{`{"type":"kythe0","meta":[{"type":"nop","begin":"x"}]}`, 0, "/meta/0"},
+		{`{"type":"kythe0","meta":[{"type":"anchor_anchor","source_begin":1}]}`, 0, "/meta/0"},
+		{`{"meta":[{"type":"nop"},{"type":"nop"},{"type":"what"}],"type":"kythe0"}`, 2, "/meta/2"},
+		{`{"type":"kythe0","meta":[{"type":"nop","begin_line":1,"begin_col":0}]}`, 0, "/meta/0"},
+	}
+	for _, test := range tests {
+		rs, err := Parse(strings.NewReader(test.input))
+		if err == nil {
+			t.Errorf("Parse %q: got %+v, wanted error", test.input, rs)
+			continue
+		}
+		if !errors.Is(err, ErrMalformed) {
+			t.Errorf("Parse %q: got error %v, want %v", test.input, err, ErrMalformed)
+		}
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("Parse %q: got error %T, want *ParseError", test.input, err)
+			continue
 		}
+		if perr.Index != test.index {
+			t.Errorf("Parse %q: got index %d, want %d", test.input, perr.Index, test.index)
+		}
+		if got := perr.Pointer(); got != test.ptr {
+			t.Errorf("Parse %q: got pointer %q, want %q", test.input, got, test.ptr)
+		}
+		t.Logf("Parse %q: %v", test.input, err)
 	}
 }
 
-func TestGeneratedCodeInfo(t *testing.T) {
-	in := &protopb.GeneratedCodeInfo{
-		Annotation: []*protopb.GeneratedCodeInfo_Annotation{{
-			Path:       []int32{1, 2, 3, 4, 5},
-			SourceFile: proto.String("a"),
-			Begin:      proto.Int(1),
-			End:        proto.Int(100),
-		}},
+func TestParseLenient(t *testing.T) {
+	tests := []struct {
+		input   string
+		begins  []int // Begin offsets of the rules returned
+		indices []int // indices of the errors returned, -1 if not a rule
+	}{
+		{`{"type":"kythe0","meta":[{"type":"nop","begin":1},{"type":"nop","begin":2}]}`, []int{1, 2}, nil},
+		{`{"type":"kythe0","meta":[
+		   {"type":"nop","begin":1},
+		   {"type":"bogus","begin":2},
+		   {"type":"nop","begin":"x"},
+		   {"type":"anchor_anchor","begin":4},
+		   {"type":"nop","begin":5}]}`, []int{1, 5}, []int{1, 2, 3}},
+		{`{"meta":[{"type":"nop","begin":1},{"type":"what"}],"type":"kythe0"}`, []int{1}, []int{1}},
+		{`{"type":"wrong","meta":[{"type":"nop","begin":1}]}`, nil, []int{-1}},
+		{`{"meta":[{"type":"nop","begin":1}],"type":"wrong"}`, nil, []int{-1}},
+		{`{"type":"kythe0","meta":[{"type":"nop","begin":1},{"type":"nop",]}`, []int{1}, []int{1}},
 	}
-	want := Rules{{
-		VName: &spb.VName{
-			Signature: "1.2.3.4.5",
-			Language:  "protobuf",
-			Path:      "a",
-		},
-		Reverse: true,
-		EdgeIn:  edges.DefinesBinding,
-		EdgeOut: edges.Generates,
-		Begin:   1,
-		End:     100,
-	}}
-	{
-		got := FromGeneratedCodeInfo(in, nil)
-		if err := testutil.DeepEqual(got, want); err != nil {
-			t.Errorf("FromGeneratedCodeInfo failed: %v", err)
+	for _, test := range tests {
+		rs, errs := ParseLenient(strings.NewReader(test.input))
+		var begins, indices []int
+		for _, r := range rs {
+			begins = append(begins, r.Begin)
+		}
+		for _, err := range errs {
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Errorf("ParseLenient %q: got error %T, want *ParseError", test.input, err)
+				continue
+			}
+			indices = append(indices, perr.Index)
+		}
+		if err := testutil.DeepEqual(test.begins, begins); err != nil {
+			t.Errorf("ParseLenient %q rules: %v", test.input, err)
+		}
+		if err := testutil.DeepEqual(test.indices, indices); err != nil {
+			t.Errorf("ParseLenient %q errors %v: %v", test.input, errs, err)
 		}
 	}
-	{
-		got := FromGeneratedCodeInfo(in, &spb.VName{
-			Corpus: "blargh",
-		})
-		want[0].VName.Corpus = "blargh"
-		if err := testutil.DeepEqual(got, want); err != nil {
-			t.Errorf("FromGeneratedCodeInfo failed: %v", err)
+}
+
+func TestParseStrict(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+           {"type":"nop"},
+           {"type":"anchor_define","begin":1,"end":2},
+           {"type":"anchor_defines","begin":3,"end":4},
+           {"type":"bogus"}
+        ]}`
+	_, err := ParseStrict(strings.NewReader(input))
+	if !errors.Is(err, ErrMalformed) {
+		t.Fatalf("ParseStrict: got error %v, want %v", err, ErrMalformed)
+	}
+	for _, want := range []string{
+		`rule 1: unknown rule type "anchor_define"`,
+		`rule 3: unknown rule type "bogus"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseStrict: error %q does not mention %q", err, want)
+		}
+	}
+
+	// All the known rule types should be accepted.
+	for _, rtype := range KnownRuleTypes {
+		input := `{"type":"kythe0","meta":[{"type":"` + rtype + `",
+                   "edge":"/kythe/edge/imputes","source_begin":0,"source_end":0}]}`
+		if _, err := ParseStrict(strings.NewReader(input)); err != nil {
+			t.Errorf("ParseStrict %q: unexpected error: %v", input, err)
+		}
+	}
+}
+
+func TestParseScore(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+           {"type":"nop","begin":1,"end":2,"score":0.5},
+           {"type":"nop","begin":3,"end":4},
+           {"type":"nop","begin":5,"end":6,"score":1.5},
+           {"type":"nop","begin":7,"end":8,"score":-0.25}
+        ]}`
+	// Out-of-range scores are accepted as they are, except in strict mode.
+	rs, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := Rules{
+		{Begin: 1, End: 2, Score: floatPtr(0.5)},
+		{Begin: 3, End: 4},
+		{Begin: 5, End: 6, Score: floatPtr(1.5)},
+		{Begin: 7, End: 8, Score: floatPtr(-0.25)},
+	}
+	if err := testutil.DeepEqual(want, rs); err != nil {
+		t.Errorf("Parse: %v", err)
+	}
+
+	_, err = ParseStrict(strings.NewReader(input))
+	if !errors.Is(err, ErrMalformed) {
+		t.Fatalf("ParseStrict: got error %v, want %v", err, ErrMalformed)
+	}
+	for _, want := range []string{
+		"rule 2: score 1.5 out of range",
+		"rule 3: score -0.25 out of range",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseStrict: error %q does not mention %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "rule 0") {
+		t.Errorf("ParseStrict: error %q reports a valid score", err)
+	}
+
+	// A rule without a score encodes without the key.
+	bits, err := want[:2].MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON failed: %v", err)
+	}
+	if n := strings.Count(string(bits), `"score"`); n != 1 {
+		t.Errorf("MarshalJSON: got %d score keys, want 1: %s", n, bits)
+	}
+}
+
+func TestParseOffsetEncoding(t *testing.T) {
+	tests := []struct {
+		input string
+		runes bool
+	}{
+		{`{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2}]}`, false},
+		{`{"type":"kythe0","offset_encoding":"bytes","meta":[{"type":"nop","begin":1,"end":2}]}`, false},
+		{`{"type":"kythe0","offset_encoding":"runes","meta":[{"type":"nop","begin":1,"end":2}]}`, true},
+		{`{"offset_encoding":"runes","meta":[{"type":"nop","begin":1,"end":2}],"type":"kythe0"}`, true},
+		{`{"meta":[{"type":"nop","begin":1,"end":2}],"offset_encoding":"runes","type":"kythe0"}`, true},
+	}
+	for _, test := range tests {
+		rs, err := Parse(strings.NewReader(test.input))
+		if err != nil {
+			t.Errorf("Parse %q failed: %v", test.input, err)
+			continue
+		}
+		want := Rules{{Begin: 1, End: 2, RuneOffsets: test.runes}}
+		if err := testutil.DeepEqual(want, rs); err != nil {
+			t.Errorf("Parse %q: %v", test.input, err)
+		}
+	}
+
+	for _, bad := range []string{
+		`{"type":"kythe0","offset_encoding":"utf-7","meta":[]}`,
+		`{"type":"kythe0","offset_encoding":3,"meta":[]}`,
+		// The rules have already been reported with byte offsets.
+		`{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2}],"offset_encoding":"runes"}`,
+	} {
+		if rs, err := Parse(strings.NewReader(bad)); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Parse %q: got %v, %v; want %v", bad, rs, err, ErrMalformed)
+		}
+	}
+
+	if bits, err := (Rules{{Begin: 1, End: 2}, {Begin: 3, End: 4, RuneOffsets: true}}).MarshalJSON(); err == nil {
+		t.Errorf("MarshalJSON of mixed offsets: got %s, wanted error", bits)
+	}
+}
+
+func TestParseOffsetBase(t *testing.T) {
+	const input = `{"type":"kythe0","offset_base":100,"meta":[
+           {"type":"nop","begin":0,"end":3},
+           {"type":"nop","begin":5,"end":5},
+           {"type":"anchor_anchor","target_begin":7,"target_end":9,"source_begin":1,"source_end":3,"edge":"/kythe/edge/imputes"},
+           {"type":"nop","begin_line":2,"begin_col":0,"end_line":2,"end_col":4},
+           {"type":"anchor_defines","edge":"%/kythe/edge/generates"}
+        ]}`
+	rs, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	// The base applies to every span of the generated file, but not to the
+	// target span, which is in another file, nor to positions or whole-file
+	// rules.
+	want := Rules{
+		{Begin: 100, End: 103},
+		{Begin: 105, End: 105},
+		{Begin: 107, End: 109, EdgeIn: edges.DefinesBinding, EdgeOut: AnchorAnchorEdge, Reverse: true, TargetSpan: &Span{Begin: 1, End: 3}},
+		{Position: &LineSpan{BeginLine: 2, BeginCol: 0, EndLine: 2, EndCol: 4}},
+		{EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates, Reverse: true, WholeFile: true},
+	}
+	if err := testutil.DeepEqual(want, rs); err != nil {
+		t.Errorf("Parse: %v", err)
+	}
+
+	for _, bad := range []string{
+		`{"type":"kythe0","offset_base":-1,"meta":[]}`,
+		`{"type":"kythe0","offset_base":"1","meta":[]}`,
+		`{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2}],"offset_base":1}`,
+	} {
+		if rs, err := Parse(strings.NewReader(bad)); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Parse %q: got %v, %v; want %v", bad, rs, err, ErrMalformed)
+		}
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	// UTF-16 offsets:  é=0 😀=1,2 x=3 end=4
+	// Rune offsets:    é=0 😀=1 x=2 end=3
+	// Byte offsets:    é=0,1 😀=2..5 x=6 end=7
+	src := []byte("é😀x")
+	tests := []struct {
+		header string
+		begin  int
+		want   string
+	}{
+		{``, 6, EncodingUTF8Bytes},
+		{`"encoding":"utf-8-bytes",`, 6, EncodingUTF8Bytes},
+		{`"encoding":"utf-16",`, 3, EncodingUTF16},
+		{`"encoding":"runes",`, 2, EncodingRunes},
+		{`"offset_encoding":"runes",`, 2, EncodingRunes},
+		{`"offset_encoding":"runes","encoding":"runes",`, 2, EncodingRunes},
+	}
+	for _, test := range tests {
+		input := fmt.Sprintf(`{"type":"kythe0",%s"meta":[{"type":"nop","begin":%d,"end":%d}]}`,
+			test.header, test.begin, test.begin+1)
+		res, err := ParseWithWarnings(strings.NewReader(input), &ParseOptions{Strict: true})
+		if err != nil {
+			t.Errorf("Parse %q failed: %v", input, err)
+			continue
+		}
+		if got := res.Encoding(); got != test.want {
+			t.Errorf("Parse %q: got encoding %q, want %q", input, got, test.want)
+		}
+		if err := res.Normalize(src); err != nil {
+			t.Errorf("Normalize %q failed: %v", input, err)
+			continue
+		}
+		want := Rules{{Begin: 6, End: 7}}
+		if err := testutil.DeepEqual(want, res.Rules()); err != nil {
+			t.Errorf("Normalize %q: %v", input, err)
+		}
+		if got := res.Encoding(); got != EncodingUTF8Bytes {
+			t.Errorf("Normalize %q: got encoding %q, want %q", input, got, EncodingUTF8Bytes)
+		}
+	}
+
+	// An unknown encoding is rejected in strict mode, and cannot be
+	// normalized otherwise.
+	const unknown = `{"type":"kythe0","encoding":"ebcdic","meta":[{"type":"nop","begin":1,"end":2}]}`
+	if _, err := ParseWithWarnings(strings.NewReader(unknown), &ParseOptions{Strict: true}); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Parse %q: got error %v, want %v", unknown, err, ErrMalformed)
+	}
+	res, err := ParseWithWarnings(strings.NewReader(unknown), nil)
+	if err != nil {
+		t.Fatalf("Parse %q failed: %v", unknown, err)
+	}
+	if got := res.Encoding(); got != "ebcdic" {
+		t.Errorf("Parse %q: got encoding %q, want %q", unknown, got, "ebcdic")
+	}
+	if err := res.Normalize(src); err == nil {
+		t.Errorf("Normalize %q: got %v, wanted error", unknown, res.Rules())
+	}
+
+	for _, bad := range []string{
+		`{"type":"kythe0","offset_encoding":"runes","encoding":"utf-16","meta":[]}`,
+		`{"type":"kythe0","meta":[],"encoding":"utf-16"}`,
+	} {
+		if _, err := ParseWithWarnings(strings.NewReader(bad), nil); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Parse %q: got error %v, want %v", bad, err, ErrMalformed)
+		}
+	}
+}
+
+func TestParseVersions(t *testing.T) {
+	const rules = `[{"type":"anchor_defines","begin":1,"end":2,
+          "edge":"%/kythe/edge/generates","vname":{"signature":"s"}}]`
+	want, err := Parse(strings.NewReader(`{"type":"kythe0","meta":` + rules + `}`))
+	if err != nil {
+		t.Fatalf("Parse kythe0 failed: %v", err)
+	}
+	for _, input := range []string{
+		`{"type":"kythe1","meta":` + rules + `}`,
+		`{"type":"kythe1","future":{"x":[1,2]},"meta":` + rules + `,"more":true}`,
+		`{"meta":` + rules + `,"type":"kythe1"}`,
+	} {
+		for _, parse := range []func(io.Reader) (Rules, error){Parse, ParseStrict} {
+			got, err := parse(strings.NewReader(input))
+			if err != nil {
+				t.Errorf("Parse %q failed: %v", input, err)
+				continue
+			}
+			if err := testutil.DeepEqual(want, got); err != nil {
+				t.Errorf("Parse %q: %v", input, err)
+			}
+		}
+	}
+
+	const unknown = `{"type":"kythe99","meta":[]}`
+	if rs, err := ParseStrict(strings.NewReader(unknown)); !errors.Is(err, ErrMalformed) {
+		t.Errorf("ParseStrict %q: got (%+v, %v), want %v", unknown, rs, err, ErrMalformed)
+	}
+}
+
+func TestParseOffsets(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+           {"type":"nop","begin":1,"end":2},
+           {"type":"nop","begin":-1,"end":2},
+           {"type":"nop","begin":10,"end":5},
+           {"type":"nop","begin":-3,"end":-5}
+        ]}`
+
+	// In strict mode, each bad span should be reported.
+	_, err := ParseStrict(strings.NewReader(input))
+	if !errors.Is(err, ErrMalformed) {
+		t.Fatalf("ParseStrict: got error %v, want %v", err, ErrMalformed)
+	}
+	for _, want := range []string{
+		"rule 1: negative begin offset -1",
+		"rule 2: end offset 5 < begin offset 10",
+		"rule 3: negative begin offset -3",
+		"rule 3: negative end offset -5",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseStrict: error %q does not mention %q", err, want)
+		}
+	}
+
+	// In lenient mode, the spans should be repaired.
+	res, err := ParseWithWarnings(strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatalf("ParseWithWarnings failed: %v", err)
+	}
+	want := Rules{
+		{Begin: 1, End: 2},
+		{Begin: 0, End: 2},
+		{Begin: 5, End: 10},
+		{Begin: 0, End: 0},
+	}
+	if err := testutil.DeepEqual(want, res.Rules()); err != nil {
+		t.Errorf("ParseWithWarnings: %v", err)
+	}
+	var got []int
+	for _, w := range res.Warnings() {
+		t.Logf("Warning: %v", w)
+		got = append(got, w.Index)
+	}
+	if err := testutil.DeepEqual([]int{1, 2, 3, 3}, got); err != nil {
+		t.Errorf("ParseWithWarnings: wrong warning indices: %v", err)
+	}
+}
+
+func TestParseVerbose(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+{"type":"nop","begin":1,"end":2},
+{"type":"unknown_thing","begin":3,"end":4},
+{"type":"nop","begin":-1,"end":2},
+{"type":"nop","begin":10,"end":5}
+]}`
+	if rs, err := Parse(strings.NewReader(input)); err == nil {
+		t.Errorf("Parse: got %v, wanted error", rs)
+	}
+	res, err := ParseVerbose(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseVerbose failed: %v", err)
+	}
+	want := Rules{
+		{Begin: 1, End: 2},
+		{Begin: 0, End: 2},
+		{Begin: 5, End: 10},
+	}
+	if err := testutil.DeepEqual(want, res.Rules()); err != nil {
+		t.Errorf("ParseVerbose: %v", err)
+	}
+	type warning struct {
+		Index    int
+		Category WarningCategory
+	}
+	var got []warning
+	for _, w := range res.Warnings() {
+		t.Logf("Warning: %v (%v)", w, w.Category)
+		got = append(got, warning{w.Index, w.Category})
+	}
+	if err := testutil.DeepEqual([]warning{
+		{1, WarnUnknownType},
+		{2, WarnClampedOffset},
+		{3, WarnSwappedOffsets},
+	}, got); err != nil {
+		t.Errorf("ParseVerbose: wrong warnings: %v", err)
+	}
+}
+
+// roundTripTests are rule sets that should survive a round trip through each
+// of the encodings.
+var roundTripTests = []Rules{
+	nil,
+	Rules{},
+	Rules{{}},
+	Rules{
+		{},
+		{Begin: 25, End: 37, EdgeOut: "blah"},
+	},
+	Rules{{
+		Begin: 1,
+		End:   2,
+		Extra: map[string]json.RawMessage{"unknown": json.RawMessage(`[true]`)},
+	}},
+	Rules{{
+		VName: &spb.VName{
+			Signature: "gsig",
+			Corpus:    "gcorp",
+			Path:      "gpath",
+			Language:  "glang",
+			Root:      "groot",
+		},
+		Reverse: true,
+		EdgeIn:  edges.DefinesBinding,
+		EdgeOut: edges.Generates,
+		Begin:   179,
+		End:     182,
+	}},
+	Rules{{
+		VName:   &spb.VName{Signature: "rsig", Corpus: "rcorp"},
+		EdgeIn:  edges.Ref,
+		EdgeOut: edges.Generates,
+		Begin:   5,
+		End:     10,
+	}},
+	Rules{{
+		VName:      &spb.VName{Path: "src.ts"},
+		EdgeIn:     edges.DefinesBinding,
+		EdgeOut:    AnchorAnchorEdge,
+		Begin:      1,
+		End:        4,
+		TargetSpan: &Span{Begin: 0, End: 3},
+	}},
+	Rules{{
+		VName:   &spb.VName{Signature: "p"},
+		EdgeIn:  edges.DefinesBinding,
+		EdgeOut: edges.Param,
+		Ordinal: intPtr(0),
+	}},
+	Rules{{
+		VName:    &spb.VName{Signature: "pos"},
+		EdgeIn:   edges.DefinesBinding,
+		EdgeOut:  edges.Generates,
+		Position: &LineSpan{BeginLine: 10, BeginCol: 4, EndLine: 10, EndCol: 7},
+	}, {
+		Position: &LineSpan{BeginLine: 1, BeginCol: 2, EndLine: 1, EndCol: 4, Units: ColumnUTF16},
+	}},
+	Rules{
+		{Begin: 1, End: 2, EdgeIn: edges.Ref, EdgeOut: edges.Ref, VName: &spb.VName{Signature: "s"}, Score: floatPtr(0.75)},
+		{Begin: 3, End: 4, Score: floatPtr(0)},
+	},
+	Rules{
+		{Begin: 2, End: 5, EdgeIn: edges.Ref, EdgeOut: edges.Ref, VName: &spb.VName{Signature: "r"}, RuneOffsets: true},
+		{Begin: 7, End: 9, RuneOffsets: true},
+	},
+}
+
+func TestParseLargeOffsets(t *testing.T) {
+	const big = int64(1) << 33 // beyond the range of a 32-bit int
+	input := fmt.Sprintf(`{"type":"kythe0","meta":[{"type":"nop","begin":%d,"end":%d}]}`, big, big+5)
+	rs, err := Parse(strings.NewReader(input))
+	if strconv.IntSize < 64 {
+		// The offsets do not fit, and should be reported rather than
+		// truncated.
+		if err == nil {
+			t.Errorf("Parse %q: got %v, wanted error", input, rs)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Parse %q failed: %v", input, err)
+	}
+	if got := rs[0]; got.Begin64() != big || got.End64() != big+5 {
+		t.Errorf("Parse %q: got offsets [%d, %d), want [%d, %d)", input, got.Begin64(), got.End64(), big, big+5)
+	}
+	if enc, err := json.Marshal(rs); err != nil {
+		t.Errorf("Encoding %v failed: %v", rs, err)
+	} else if !strings.Contains(string(enc), fmt.Sprint(big)) {
+		t.Errorf("Encoding %v: got %s, want offset %d", rs, enc, big)
+	}
+
+	if _, err := checkOffset(maxInt); err != nil {
+		t.Errorf("checkOffset(%d) failed: %v", maxInt, err)
+	}
+}
+
+func TestAnchorAnchorCXXFormat(t *testing.T) {
+	// This is the fixture kythe/cxx/indexer/cxx/testdata/metadata/self.meta,
+	// which the C++ indexer reads as a MappingRule.
+	const input = `{
+  "type": "kythe0",
+  "meta": [
+    {
+      "type": "anchor_anchor",
+      "source_begin": 184,
+      "source_end": 187,
+      "target_begin": 517,
+      "target_end": 518,
+      "edge": "/kythe/edge/imputes",
+      "source_vname": {
+        "corpus": "sourcecorpus",
+        "path": "sourcepath",
+        "root": "sourceroot"
+      }
+    }
+  ]
+}`
+	rs, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := Rules{{
+		Begin:      517,
+		End:        518,
+		EdgeIn:     edges.DefinesBinding,
+		EdgeOut:    AnchorAnchorEdge,
+		Reverse:    true,
+		VName:      &spb.VName{Corpus: "sourcecorpus", Path: "sourcepath", Root: "sourceroot"},
+		TargetSpan: &Span{Begin: 184, End: 187},
+	}}
+	if err := testutil.DeepEqual(want, rs); err != nil {
+		t.Errorf("Parse: %v", err)
+	}
+
+	// Encoding the rules should reproduce the fixture.
+	bits, err := json.Marshal(rs)
+	if err != nil {
+		t.Fatalf("Encoding %+v failed: %v", rs, err)
+	}
+	var got, wantJSON interface{}
+	if err := json.Unmarshal(bits, &got); err != nil {
+		t.Fatalf("Decoding %s failed: %v", bits, err)
+	}
+	if err := json.Unmarshal([]byte(input), &wantJSON); err != nil {
+		t.Fatalf("Decoding fixture failed: %v", err)
+	}
+	if err := testutil.DeepEqual(wantJSON, got); err != nil {
+		t.Errorf("MarshalJSON: got %s: %v", bits, err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, test := range roundTripTests {
+		enc, err := json.Marshal(test)
+		if err != nil {
+			t.Errorf("Encoding %+v failed: %v", test, err)
+			continue
+		}
+
+		dec, err := Parse(bytes.NewReader(enc))
+		if err != nil {
+			t.Errorf("Decoding %q failed: %v", string(enc), err)
+			continue
+		}
+
+		if err := testutil.DeepEqual(test, dec); err != nil {
+			t.Errorf("Round-trip of %+v failed: %v", test, err)
+		}
+	}
+}
+
+func intPtr(i int) *int { return &i }
+
+func floatPtr(f float64) *float64 { return &f }
+
+func TestEdgeKind(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		want string
+	}{
+		{Rule{}, ""},
+		{Rule{EdgeOut: edges.Generates}, edges.Generates},
+		{Rule{EdgeOut: edges.Param, Ordinal: intPtr(0)}, edges.ParamIndex(0)},
+		{Rule{EdgeOut: edges.Param, Ordinal: intPtr(3)}, edges.ParamIndex(3)},
+	}
+	for _, test := range tests {
+		if got := test.rule.EdgeKind(); got != test.want {
+			t.Errorf("EdgeKind %+v: got %q, want %q", test.rule, got, test.want)
+		}
+	}
+
+	// An absent ordinal is omitted from the encoding.
+	enc, err := json.Marshal(Rules{{EdgeOut: edges.Param}})
+	if err != nil {
+		t.Fatalf("Encoding failed: %v", err)
+	}
+	if strings.Contains(string(enc), "ordinal") {
+		t.Errorf("Encoding %q: unexpected ordinal", string(enc))
+	}
+}
+
+func TestEffectiveEdge(t *testing.T) {
+	tests := []struct {
+		rule     Rule
+		kind     string
+		reversed bool
+	}{
+		{Rule{}, "", false},
+		{Rule{EdgeOut: edges.Generates}, edges.Generates, false},
+		{Rule{EdgeOut: edges.Generates, Reverse: true}, edges.Generates, true},
+		{Rule{EdgeOut: "%" + edges.Generates}, edges.Generates, true},
+		{Rule{EdgeOut: "%" + edges.Generates, Reverse: true}, edges.Generates, true},
+		{Rule{EdgeOut: edges.Param, Ordinal: intPtr(1)}, edges.ParamIndex(1), false},
+	}
+	for _, test := range tests {
+		kind, reversed := test.rule.EffectiveEdge()
+		if kind != test.kind || reversed != test.reversed {
+			t.Errorf("EffectiveEdge %+v: got (%q, %v), want (%q, %v)",
+				test.rule, kind, reversed, test.kind, test.reversed)
+		}
+	}
+
+	// A decoded reverse edge resolves the same way.
+	rs, err := Parse(strings.NewReader(`{"type":"kythe0","meta":[{"type":"anchor_defines",
+"begin":1,"end":2,"edge":"%/kythe/edge/generates","vname":{"signature":"s"}}]}`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if kind, reversed := rs[0].EffectiveEdge(); kind != edges.Generates || !reversed {
+		t.Errorf("EffectiveEdge %v: got (%q, %v), want (%q, true)", rs[0], kind, reversed, edges.Generates)
+	}
+}
+
+func TestRuleEqual(t *testing.T) {
+	base := Rule{
+		Begin:   1,
+		End:     4,
+		EdgeIn:  edges.DefinesBinding,
+		EdgeOut: edges.Generates,
+		VName:   &spb.VName{Signature: "s", Corpus: "c"},
+		Ordinal: intPtr(2),
+		Score:   floatPtr(0.5),
+		Extra:   map[string]json.RawMessage{"x": json.RawMessage(`1`)},
+	}
+	// A copy whose pointer fields are distinct from those of base.
+	same := base.clone()
+	if same.VName == base.VName || same.Ordinal == base.Ordinal {
+		t.Fatal("clone shares pointers with its receiver")
+	}
+	if !base.Equal(same) || !same.Equal(base) {
+		t.Errorf("Equal(%v, %v): got false, want true", base, same)
+	}
+	if !(Rule{}).Equal(Rule{VName: &spb.VName{}, Extra: map[string]json.RawMessage{}}) {
+		t.Error("Equal: an unset vname and Extra map differ from empty ones")
+	}
+	// Merge and Diff compare vnames by value as well.
+	if got := (Rules{base}).Merge(Rules{same}); len(got) != 1 {
+		t.Errorf("Merge of equal rules: got %v, want 1 rule", got)
+	}
+	if added, removed := Diff(Rules{base}, Rules{same}); added != nil || removed != nil {
+		t.Errorf("Diff of equal rules: got %v, %v; want none", added, removed)
+	}
+
+	for _, change := range []func(*Rule){
+		func(r *Rule) { r.End++ },
+		func(r *Rule) { r.Reverse = true },
+		func(r *Rule) { r.VName.Language = "go" },
+		func(r *Rule) { r.VName = nil },
+		func(r *Rule) { r.Ordinal = intPtr(0) },
+		func(r *Rule) { r.Ordinal = nil },
+		func(r *Rule) { r.Score = nil },
+		func(r *Rule) { r.TargetSpan = &Span{} },
+		func(r *Rule) { r.Position = &LineSpan{BeginLine: 1, EndLine: 1} },
+		func(r *Rule) { r.Extra["x"] = json.RawMessage(`2`) },
+		func(r *Rule) { r.Extra = nil },
+		func(r *Rule) { r.WholeFile = true },
+	} {
+		other := base.clone()
+		change(&other)
+		if base.Equal(other) || other.Equal(base) {
+			t.Errorf("Equal(%+v, %+v): got true, want false", base, other)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	vname := &spb.VName{Signature: "gsig", Corpus: "gcorp", Path: "gpath"}
+	tests := []struct {
+		rule Rule
+		want string
+	}{
+		{Rule{}, "[0,0) nop"},
+		{Rule{
+			Begin:   179,
+			End:     182,
+			EdgeIn:  edges.DefinesBinding,
+			EdgeOut: edges.Generates,
+			Reverse: true,
+			VName:   vname,
+		}, "[179,182) defines/binding <- generates @ gcorp:gpath:gsig"},
+		{Rule{
+			Begin:   1,
+			End:     2,
+			EdgeIn:  edges.Ref,
+			EdgeOut: edges.Param,
+			Ordinal: intPtr(1),
+			VName:   &spb.VName{Signature: "s", Language: "go"},
+		}, "[1,2) ref -> param.1 @ ::s (go)"},
+	}
+	for _, test := range tests {
+		if got := test.rule.String(); got != test.want {
+			t.Errorf("String %+v:\n got %q\nwant %q", test.rule, got, test.want)
+		}
+	}
+
+	rs := Rules{
+		{},
+		{EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates},
+		{EdgeIn: edges.Ref, EdgeOut: edges.Generates, Reverse: true},
+	}
+	if got, want := rs.String(), "3 rules: generates=2, nop=1"; got != want {
+		t.Errorf("String %+v: got %q, want %q", rs, got, want)
+	}
+	if got, want := Rules(nil).String(), "0 rules"; got != want {
+		t.Errorf("String nil: got %q, want %q", got, want)
+	}
+}
+
+func TestSort(t *testing.T) {
+	vname := func(sig string) *spb.VName { return &spb.VName{Signature: sig} }
+	input := Rules{
+		{Begin: 5, End: 9, EdgeOut: "b"},
+		{Begin: 5, End: 9, EdgeOut: "a", VName: vname("2")},
+		{Begin: 1, End: 20},
+		{Begin: 5, End: 7},
+		{},
+		{Begin: 5, End: 9, EdgeOut: "a", VName: vname("1")},
+		{Begin: 5, End: 9, EdgeOut: "a"},
+	}
+	want := Rules{
+		{},
+		{Begin: 1, End: 20},
+		{Begin: 5, End: 7},
+		{Begin: 5, End: 9, EdgeOut: "a"},
+		{Begin: 5, End: 9, EdgeOut: "a", VName: vname("1")},
+		{Begin: 5, End: 9, EdgeOut: "a", VName: vname("2")},
+		{Begin: 5, End: 9, EdgeOut: "b"},
+	}
+	input.Sort()
+	if err := testutil.DeepEqual(want, input); err != nil {
+		t.Errorf("Sort: %v", err)
+	}
+
+	// Rules that differ only in the remaining fields are ordered too, so the
+	// result does not depend on the input order.
+	want = Rules{
+		{Begin: 1},
+		{Begin: 1, Extra: map[string]json.RawMessage{"a": json.RawMessage(`1`)}},
+		{Begin: 1, Extra: map[string]json.RawMessage{"a": json.RawMessage(`2`)}},
+		{Begin: 1, Extra: map[string]json.RawMessage{"a": json.RawMessage(`2`), "b": json.RawMessage(`0`)}},
+		{Begin: 1, Extra: map[string]json.RawMessage{"b": json.RawMessage(`0`)}},
+		{Begin: 1, Score: floatPtr(0)},
+		{Begin: 1, Score: floatPtr(0.5)},
+		{Begin: 1, Position: &LineSpan{BeginLine: 1}},
+		{Begin: 1, Position: &LineSpan{BeginLine: 1, Units: ColumnUTF16}},
+		{Begin: 1, TargetSpan: &Span{Begin: 0, End: 2}},
+		{Begin: 1, TargetSpan: &Span{Begin: 1, End: 1}},
+		{Begin: 1, Ordinal: intPtr(0)},
+		{Begin: 1, Ordinal: intPtr(1)},
+		{Begin: 1, RuneOffsets: true},
+		{Begin: 1, Semantic: SemanticSet},
+	}
+	for shift := range want {
+		input := append(append(Rules(nil), want[shift:]...), want[:shift]...)
+		for i, j := 0, len(input)-1; i < j; i, j = i+1, j-1 {
+			input[i], input[j] = input[j], input[i]
+		}
+		input.Sort()
+		if err := testutil.DeepEqual(want, input); err != nil {
+			t.Errorf("Sort of rotation %d: %v", shift, err)
+		}
+	}
+}
+
+func TestMarshalIndent(t *testing.T) {
+	tests := []struct {
+		rules Rules
+		want  string
+	}{
+		{nil, "{\n  \"type\": \"kythe0\"\n}"},
+		{Rules{}, "{\n  \"type\": \"kythe0\"\n}"},
+		{Rules{{Begin: 1, End: 2}}, `{
+  "type": "kythe0",
+  "meta": [
+    {
+      "type": "nop",
+      "begin": 1,
+      "end": 2
+    }
+  ]
+}`},
+	}
+	for _, test := range tests {
+		got, err := test.rules.MarshalIndent("", "  ")
+		if err != nil {
+			t.Errorf("MarshalIndent %+v failed: %v", test.rules, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("MarshalIndent %+v:\ngot:\n%s\nwant:\n%s", test.rules, got, test.want)
+		}
+		if _, err := Parse(bytes.NewReader(got)); err != nil {
+			t.Errorf("Parse of %q failed: %v", got, err)
+		}
+	}
+}
+
+func TestMarshalCanonical(t *testing.T) {
+	rs := Rules{
+		{Begin: 10, End: 20, EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates,
+			VName: &spb.VName{Signature: "b", Corpus: "c", Path: "p"}},
+		{Begin: 1, End: 2, Extra: map[string]json.RawMessage{"z": json.RawMessage(`{"y":1,"x":2}`)}},
+	}
+	got, err := rs.MarshalCanonical()
+	if err != nil {
+		t.Fatalf("MarshalCanonical failed: %v", err)
+	}
+	const want = `{"meta":[` +
+		`{"begin":1,"end":2,"type":"nop","z":{"x":2,"y":1}},` +
+		`{"begin":10,"edge":"/kythe/edge/generates","end":20,"type":"anchor_defines",` +
+		`"vname":{"corpus":"c","path":"p","signature":"b"}}],"type":"kythe0"}`
+	if string(got) != want {
+		t.Errorf("MarshalCanonical:\ngot  %s\nwant %s", got, want)
+	}
+	if rs[0].Begin != 10 {
+		t.Errorf("MarshalCanonical modified its receiver: %v", rs)
+	}
+
+	// The order of the input does not matter.
+	rev := Rules{rs[1], rs[0]}
+	if again, err := rev.MarshalCanonical(); err != nil {
+		t.Errorf("MarshalCanonical failed: %v", err)
+	} else if string(again) != string(got) {
+		t.Errorf("MarshalCanonical of reordered rules:\ngot  %s\nwant %s", again, got)
+	}
+	if _, err := Parse(bytes.NewReader(got)); err != nil {
+		t.Errorf("Parse of canonical encoding failed: %v", err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	orig := Rules{{
+		Begin:      1,
+		End:        2,
+		EdgeIn:     edges.DefinesBinding,
+		EdgeOut:    edges.Generates,
+		VName:      &spb.VName{Corpus: "c", Signature: "s"},
+		TargetSpan: &Span{Begin: 3, End: 4},
+		Ordinal:    intPtr(1),
+		Position:   &LineSpan{BeginLine: 1, EndLine: 1, EndCol: 1},
+		Extra:      map[string]json.RawMessage{"x": json.RawMessage(`[1]`)},
+	}, {}}
+	clone := orig.Clone()
+	if err := testutil.DeepEqual(orig, clone); err != nil {
+		t.Fatalf("Clone: %v", err)
+	}
+
+	clone[0].VName.Corpus = "changed"
+	clone[0].TargetSpan.Begin = 0
+	*clone[0].Ordinal = 5
+	clone[0].Position.EndCol = 9
+	clone[0].Extra["x"][1] = '2'
+	clone[0].Extra["y"] = json.RawMessage(`true`)
+	want := Rule{
+		Begin:      1,
+		End:        2,
+		EdgeIn:     edges.DefinesBinding,
+		EdgeOut:    edges.Generates,
+		VName:      &spb.VName{Corpus: "c", Signature: "s"},
+		TargetSpan: &Span{Begin: 3, End: 4},
+		Ordinal:    intPtr(1),
+		Position:   &LineSpan{BeginLine: 1, EndLine: 1, EndCol: 1},
+		Extra:      map[string]json.RawMessage{"x": json.RawMessage(`[1]`)},
+	}
+	if err := testutil.DeepEqual(want, orig[0]); err != nil {
+		t.Errorf("Modifying the clone changed the original: %v", err)
+	}
+	if Rules(nil).Clone() != nil {
+		t.Error("Clone of nil rules is not nil")
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	a := Rules{
+		{Begin: 10, End: 20, EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates, Reverse: true,
+			VName: &spb.VName{Corpus: " c ", Signature: "sig\n"}},
+		{Begin: 1, End: 2, EdgeIn: edges.DefinesBinding, VName: &spb.VName{Path: "a.ts"},
+			TargetSpan: &Span{Begin: 3, End: 4}},
+		{Begin: 1, End: 2, Semantic: SemanticSet, Extra: map[string]json.RawMessage{}},
+		{Begin: 1, End: 2},
+	}
+	b := Rules{
+		{Begin: 1, End: 2},
+		{Begin: 1, End: 2, Semantic: SemanticSet},
+		{Begin: 1, End: 2, EdgeIn: edges.DefinesBinding, EdgeOut: AnchorAnchorEdge,
+			VName: &spb.VName{Path: "a.ts"}, TargetSpan: &Span{Begin: 3, End: 4}},
+		{Begin: 10, End: 20, EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates, Reverse: true,
+			VName: &spb.VName{Corpus: "c", Signature: "sig"}},
+	}
+	ca, cb := a.Canonicalize(), b.Canonicalize()
+	if err := testutil.DeepEqual(ca, cb); err != nil {
+		t.Errorf("Canonicalize: %v", err)
+	}
+	if err := testutil.DeepEqual(b, cb); err != nil {
+		t.Errorf("Canonicalize of canonical rules: %v", err)
+	}
+	if c := a[0].VName.Corpus; c != " c " {
+		t.Errorf("Canonicalize modified its receiver: corpus is %q", c)
+	}
+
+	// Reordering the input does not change the result.
+	rev := Rules{b[2], b[3], b[1], b[0]}
+	if err := testutil.DeepEqual(cb, rev.Canonicalize()); err != nil {
+		t.Errorf("Canonicalize of reordered rules: %v", err)
+	}
+}
+
+func TestExtraFields(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+           {"type":"nop","begin":1,"end":2,"future":{"a":[1,2,3]},"note":"hi"},
+           {"type":"nop","begin":3,"end":4}
+        ]}`
+	rs, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := Rules{{
+		Begin: 1,
+		End:   2,
+		Extra: map[string]json.RawMessage{
+			"future": json.RawMessage(`{"a":[1,2,3]}`),
+			"note":   json.RawMessage(`"hi"`),
+		},
+	}, {
+		Begin: 3,
+		End:   4,
+	}}
+	if err := testutil.DeepEqual(want, rs); err != nil {
+		t.Errorf("Parse: %v", err)
+	}
+
+	// Re-encoding should preserve the extra fields.
+	enc, err := json.Marshal(rs)
+	if err != nil {
+		t.Fatalf("Encoding %+v failed: %v", rs, err)
+	}
+	dec, err := Parse(bytes.NewReader(enc))
+	if err != nil {
+		t.Fatalf("Decoding %q failed: %v", string(enc), err)
+	}
+	if err := testutil.DeepEqual(rs, dec); err != nil {
+		t.Errorf("Round-trip of %q failed: %v", string(enc), err)
+	}
+}
+
+func TestGeneratedCodeInfo(t *testing.T) {
+	in := &protopb.GeneratedCodeInfo{
+		Annotation: []*protopb.GeneratedCodeInfo_Annotation{{
+			Path:       []int32{1, 2, 3, 4, 5},
+			SourceFile: proto.String("a"),
+			Begin:      proto.Int(1),
+			End:        proto.Int(100),
+		}},
+	}
+	want := Rules{{
+		VName: &spb.VName{
+			Signature: "1.2.3.4.5",
+			Language:  "protobuf",
+			Path:      "a",
+		},
+		Reverse: true,
+		EdgeIn:  edges.DefinesBinding,
+		EdgeOut: edges.Generates,
+		Begin:   1,
+		End:     100,
+	}}
+	{
+		got := FromGeneratedCodeInfo(in, nil)
+		if err := testutil.DeepEqual(got, want); err != nil {
+			t.Errorf("FromGeneratedCodeInfo failed: %v", err)
+		}
+	}
+	{
+		got := FromGeneratedCodeInfo(in, &spb.VName{
+			Corpus: "blargh",
+		})
+		want[0].VName.Corpus = "blargh"
+		if err := testutil.DeepEqual(got, want); err != nil {
+			t.Errorf("FromGeneratedCodeInfo failed: %v", err)
+		}
+	}
+}
+
+func TestGeneratedCodeInfoNoSpan(t *testing.T) {
+	in := &protopb.GeneratedCodeInfo{
+		Annotation: []*protopb.GeneratedCodeInfo_Annotation{{
+			Path:       []int32{4, 0},
+			SourceFile: proto.String("a"),
+		}, {
+			Path:       []int32{4, 1},
+			SourceFile: proto.String("a"),
+			Begin:      proto.Int(3),
+			End:        proto.Int(5),
+		}, nil},
+	}
+	want := Rules{{
+		VName:   &spb.VName{Signature: "4.1", Language: "protobuf", Path: "a"},
+		Reverse: true,
+		EdgeIn:  edges.DefinesBinding,
+		EdgeOut: edges.Generates,
+		Begin:   3,
+		End:     5,
+	}}
+	if err := testutil.DeepEqual(want, FromGeneratedCodeInfo(in, nil)); err != nil {
+		t.Errorf("FromGeneratedCodeInfo failed: %v", err)
+	}
+}
+
+func TestGeneratedCodeInfoOrder(t *testing.T) {
+	anno := func(begin, end int, path ...int32) *protopb.GeneratedCodeInfo_Annotation {
+		return &protopb.GeneratedCodeInfo_Annotation{
+			Path:       path,
+			SourceFile: proto.String("a"),
+			Begin:      proto.Int(begin),
+			End:        proto.Int(end),
+		}
+	}
+	in := &protopb.GeneratedCodeInfo{
+		Annotation: []*protopb.GeneratedCodeInfo_Annotation{
+			anno(20, 25, 4, 2),
+			anno(5, 9, 4, 1, 2),
+			anno(5, 7, 4, 1, 3),
+			anno(5, 7, 4, 1, 1),
+			anno(0, 30, 4),
+		},
+	}
+	var got []string
+	for _, r := range FromGeneratedCodeInfo(in, nil) {
+		got = append(got, fmt.Sprintf("%d:%d:%s", r.Begin, r.End, r.VName.Signature))
+	}
+	want := []string{"0:30:4", "5:7:4.1.1", "5:7:4.1.3", "5:9:4.1.2", "20:25:4.2"}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("FromGeneratedCodeInfo order: %v", err)
+	}
+}
+
+func TestGeneratedCodeInfoDuplicates(t *testing.T) {
+	anno := func(sig int32) *protopb.GeneratedCodeInfo_Annotation {
+		return &protopb.GeneratedCodeInfo_Annotation{
+			Path:       []int32{4, sig},
+			SourceFile: proto.String("a"),
+			Begin:      proto.Int(1),
+			End:        proto.Int(5),
+		}
+	}
+	in := &protopb.GeneratedCodeInfo{
+		Annotation: []*protopb.GeneratedCodeInfo_Annotation{anno(0), anno(1), anno(0)},
+	}
+	got := FromGeneratedCodeInfo(in, nil)
+	if len(got) != 2 {
+		t.Fatalf("FromGeneratedCodeInfo: got %d rules, want 2: %v", len(got), got)
+	}
+	for i, sig := range []string{"4.0", "4.1"} {
+		if s := got[i].VName.Signature; s != sig {
+			t.Errorf("Rule %d: got signature %q, want %q", i, s, sig)
+		}
+	}
+}
+
+func TestGeneratedCodeInfoFormatPath(t *testing.T) {
+	in := &protopb.GeneratedCodeInfo{
+		Annotation: []*protopb.GeneratedCodeInfo_Annotation{{
+			Path:       []int32{4, 0, 2, 1},
+			SourceFile: proto.String("a"),
+			Begin:      proto.Int(1),
+			End:        proto.Int(5),
+		}},
+	}
+	for _, test := range []struct {
+		opts *GeneratedCodeOptions
+		want string
+	}{
+		{nil, "4.0.2.1"},
+		{&GeneratedCodeOptions{}, "4.0.2.1"},
+		{&GeneratedCodeOptions{FormatPath: func(path []int32) string {
+			return fmt.Sprintf("message[%d].field[%d]", path[1], path[3])
+		}}, "message[0].field[1]"},
+	} {
+		rs := FromGeneratedCodeInfoOpts(in, nil, test.opts)
+		if len(rs) != 1 {
+			t.Fatalf("FromGeneratedCodeInfoOpts: got %d rules, want 1", len(rs))
+		}
+		if got := rs[0].VName.Signature; got != test.want {
+			t.Errorf("FromGeneratedCodeInfoOpts(%+v): got signature %q, want %q", test.opts, got, test.want)
+		}
+	}
+}
+
+func TestGeneratedCodeInfoLanguage(t *testing.T) {
+	in := &protopb.GeneratedCodeInfo{
+		Annotation: []*protopb.GeneratedCodeInfo_Annotation{{
+			Path:       []int32{4, 0},
+			SourceFile: proto.String("a"),
+			Begin:      proto.Int(1),
+			End:        proto.Int(5),
+		}},
+	}
+	for _, test := range []struct {
+		opts *GeneratedCodeOptions
+		want string
+	}{
+		{nil, "protobuf"},
+		{&GeneratedCodeOptions{}, "protobuf"},
+		{&GeneratedCodeOptions{Language: "grpc"}, "grpc"},
+	} {
+		rs := FromGeneratedCodeInfoOpts(in, nil, test.opts)
+		if len(rs) != 1 {
+			t.Fatalf("FromGeneratedCodeInfoOpts: got %d rules, want 1", len(rs))
+		}
+		if got := rs[0].VName.Language; got != test.want {
+			t.Errorf("FromGeneratedCodeInfoOpts(%+v): got language %q, want %q", test.opts, got, test.want)
+		}
+	}
+}
+
+func TestGeneratedCodeInfoSemantic(t *testing.T) {
+	anno := func(sem Semantic) *protopb.GeneratedCodeInfo_Annotation {
+		a := &protopb.GeneratedCodeInfo_Annotation{
+			Path:       []int32{4, 0},
+			SourceFile: proto.String("a"),
+			Begin:      proto.Int(1),
+			End:        proto.Int(10),
+		}
+		if sem != SemanticNone {
+			// The semantic field is not known to the descriptor package, so
+			// record it as an unknown field.
+			b := protowire.AppendTag(nil, annoSemanticField, protowire.VarintType)
+			a.ProtoReflect().SetUnknown(protowire.AppendVarint(b, uint64(sem)))
+		}
+		return a
+	}
+	in := &protopb.GeneratedCodeInfo{
+		Annotation: []*protopb.GeneratedCodeInfo_Annotation{
+			anno(SemanticNone), anno(SemanticSet), anno(SemanticAlias),
+		},
+	}
+	vname := &spb.VName{Signature: "4.0", Language: "protobuf", Path: "a"}
+	want := Rules{{
+		VName:   vname,
+		Reverse: true,
+		EdgeIn:  edges.DefinesBinding,
+		EdgeOut: edges.Generates,
+		Begin:   1,
+		End:     10,
+	}, {
+		VName:    vname,
+		Reverse:  true,
+		EdgeIn:   edges.DefinesBinding,
+		EdgeOut:  edges.Generates,
+		Begin:    1,
+		End:      10,
+		Semantic: SemanticSet,
+	}, {
+		VName:    vname,
+		EdgeIn:   edges.DefinesBinding,
+		EdgeOut:  "/kythe/edge/aliases",
+		Begin:    1,
+		End:      10,
+		Semantic: SemanticAlias,
+	}}
+	got := FromGeneratedCodeInfo(in, nil)
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("FromGeneratedCodeInfo failed: %v", err)
+	}
+
+	// The semantic should survive a round trip through JSON.
+	enc, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("Encoding %+v failed: %v", got, err)
+	}
+	dec, err := Parse(bytes.NewReader(enc))
+	if err != nil {
+		t.Fatalf("Decoding %q failed: %v", string(enc), err)
+	}
+	if err := testutil.DeepEqual(got, dec); err != nil {
+		t.Errorf("Round-trip of %q failed: %v", string(enc), err)
+	}
+}
+
+func TestGeneratedCodeInfoByFile(t *testing.T) {
+	anno := func(file string, path ...int32) *protopb.GeneratedCodeInfo_Annotation {
+		return &protopb.GeneratedCodeInfo_Annotation{
+			Path:       path,
+			SourceFile: proto.String(file),
+			Begin:      proto.Int(int(path[0])),
+			End:        proto.Int(int(path[0]) + 1),
+		}
+	}
+	in := &protopb.GeneratedCodeInfo{
+		Annotation: []*protopb.GeneratedCodeInfo_Annotation{
+			anno("a.proto", 4, 0), anno("b.proto", 5, 1), anno("a.proto", 6, 2, 1),
+		},
+	}
+	rule := func(file, sig string, begin int) Rule {
+		return Rule{
+			VName: &spb.VName{
+				Corpus:    "c",
+				Path:      file,
+				Language:  "protobuf",
+				Signature: sig,
+			},
+			Reverse: true,
+			EdgeIn:  edges.DefinesBinding,
+			EdgeOut: edges.Generates,
+			Begin:   begin,
+			End:     begin + 1,
+		}
+	}
+	want := map[string]Rules{
+		"a.proto": {rule("a.proto", "4.0", 4), rule("a.proto", "6.2.1", 6)},
+		"b.proto": {rule("b.proto", "5.1", 5)},
+	}
+	got := FromGeneratedCodeInfoByFile(in, &spb.VName{Corpus: "c"})
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("FromGeneratedCodeInfoByFile failed: %v", err)
+	}
+}
+
+func TestToGeneratedCodeInfo(t *testing.T) {
+	in := &protopb.GeneratedCodeInfo{
+		Annotation: []*protopb.GeneratedCodeInfo_Annotation{{
+			Path:       []int32{1, 2, 3, 4, 5},
+			SourceFile: proto.String("a"),
+			Begin:      proto.Int(1),
+			End:        proto.Int(100),
+		}, {
+			Path:       []int32{4, 0},
+			SourceFile: proto.String("b"),
+			Begin:      proto.Int(7),
+			End:        proto.Int(9),
+		}},
+	}
+	alias := proto.Clone(in.Annotation[1]).(*protopb.GeneratedCodeInfo_Annotation)
+	b := protowire.AppendTag(nil, annoSemanticField, protowire.VarintType)
+	alias.ProtoReflect().SetUnknown(protowire.AppendVarint(b, uint64(SemanticAlias)))
+	in.Annotation = append(in.Annotation, alias)
+
+	rs := FromGeneratedCodeInfo(in, nil)
+	got, err := append(rs, Rule{Begin: 5}).ToGeneratedCodeInfo() // the nop is skipped
+	if err != nil {
+		t.Fatalf("ToGeneratedCodeInfo failed: %v", err)
+	}
+	if !proto.Equal(got, in) {
+		t.Errorf("ToGeneratedCodeInfo: got %v, want %v", got, in)
+	}
+	if err := testutil.DeepEqual(rs, FromGeneratedCodeInfo(got, nil)); err != nil {
+		t.Errorf("Round trip failed: %v", err)
+	}
+
+	for _, bad := range []Rule{
+		{EdgeIn: edges.Ref, EdgeOut: edges.Generates, Reverse: true, VName: &spb.VName{}},
+		{EdgeIn: edges.DefinesBinding, EdgeOut: "/custom/edge", VName: &spb.VName{}},
+		{EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates, Reverse: true},
+		{EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates, Reverse: true, VName: &spb.VName{Signature: "x.1"}},
+	} {
+		if got, err := (Rules{bad}).ToGeneratedCodeInfo(); err == nil {
+			t.Errorf("ToGeneratedCodeInfo(%v): got %v, wanted error", bad, got)
+		} else {
+			t.Logf("ToGeneratedCodeInfo(%v): %v", bad, err)
 		}
 	}
 }
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package metadatatest contains utilities to test indexers that apply
+// metadata rules.
+package metadatatest // import "kythe.io/kythe/go/util/metadata/metadatatest"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/util/metadata"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+var update = flag.Bool("update", false, "Whether to rewrite golden files rather than comparing against them")
+
+// CompareApplied applies rules to the given anchors of file, as
+// Rules.ApplyAll, and reports a test failure unless the entries produced are
+// those in the golden file at goldenPath. The golden file holds one entry per
+// line in JSON format; the order of the entries in the file, and of those
+// produced, is not significant.
+//
+// If the test binary is run with the -update flag, the golden file is
+// rewritten with the entries produced instead.
+func CompareApplied(t *testing.T, rules metadata.Rules, anchors []metadata.AnchorSpan, file *spb.VName, goldenPath string) {
+	t.Helper()
+	got, err := entryLines(rules.ApplyAll(anchors, file))
+	if err != nil {
+		t.Fatalf("Encoding entries: %v", err)
+	}
+	if *update {
+		if err := ioutil.WriteFile(goldenPath, []byte(strings.Join(got, "")), 0644); err != nil {
+			t.Fatalf("Updating golden file: %v", err)
+		}
+		t.Logf("Wrote %d entries to %s", len(got), goldenPath)
+		return
+	}
+
+	want, err := readGolden(goldenPath)
+	if os.IsNotExist(err) {
+		t.Fatalf("Golden file %s does not exist; run with -update to create it", goldenPath)
+	} else if err != nil {
+		t.Fatalf("Reading golden file: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Entries differ from %s (-want +got):\n%s", goldenPath, diff)
+	}
+}
+
+// readGolden reads the entries of the golden file at path, and returns their
+// encodings as produced by entryLines.
+func readGolden(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*spb.Entry
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Buffer(nil, len(data)+1)
+	for line := 1; s.Scan(); line++ {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		e := new(spb.Entry)
+		if err := protojson.Unmarshal(s.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return entryLines(entries)
+}
+
+// entryLines encodes each of entries as a line of compact JSON, and returns
+// the lines in sorted order.
+func entryLines(entries []*spb.Entry) ([]string, error) {
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		bits, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		// The output of protojson is deliberately unstable; compact it so
+		// that equal entries encode identically.
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, bits); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		lines[i] = buf.String()
+	}
+	sort.Strings(lines)
+	return lines, nil
+}
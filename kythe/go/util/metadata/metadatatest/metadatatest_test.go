@@ -0,0 +1,54 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadatatest
+
+import (
+	"testing"
+
+	"kythe.io/kythe/go/util/metadata"
+	"kythe.io/kythe/go/util/schema/edges"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+func TestCompareApplied(t *testing.T) {
+	file := &spb.VName{Corpus: "c", Path: "gen/foo.pb.go", Language: "go"}
+	rules := metadata.Rules{{
+		Begin:   10,
+		End:     13,
+		EdgeIn:  edges.DefinesBinding,
+		EdgeOut: edges.Generates,
+		Reverse: true,
+		VName:   &spb.VName{Corpus: "c", Path: "foo.proto", Language: "protobuf", Signature: "Foo"},
+	}, {
+		WholeFile: true,
+		EdgeIn:    edges.DefinesBinding,
+		EdgeOut:   edges.Generates,
+		Reverse:   true,
+		VName:     &spb.VName{Corpus: "c", Path: "foo.proto"},
+	}}
+	anchors := []metadata.AnchorSpan{{
+		Begin: 10,
+		End:   13,
+		VName: &spb.VName{Corpus: "c", Path: "gen/foo.pb.go", Language: "go", Signature: "a"},
+	}}
+	CompareApplied(t, rules, anchors, file, "testdata/applied.entries")
+
+	// The order of the entries produced is not significant.
+	rules[0], rules[1] = rules[1], rules[0]
+	CompareApplied(t, rules, anchors, file, "testdata/applied.entries")
+}
@@ -0,0 +1,433 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// RemapUTF16ToBytes converts the Begin and End offsets of each rule in rs from
+// UTF-16 code units to byte offsets in fileContents, which must be the UTF-8
+// encoded text of the file the offsets refer to. A character outside the
+// Basic Multilingual Plane occupies two code units (a surrogate pair).
+//
+// It is an error if any offset falls beyond the end of the file, or between
+// the two halves of a surrogate pair; in that case rs is not modified. The
+// target spans of anchor_anchor rules refer to another file, and are not
+// affected.
+func (rs Rules) RemapUTF16ToBytes(fileContents []byte) error {
+	return rs.remapUnits(fileContents, "UTF-16", utf16Width, nil)
+}
+
+// RunesToBytes converts the Begin and End offsets of each rule in rs whose
+// RuneOffsets field is set from counts of runes to byte offsets in
+// fileContents, which must be the UTF-8 encoded text of the file the offsets
+// refer to, and clears RuneOffsets. Other rules are not affected, so
+// RunesToBytes may be called on any rules before they are applied.
+//
+// It is an error if any offset falls beyond the end of the file; in that case
+// rs is not modified. The target spans of anchor_anchor rules refer to another
+// file, and are not affected.
+func (rs Rules) RunesToBytes(fileContents []byte) error {
+	err := rs.remapUnits(fileContents, "rune", runeWidth, func(r Rule) bool { return r.RuneOffsets })
+	if err != nil {
+		return err
+	}
+	for i := range rs {
+		rs[i].RuneOffsets = false
+	}
+	return nil
+}
+
+// utf16Width returns the number of UTF-16 code units that encode r.
+func utf16Width(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// runeWidth counts each rune as a single unit.
+func runeWidth(rune) int { return 1 }
+
+// Remap returns a copy of rs in which the Begin and End offsets of each rule
+// have been translated by mapping, for example to keep metadata aligned with a
+// generated file that was reformatted after generation. A rule is omitted if
+// mapping reports false for either of its offsets. The receiver is not
+// modified; the rules in the result share their vnames with those of rs.
+// Whole-file rules have no offsets, and are kept unchanged.
+func (rs Rules) Remap(mapping func(offset int) (int, bool)) Rules {
+	if rs == nil {
+		return nil
+	}
+	out := make(Rules, 0, len(rs))
+	for _, r := range rs {
+		if r.WholeFile {
+			out = append(out, r)
+			continue
+		}
+		begin, ok := mapping(r.Begin)
+		if !ok {
+			continue
+		}
+		end, ok := mapping(r.End)
+		if !ok {
+			continue
+		}
+		r.Begin, r.End = begin, end
+		out = append(out, r)
+	}
+	return out
+}
+
+// A LineEnding is a convention for marking the ends of lines in a text file.
+type LineEnding int
+
+// The line endings understood by AdjustLineEndings.
+const (
+	LineEndingLF   LineEnding = iota // "\n", as on Unix
+	LineEndingCRLF                   // "\r\n", as on Windows
+)
+
+// AdjustLineEndings returns a copy of rs in which the Begin and End offsets of
+// each rule have been recomputed for a change of line endings from those of
+// from to those of to, for example because the file was checked out with
+// CRLF line endings after the rules were generated for its LF version. Here
+// src is the text the offsets of rs refer to, which uses from. An offset
+// between the two bytes of a CRLF sequence is treated as preceding it.
+//
+// The receiver is not modified. Whole-file rules have no offsets, the target
+// spans of anchor_anchor rules refer to another file, and rules whose span is
+// given by a Position are resolved against the converted text, so none of
+// these is affected.
+func (rs Rules) AdjustLineEndings(src []byte, from, to LineEnding) Rules {
+	// breaks holds the offsets in src at which a byte is inserted (LF to
+	// CRLF) or removed (CRLF to LF), in increasing order.
+	var breaks []int
+	var delta int
+	switch {
+	case from == LineEndingLF && to == LineEndingCRLF:
+		for i, b := range src {
+			if b == '\n' && (i == 0 || src[i-1] != '\r') {
+				breaks = append(breaks, i)
+			}
+		}
+		delta = 1
+	case from == LineEndingCRLF && to == LineEndingLF:
+		for i := 0; i+1 < len(src); i++ {
+			if src[i] == '\r' && src[i+1] == '\n' {
+				breaks = append(breaks, i)
+			}
+		}
+		delta = -1
+	}
+	return rs.Remap(func(offset int) (int, bool) {
+		// The number of breaks strictly before offset; a break at offset
+		// itself is inserted or removed after it.
+		n := sort.SearchInts(breaks, offset)
+		return offset + delta*n, true
+	})
+}
+
+// ClampToFile clamps, in place, the Begin and End offsets of each rule in rs
+// to the range [0, size] of offsets within a file of the given size in bytes,
+// and returns the indices of the rules that were changed, in increasing
+// order, for example so that the caller may log them. An empty span at the
+// end of the file is within range, and is preserved. Whole-file rules have no
+// offsets, and the target spans of anchor_anchor rules refer to another file,
+// so neither is affected.
+func (rs Rules) ClampToFile(size int) []int {
+	clamp := func(v int) int {
+		if v < 0 {
+			return 0
+		} else if v > size {
+			return size
+		}
+		return v
+	}
+	var changed []int
+	for i, r := range rs {
+		if r.WholeFile {
+			continue
+		}
+		begin, end := clamp(r.Begin), clamp(r.End)
+		if begin != r.Begin || end != r.End {
+			rs[i].Begin, rs[i].End = begin, end
+			changed = append(changed, i)
+		}
+	}
+	return changed
+}
+
+// remapUnits converts the offsets of each rule in rs from units of the named
+// encoding to byte offsets in src, where width gives the number of units per
+// character. If only != nil, the rules for which it reports false are left
+// as they are. The rules are updated only if all the offsets are valid.
+func (rs Rules) remapUnits(src []byte, name string, width func(rune) int, only func(Rule) bool) error {
+	// pos[i] is the byte offset of unit offset i, or -1 if unit offset i is
+	// not at a character boundary.
+	pos := make([]int, 0, len(src)+1)
+	for i := 0; i < len(src); {
+		r, n := utf8.DecodeRune(src[i:])
+		pos = append(pos, i)
+		for j := 1; j < width(r); j++ {
+			pos = append(pos, -1)
+		}
+		i += n
+	}
+	pos = append(pos, len(src))
+
+	convert := func(i int, which string, off int) (int, error) {
+		if off < 0 || off >= len(pos) {
+			return 0, fmt.Errorf("metadata: rule %d: %s offset %d is outside the file (%d %s units)",
+				i, which, off, len(pos)-1, name)
+		} else if pos[off] < 0 {
+			return 0, fmt.Errorf("metadata: rule %d: %s offset %d is not at a character boundary", i, which, off)
+		}
+		return pos[off], nil
+	}
+	spans := make([]Span, len(rs))
+	for i, r := range rs {
+		if only != nil && !only(r) {
+			spans[i] = Span{Begin: r.Begin, End: r.End}
+			continue
+		}
+		begin, err := convert(i, "begin", r.Begin)
+		if err != nil {
+			return err
+		}
+		end, err := convert(i, "end", r.End)
+		if err != nil {
+			return err
+		}
+		spans[i] = Span{Begin: begin, End: end}
+	}
+	for i, s := range spans {
+		rs[i].Begin, rs[i].End = s.Begin, s.End
+	}
+	return nil
+}
+
+// A LineSpan is a span of text given by line and column positions. Lines are
+// numbered from 1; columns are numbered from 0, and count the given units
+// from the start of the line. The end position is exclusive.
+type LineSpan struct {
+	BeginLine, BeginCol int
+	EndLine, EndCol     int
+
+	// The units of the columns. Byte columns are interpreted as requested
+	// by the options to ResolveLineColumnsWithOptions.
+	Units ColumnUnit
+}
+
+// A ColumnUnit identifies the units counted by the columns of a LineSpan.
+type ColumnUnit int
+
+// The defined values of ColumnUnit.
+const (
+	ColumnBytes ColumnUnit = iota // bytes; the default
+	ColumnRunes                   // runes (Unicode code points)
+	ColumnUTF16                   // UTF-16 code units, as in JavaScript source maps
+
+	// Bytes, except that a tab advances the column to the next multiple of
+	// 8, as in the locations of a protobuf SourceCodeInfo message.
+	ColumnExpandedTabs
+)
+
+var columnUnitNames = map[ColumnUnit]string{
+	ColumnRunes:        EncodingRunes,
+	ColumnUTF16:        EncodingUTF16,
+	ColumnExpandedTabs: "expanded-tabs",
+}
+
+// String returns the encoded name of the unit, or "" for ColumnBytes.
+func (u ColumnUnit) String() string { return columnUnitNames[u] }
+
+// parseColumnUnit returns the ColumnUnit whose encoded name is s.
+func parseColumnUnit(s string) (ColumnUnit, error) {
+	if s == "" {
+		return ColumnBytes, nil
+	}
+	for u, name := range columnUnitNames {
+		if s == name {
+			return u, nil
+		}
+	}
+	return ColumnBytes, fmt.Errorf("unknown column units %q", s)
+}
+
+// width returns the number of column units occupied by each rune, or nil if
+// columns count bytes or, for ColumnExpandedTabs, depend on the position.
+func (u ColumnUnit) width() func(rune) int {
+	switch u {
+	case ColumnRunes:
+		return runeWidth
+	case ColumnUTF16:
+		return utf16Width
+	}
+	return nil
+}
+
+// LineColumnOptions control the behaviour of ResolveLineColumnsWithOptions. A
+// nil *LineColumnOptions provides default values.
+type LineColumnOptions struct {
+	// If true, columns count runes (Unicode code points) rather than bytes.
+	RuneColumns bool
+
+	// If true, columns count UTF-16 code units rather than bytes, as in
+	// JavaScript source maps. This takes precedence over RuneColumns.
+	UTF16Columns bool
+}
+
+// columnWidth returns the number of column units occupied by each rune, or
+// nil if columns count bytes.
+func (o *LineColumnOptions) columnWidth() func(rune) int {
+	switch {
+	case o == nil:
+		return nil
+	case o.UTF16Columns:
+		return utf16Width
+	case o.RuneColumns:
+		return runeWidth
+	}
+	return nil
+}
+
+// ResolveLineColumns sets the Begin and End offsets of each rule in rs whose
+// span is given by line and column to the corresponding byte offsets in
+// fileContents, and clears its Position. Columns count the units of each
+// Position. Rules without a Position are not changed.
+//
+// It is an error if any position falls beyond the end of its line or of the
+// file; in that case rs is not modified.
+func (rs Rules) ResolveLineColumns(fileContents []byte) error {
+	return rs.ResolveLineColumnsWithOptions(fileContents, nil)
+}
+
+// ResolveLineColumnsWithOptions behaves as ResolveLineColumns, using the
+// settings from opts for positions whose columns count bytes. The columns of
+// positions with other units are interpreted in those units regardless.
+func (rs Rules) ResolveLineColumnsWithOptions(fileContents []byte, opts *LineColumnOptions) error {
+	lines := newLineIndex(fileContents)
+	byteWidth := opts.columnWidth()
+	offset := func(i, line, col int, units ColumnUnit) (int, error) {
+		width := byteWidth
+		if units != ColumnBytes {
+			width = units.width()
+		}
+		if line < 1 || line > len(lines.starts) {
+			return 0, fmt.Errorf("metadata: rule %d: line %d is outside the file (%d lines)", i, line, len(lines.starts))
+		} else if units == ColumnExpandedTabs {
+			if off, ok := lines.tabOffset(line, col); ok {
+				return off, nil
+			}
+		} else if off, ok := lines.offset(line, col, width); ok {
+			return off, nil
+		}
+		return 0, fmt.Errorf("metadata: rule %d: column %d is outside line %d", i, col, line)
+	}
+
+	spans := make(map[int]Span)
+	for i, r := range rs {
+		p := r.Position
+		if p == nil {
+			continue
+		}
+		begin, err := offset(i, p.BeginLine, p.BeginCol, p.Units)
+		if err != nil {
+			return err
+		}
+		end, err := offset(i, p.EndLine, p.EndCol, p.Units)
+		if err != nil {
+			return err
+		}
+		spans[i] = Span{Begin: begin, End: end}
+	}
+	for i, s := range spans {
+		rs[i].Begin, rs[i].End = s.Begin, s.End
+		rs[i].Position = nil
+	}
+	return nil
+}
+
+// A lineIndex locates the lines of a text.
+type lineIndex struct {
+	text   []byte
+	starts []int // starts[i] is the byte offset of the start of line i+1
+}
+
+func newLineIndex(text []byte) lineIndex {
+	starts := []int{0}
+	for i, b := range text {
+		if b == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return lineIndex{text: text, starts: starts}
+}
+
+// offset returns the byte offset of the given column of the given line,
+// numbered from 1, where width gives the number of column units of each rune,
+// or is nil if columns count bytes. It reports false if the position is not
+// within the text, or does not fall at a character boundary.
+func (x lineIndex) offset(line, col int, width func(rune) int) (int, bool) {
+	if line < 1 || line > len(x.starts) || col < 0 {
+		return 0, false
+	}
+	lo, hi := x.starts[line-1], len(x.text)
+	if line < len(x.starts) {
+		hi = x.starts[line] - 1 // exclude the newline
+	}
+	text := x.text[lo:hi]
+	if width == nil {
+		return lo + col, col <= len(text)
+	}
+	n, pos := 0, 0
+	for n < col && pos < len(text) {
+		r, size := utf8.DecodeRune(text[pos:])
+		n += width(r)
+		pos += size
+	}
+	return lo + pos, n == col
+}
+
+// tabOffset returns the byte offset of the given column of the given line,
+// numbered from 1, where columns count bytes except that a tab advances to
+// the next multiple of 8. It reports false if the position is not within the
+// text, or falls within the expansion of a tab.
+func (x lineIndex) tabOffset(line, col int) (int, bool) {
+	const tabWidth = 8
+	if line < 1 || line > len(x.starts) || col < 0 {
+		return 0, false
+	}
+	lo, hi := x.starts[line-1], len(x.text)
+	if line < len(x.starts) {
+		hi = x.starts[line] - 1 // exclude the newline
+	}
+	n, pos := 0, lo
+	for n < col && pos < hi {
+		if x.text[pos] == '\t' {
+			n += tabWidth - n%tabWidth
+		} else {
+			n++
+		}
+		pos++
+	}
+	return pos, n == col
+}
@@ -0,0 +1,305 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+)
+
+func TestRemapUTF16ToBytes(t *testing.T) {
+	// UTF-16 offsets:  a=0 é=1 😀=2,3 b=4 end=5
+	// Byte offsets:    a=0 é=1,2 😀=3..6 b=7 end=8
+	src := []byte("aé😀b")
+	rs := Rules{
+		{Begin: 0, End: 1},
+		{Begin: 1, End: 2},
+		{Begin: 2, End: 4},
+		{Begin: 4, End: 5},
+		{Begin: 0, End: 5},
+	}
+	want := Rules{
+		{Begin: 0, End: 1},
+		{Begin: 1, End: 3},
+		{Begin: 3, End: 7},
+		{Begin: 7, End: 8},
+		{Begin: 0, End: 8},
+	}
+	if err := rs.RemapUTF16ToBytes(src); err != nil {
+		t.Fatalf("RemapUTF16ToBytes failed: %v", err)
+	}
+	if err := testutil.DeepEqual(want, rs); err != nil {
+		t.Errorf("RemapUTF16ToBytes: %v", err)
+	}
+
+	for _, bad := range []Rules{
+		{{Begin: 0, End: 6}}, // past the end of the file
+		{{Begin: 3, End: 4}}, // inside a surrogate pair
+		{{Begin: -1, End: 0}},
+	} {
+		orig := bad[0]
+		if err := bad.RemapUTF16ToBytes(src); err == nil {
+			t.Errorf("RemapUTF16ToBytes %v: got %v, wanted error", orig, bad)
+		} else if bad[0].Begin != orig.Begin || bad[0].End != orig.End {
+			t.Errorf("RemapUTF16ToBytes %v: rules modified on error: %v", orig, bad)
+		} else {
+			t.Logf("RemapUTF16ToBytes %v: %v", orig, err)
+		}
+	}
+}
+
+func TestRunesToBytes(t *testing.T) {
+	// Rune offsets:  α=0 β=1 x=2 😀=3 y=4 end=5
+	// Byte offsets:  α=0,1 β=2,3 x=4 😀=5..8 y=9 end=10
+	src := []byte("αβx😀y")
+	const input = `{"type":"kythe0","offset_encoding":"runes","meta":[
+           {"type":"nop","begin":2,"end":3},
+           {"type":"nop","begin":4,"end":5},
+           {"type":"nop","begin":0,"end":5}
+        ]}`
+	rs, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	// A rule with byte offsets is not converted.
+	rs = append(rs, Rule{Begin: 4, End: 5})
+	want := Rules{
+		{Begin: 4, End: 5},
+		{Begin: 9, End: 10},
+		{Begin: 0, End: 10},
+		{Begin: 4, End: 5},
+	}
+	if err := rs.RunesToBytes(src); err != nil {
+		t.Fatalf("RunesToBytes failed: %v", err)
+	}
+	if err := testutil.DeepEqual(want, rs); err != nil {
+		t.Errorf("RunesToBytes: %v", err)
+	}
+
+	bad := Rules{
+		{Begin: 0, End: 1, RuneOffsets: true},
+		{Begin: 4, End: 6, RuneOffsets: true}, // past the end of the file
+	}
+	if err := bad.RunesToBytes(src); err == nil {
+		t.Errorf("RunesToBytes: got %v, wanted error", bad)
+	} else if bad[0].End != 1 || !bad[0].RuneOffsets {
+		t.Errorf("RunesToBytes: rules modified on error: %v", bad)
+	} else {
+		t.Logf("RunesToBytes: %v", err)
+	}
+}
+
+func TestAdjustLineEndings(t *testing.T) {
+	lf := []byte("ab\ncd\n\nef")
+	crlf := []byte("ab\r\ncd\r\n\r\nef")
+	// The rules of the LF file, and the equivalent rules of the CRLF file.
+	lfRules := Rules{
+		{Begin: 0, End: 2}, // ab
+		{Begin: 3, End: 5}, // cd
+		{Begin: 7, End: 9}, // ef
+		{Begin: 2, End: 3}, // the first line break
+		{Begin: 0, End: 9}, // the whole text
+		{WholeFile: true},
+		{Begin: 3, End: 5, TargetSpan: &Span{Begin: 3, End: 5}},
+	}
+	crlfRules := Rules{
+		{Begin: 0, End: 2},
+		{Begin: 4, End: 6},
+		{Begin: 10, End: 12},
+		{Begin: 2, End: 4},
+		{Begin: 0, End: 12},
+		{WholeFile: true},
+		{Begin: 4, End: 6, TargetSpan: &Span{Begin: 3, End: 5}},
+	}
+	for i, r := range crlfRules {
+		if r.WholeFile || r.TargetSpan != nil {
+			continue
+		}
+		if got, want := string(crlf[r.Begin:r.End]), strings.Replace(string(lf[lfRules[i].Begin:lfRules[i].End]), "\n", "\r\n", -1); got != want {
+			t.Fatalf("Test rule %d spans %q, want %q", i, got, want)
+		}
+	}
+
+	orig := append(Rules(nil), lfRules...)
+	got := lfRules.AdjustLineEndings(lf, LineEndingLF, LineEndingCRLF)
+	if err := testutil.DeepEqual(crlfRules, got); err != nil {
+		t.Errorf("AdjustLineEndings LF to CRLF: %v", err)
+	}
+	if err := testutil.DeepEqual(orig, lfRules); err != nil {
+		t.Errorf("AdjustLineEndings modified its receiver: %v", err)
+	}
+	if got := crlfRules.AdjustLineEndings(crlf, LineEndingCRLF, LineEndingLF); !reflect.DeepEqual(got, lfRules) {
+		t.Errorf("AdjustLineEndings CRLF to LF: got %v, want %v", got, lfRules)
+	}
+	if got := lfRules.AdjustLineEndings(lf, LineEndingLF, LineEndingLF); !reflect.DeepEqual(got, lfRules) {
+		t.Errorf("AdjustLineEndings LF to LF: got %v, want %v", got, lfRules)
+	}
+
+	// An offset between the bytes of a CRLF sequence precedes it.
+	mid := Rules{{Begin: 0, End: 3}}
+	if got, want := mid.AdjustLineEndings(crlf, LineEndingCRLF, LineEndingLF), (Rules{{Begin: 0, End: 2}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("AdjustLineEndings %v: got %v, want %v", mid, got, want)
+	}
+}
+
+func TestResolveLineColumns(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+  {"type":"nop","begin_line":1,"begin_col":0,"end_line":1,"end_col":3},
+  {"type":"nop","begin_line":2,"begin_col":4,"end_line":2,"end_col":7},
+  {"type":"nop","begin_line":1,"begin_col":2,"end_line":3,"end_col":0},
+  {"type":"nop","begin":5,"end":6}
+]}`
+	rs, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if err := testutil.DeepEqual(&LineSpan{BeginLine: 2, BeginCol: 4, EndLine: 2, EndCol: 7}, rs[1].Position); err != nil {
+		t.Errorf("Parse position: %v", err)
+	}
+
+	// Line 1 is bytes [0,4), line 2 is bytes [5,14) with é at [6,8), and
+	// line 3 begins at byte 15.
+	src := []byte("abcd\nxé yzw12\n")
+
+	bytesRules := append(Rules(nil), rs...)
+	if err := bytesRules.ResolveLineColumns(src); err != nil {
+		t.Fatalf("ResolveLineColumns failed: %v", err)
+	}
+	want := Rules{{Begin: 0, End: 3}, {Begin: 9, End: 12}, {Begin: 2, End: 15}, {Begin: 5, End: 6}}
+	if err := testutil.DeepEqual(want, bytesRules); err != nil {
+		t.Errorf("ResolveLineColumns: %v", err)
+	}
+
+	runeRules := append(Rules(nil), rs...)
+	if err := runeRules.ResolveLineColumnsWithOptions(src, &LineColumnOptions{RuneColumns: true}); err != nil {
+		t.Fatalf("ResolveLineColumnsWithOptions failed: %v", err)
+	}
+	want[1] = Rule{Begin: 10, End: 13}
+	if err := testutil.DeepEqual(want, runeRules); err != nil {
+		t.Errorf("ResolveLineColumnsWithOptions: %v", err)
+	}
+
+	// A position that records its units is resolved in those units,
+	// whatever the options.
+	unitRules, err := Parse(strings.NewReader(`{"type":"kythe0","meta":[
+  {"type":"nop","begin_line":2,"begin_col":4,"end_line":2,"end_col":7,"column_units":"runes"},
+  {"type":"nop","begin_line":2,"begin_col":4,"end_line":2,"end_col":7}
+]}`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if got := unitRules[0].Position.Units; got != ColumnRunes {
+		t.Errorf("Parse column_units: got %v, want %v", got, ColumnRunes)
+	}
+	if err := unitRules.ResolveLineColumnsWithOptions(src, &LineColumnOptions{UTF16Columns: true}); err != nil {
+		t.Fatalf("ResolveLineColumnsWithOptions failed: %v", err)
+	}
+	if err := testutil.DeepEqual(Rules{{Begin: 10, End: 13}, {Begin: 10, End: 13}}, unitRules); err != nil {
+		t.Errorf("ResolveLineColumnsWithOptions with units: %v", err)
+	}
+	if rs, err := Parse(strings.NewReader(`{"type":"kythe0","meta":[{"type":"nop","column_units":"runes"}]}`)); err == nil {
+		t.Errorf("Parse of column_units without a position: got %v, wanted error", rs)
+	}
+
+	for _, pos := range []LineSpan{
+		{BeginLine: 4, EndLine: 4},               // past the last line
+		{BeginLine: 0, EndLine: 1},               // lines are numbered from 1
+		{BeginLine: 1, EndLine: 1, EndCol: 5},    // past the end of the line
+		{BeginLine: 1, BeginCol: -1, EndLine: 1}, // negative column
+	} {
+		pos := pos
+		bad := Rules{{Position: &pos}}
+		if err := bad.ResolveLineColumns(src); err == nil {
+			t.Errorf("ResolveLineColumns %+v: got %v, wanted error", pos, bad)
+		} else if bad[0].Position == nil {
+			t.Errorf("ResolveLineColumns %+v: rules modified on error", pos)
+		} else {
+			t.Logf("ResolveLineColumns %+v: %v", pos, err)
+		}
+	}
+}
+
+func TestRemap(t *testing.T) {
+	rs := Rules{
+		{Begin: 0, End: 5, EdgeOut: "a"},
+		{Begin: 10, End: 20, EdgeOut: "b"},
+		{Begin: 30, End: 35, EdgeOut: "c"},
+	}
+	// Shift everything after offset 8 by 3 bytes, and treat [25,40) as a
+	// deleted region.
+	got := rs.Remap(func(off int) (int, bool) {
+		switch {
+		case off < 8:
+			return off, true
+		case off >= 25 && off < 40:
+			return 0, false
+		}
+		return off + 3, true
+	})
+	want := Rules{
+		{Begin: 0, End: 5, EdgeOut: "a"},
+		{Begin: 13, End: 23, EdgeOut: "b"},
+	}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("Remap: %v", err)
+	}
+	if rs[1].Begin != 10 || rs[1].End != 20 || len(rs) != 3 {
+		t.Errorf("Remap modified its receiver: %v", rs)
+	}
+	if got := Rules(nil).Remap(func(int) (int, bool) { return 0, true }); got != nil {
+		t.Errorf("Remap of nil: got %v, want nil", got)
+	}
+}
+
+func TestClampToFile(t *testing.T) {
+	const size = 100
+	rs := Rules{
+		{Begin: 10, End: 20},   // 0: within the file
+		{Begin: 90, End: 100},  // 1: ends exactly at EOF
+		{Begin: 100, End: 100}, // 2: empty at EOF
+		{Begin: 95, End: 101},  // 3: one past EOF
+		{Begin: 101, End: 101}, // 4: empty one past EOF
+		{Begin: -2, End: 5},    // 5: negative begin
+		{WholeFile: true},      // 6: no offsets
+		{Begin: 0, End: 4, TargetSpan: &Span{Begin: 500, End: 600}},   // 7: target in another file
+		{Begin: 150, End: 200, TargetSpan: &Span{Begin: 0, End: 300}}, // 8: both past EOF
+	}
+	got := rs.ClampToFile(size)
+	if err := testutil.DeepEqual([]int{3, 4, 5, 8}, got); err != nil {
+		t.Errorf("ClampToFile(%d): wrong indices: %v", size, err)
+	}
+	want := Rules{
+		{Begin: 10, End: 20},
+		{Begin: 90, End: 100},
+		{Begin: 100, End: 100},
+		{Begin: 95, End: 100},
+		{Begin: 100, End: 100},
+		{Begin: 0, End: 5},
+		{WholeFile: true},
+		{Begin: 0, End: 4, TargetSpan: &Span{Begin: 500, End: 600}},
+		{Begin: 100, End: 100, TargetSpan: &Span{Begin: 0, End: 300}},
+	}
+	if err := testutil.DeepEqual(want, rs); err != nil {
+		t.Errorf("ClampToFile(%d): %v", size, err)
+	}
+	if got := rs.ClampToFile(size); got != nil {
+		t.Errorf("ClampToFile(%d) again: got %v, want nil", size, got)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A Parser parses metadata files as Parse, reusing scratch state across calls
+// to reduce the garbage produced by programs that parse very many files. The
+// zero value is ready for use, and a Parser is safe for concurrent use by
+// multiple goroutines. A Parser must not be copied after first use.
+//
+// Each input is read into a pooled buffer, which is decoded as by ParseBytes,
+// and its rules are decoded into a pooled scratch slice and copied into a
+// result of exactly the right size, rather than grown by appending to the
+// result. For a file of 100 rules, this saves about a sixth of the bytes
+// allocated by Parse (see BenchmarkParser). The JSON decoder itself cannot be
+// reused, as it cannot be reset to read a new input.
+type Parser struct {
+	// If non-nil, the settings used for every call, as ParseWithOptions.
+	Options *ParseOptions
+
+	scratch sync.Pool // of *parseScratch
+}
+
+// parseScratch is the state reused by the calls of a Parser.
+type parseScratch struct {
+	input bytes.Buffer
+	rules []Rule
+}
+
+// maxScratchRules and maxScratchBytes are the capacities above which scratch
+// state is discarded rather than kept for reuse, so that a single large input
+// does not pin its memory for the life of the Parser.
+const (
+	maxScratchRules = 1 << 16
+	maxScratchBytes = 1 << 22
+)
+
+// Parse parses a single JSON metadata object from r, as ParseWithOptions with
+// the options of p.
+func (p *Parser) Parse(r io.Reader) (Rules, error) {
+	s, _ := p.scratch.Get().(*parseScratch)
+	if s == nil {
+		s = new(parseScratch)
+	}
+	defer func() {
+		for i := range s.rules {
+			s.rules[i] = Rule{} // do not retain the vnames of the result
+		}
+		if cap(s.rules) <= maxScratchRules && s.input.Cap() <= maxScratchBytes {
+			s.rules = s.rules[:0]
+			s.input.Reset()
+			p.scratch.Put(s)
+		}
+	}()
+	if _, err := s.input.ReadFrom(r); err != nil {
+		return nil, &ParseError{Index: -1, Err: fmt.Errorf("invalid file: %v", err)}
+	}
+	d := newBytesDecoder(context.Background(), s.input.Bytes(), p.Options)
+	if err := d.decode(func(_ int, rule Rule) error {
+		s.rules = append(s.rules, rule)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(s.rules) == 0 && !d.sawMeta {
+		return nil, nil
+	}
+	return append(make(Rules, 0, len(s.rules)), s.rules...), nil
+}
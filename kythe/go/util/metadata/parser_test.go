@@ -0,0 +1,102 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+)
+
+func TestParser(t *testing.T) {
+	var p Parser
+	inputs := []string{
+		`{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2}]}`,
+		string(benchInput(50)),
+		`{"type":"kythe0","meta":[{"type":"nop","begin":3,"end":4,"unknown":[1,2]}]}`,
+		`{"type":"kythe0"}`,
+		`{"type":"kythe0","meta":[]}`,
+	}
+	// Parse each input several times, concurrently, so that buffers are
+	// reused for inputs of different sizes.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		for _, input := range inputs {
+			wg.Add(1)
+			go func(input string) {
+				defer wg.Done()
+				want, err := Parse(strings.NewReader(input))
+				if err != nil {
+					t.Errorf("Parse %q failed: %v", input, err)
+					return
+				}
+				got, err := p.Parse(strings.NewReader(input))
+				if err != nil {
+					t.Errorf("Parser.Parse %q failed: %v", input, err)
+					return
+				}
+				if err := testutil.DeepEqual(want, got); err != nil {
+					t.Errorf("Parser.Parse %q: %v", input, err)
+				}
+			}(input)
+		}
+	}
+	wg.Wait()
+
+	if _, err := p.Parse(strings.NewReader(`{"type":"kythe0","meta":[{"type":"bogus"}]}`)); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Parser.Parse: got error %v, want %v", err, ErrMalformed)
+	}
+	readErr := errors.New("read failed")
+	var perr *ParseError
+	if _, err := p.Parse(errReader{readErr}); !errors.As(err, &perr) || perr.Index != -1 {
+		t.Errorf("Parser.Parse of a failing reader: got error %v, want *ParseError", err)
+	}
+	strict := Parser{Options: &ParseOptions{Strict: true}}
+	if _, err := strict.Parse(strings.NewReader(`{"type":"kythe0","meta":[{"type":"nop","begin":2,"end":1}]}`)); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Parser.Parse strict: got error %v, want %v", err, ErrMalformed)
+	}
+}
+
+func BenchmarkParser(b *testing.B) {
+	input := benchInput(100)
+	b.Run("Parse", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := Parse(bytes.NewReader(input)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		var p Parser
+		for i := 0; i < b.N; i++ {
+			if _, err := p.Parse(bytes.NewReader(input)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// errReader is an io.Reader whose every read fails with err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	mfpb "kythe.io/kythe/proto/metadata_file_go_proto"
+)
+
+// This file implements the binary encoding of metadata, as a MetadataFile
+// message from kythe/proto/metadata_file.proto. The message is converted to
+// and from the intermediate rule format, so that it shares the validation
+// performed by Parse with the JSON encoding.
+
+// MarshalProto encodes rs as a binary MetadataFile message. The result can be
+// decoded by ParseProto, and holds the same information as the JSON encoding
+// produced by MarshalJSON.
+func (rs Rules) MarshalProto() ([]byte, error) {
+	enc, err := rs.offsetEncoding()
+	if err != nil {
+		return nil, err
+	}
+	file := &mfpb.MetadataFile{Type: MetadataType, OffsetEncoding: enc}
+	for _, r := range rs {
+		file.Meta = append(file.Meta, protoRule(encodeRule(r)))
+	}
+	// Deterministic output orders the entries of the extra field maps.
+	return proto.MarshalOptions{Deterministic: true}.Marshal(file)
+}
+
+// protoRule converts meta to its binary encoding.
+func protoRule(meta rule) *mfpb.MetadataFile_Rule {
+	msg := &mfpb.MetadataFile_Rule{
+		Type:        meta.Type,
+		Begin:       meta.Begin,
+		End:         meta.End,
+		Edge:        meta.Edge,
+		Vname:       meta.VName,
+		SourceVname: meta.SourceVName,
+		Semantic:    meta.Semantic,
+		Language:    meta.Language,
+		WholeFile:   meta.noBegin && meta.noEnd,
+	}
+	if meta.TargetBegin != nil || meta.TargetEnd != nil {
+		msg.Target = &mfpb.MetadataFile_Span{Begin: derefInt64(meta.TargetBegin), End: derefInt64(meta.TargetEnd)}
+	}
+	if meta.SourceBegin != nil || meta.SourceEnd != nil {
+		msg.Source = &mfpb.MetadataFile_Span{Begin: int64(derefInt(meta.SourceBegin)), End: int64(derefInt(meta.SourceEnd))}
+	}
+	if meta.Ordinal != nil {
+		msg.Ordinal = &mfpb.MetadataFile_Ordinal{Value: int64(*meta.Ordinal)}
+	}
+	if meta.Score != nil {
+		msg.Score = &mfpb.MetadataFile_Score{Value: *meta.Score}
+	}
+	if meta.BeginLine != nil {
+		msg.Position = &mfpb.MetadataFile_LineSpan{
+			BeginLine:   int64(*meta.BeginLine),
+			BeginCol:    int64(derefInt(meta.BeginCol)),
+			EndLine:     int64(derefInt(meta.EndLine)),
+			EndCol:      int64(derefInt(meta.EndCol)),
+			ColumnUnits: meta.ColumnUnits,
+		}
+	}
+	if len(meta.Extra) != 0 {
+		msg.Extra = make(map[string][]byte, len(meta.Extra))
+		for key, val := range meta.Extra {
+			msg.Extra[key] = val
+		}
+	}
+	return msg
+}
+
+// ParseProto decodes a binary MetadataFile message from data and returns the
+// corresponding rules. The rules are checked as by Parse, and any error
+// returned has concrete type *ParseError.
+func ParseProto(data []byte) (Rules, error) {
+	var file mfpb.MetadataFile
+	if err := proto.Unmarshal(data, &file); err != nil {
+		return nil, &ParseError{Index: -1, Err: fmt.Errorf("invalid file: %v", err)}
+	}
+	if !ValidType(file.Type) {
+		return nil, &ParseError{Index: -1, Err: fmt.Errorf("wrong type tag: %q", file.Type)}
+	}
+	runes, err := runeOffsets(file.OffsetEncoding)
+	if err != nil {
+		return nil, &ParseError{Index: -1, Err: err}
+	}
+	decodeRule := ruleDecoders[file.Type]
+	var rs Rules
+	for i, msg := range file.Meta {
+		meta, err := parseProtoRule(msg)
+		if err == nil && ruleTypes[meta.Type].custom != nil {
+			// Registered decoders expect the JSON encoding of the rule.
+			meta.raw, err = json.Marshal(meta)
+		}
+		if err != nil {
+			return nil, &ParseError{Index: i, Err: err}
+		}
+		r, err := decodeRule(meta)
+		if err != nil {
+			return nil, &ParseError{Index: i, Err: err}
+		}
+		r.RuneOffsets = runes
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+// parseProtoRule converts the binary encoding of a rule to the intermediate
+// format decoded by decodeRule.
+func parseProtoRule(msg *mfpb.MetadataFile_Rule) (rule, error) {
+	meta := rule{
+		Type:        msg.Type,
+		Begin:       msg.Begin,
+		End:         msg.End,
+		Edge:        msg.Edge,
+		VName:       msg.Vname,
+		SourceVName: msg.SourceVname,
+		Semantic:    msg.Semantic,
+		Language:    msg.Language,
+		noBegin:     msg.WholeFile,
+		noEnd:       msg.WholeFile,
+	}
+	if t := msg.Target; t != nil {
+		meta.TargetBegin, meta.TargetEnd = &t.Begin, &t.End
+	}
+	if s := msg.Source; s != nil {
+		vals, err := checkOffsets(s.Begin, s.End)
+		if err != nil {
+			return meta, fmt.Errorf("invalid source span: %v", err)
+		}
+		meta.SourceBegin, meta.SourceEnd = &vals[0], &vals[1]
+	}
+	if o := msg.Ordinal; o != nil {
+		vals, err := checkOffsets(o.Value)
+		if err != nil {
+			return meta, fmt.Errorf("invalid ordinal: %v", err)
+		}
+		meta.Ordinal = &vals[0]
+	}
+	if s := msg.Score; s != nil {
+		meta.Score = &s.Value
+	}
+	if p := msg.Position; p != nil {
+		vals, err := checkOffsets(p.BeginLine, p.BeginCol, p.EndLine, p.EndCol)
+		if err != nil {
+			return meta, fmt.Errorf("invalid position: %v", err)
+		}
+		meta.BeginLine, meta.BeginCol, meta.EndLine, meta.EndCol = &vals[0], &vals[1], &vals[2], &vals[3]
+		meta.ColumnUnits = p.ColumnUnits
+	}
+	for key, val := range msg.Extra {
+		if !json.Valid(val) {
+			return meta, fmt.Errorf("invalid JSON value for extra field %q", key)
+		}
+		if meta.Extra == nil {
+			meta.Extra = make(map[string]json.RawMessage, len(msg.Extra))
+		}
+		meta.Extra[key] = json.RawMessage(val)
+	}
+	return meta, nil
+}
+
+// checkOffsets converts vs to int values, as checkOffset.
+func checkOffsets(vs ...int64) ([]int, error) {
+	out := make([]int, len(vs))
+	for i, v := range vs {
+		n, err := checkOffset(v)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = n
+	}
+	return out, nil
+}
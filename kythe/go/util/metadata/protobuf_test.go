@@ -0,0 +1,96 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"kythe.io/kythe/go/test/testutil"
+
+	mfpb "kythe.io/kythe/proto/metadata_file_go_proto"
+)
+
+func TestProtoRoundTrip(t *testing.T) {
+	for _, test := range roundTripTests {
+		enc, err := test.MarshalProto()
+		if err != nil {
+			t.Errorf("MarshalProto %+v failed: %v", test, err)
+			continue
+		}
+		dec, err := ParseProto(enc)
+		if err != nil {
+			t.Errorf("ParseProto %q failed: %v", enc, err)
+			continue
+		}
+		if err := testutil.DeepEqual(test, dec); err != nil {
+			t.Errorf("Round-trip of %+v failed: %v", test, err)
+		}
+
+		// The binary and JSON encodings should agree.
+		js, err := json.Marshal(test)
+		if err != nil {
+			t.Fatalf("Encoding %+v failed: %v", test, err)
+		}
+		fromJSON, err := Parse(bytes.NewReader(js))
+		if err != nil {
+			t.Fatalf("Decoding %q failed: %v", js, err)
+		}
+		if err := testutil.DeepEqual(fromJSON, dec); err != nil {
+			t.Errorf("ParseProto and Parse disagree: %v", err)
+		}
+	}
+}
+
+func TestParseProtoErrors(t *testing.T) {
+	encode := func(ftype string, rules ...*mfpb.MetadataFile_Rule) []byte {
+		b, err := proto.Marshal(&mfpb.MetadataFile{Type: ftype, Meta: rules})
+		if err != nil {
+			t.Fatalf("Encoding failed: %v", err)
+		}
+		return b
+	}
+	nop := &mfpb.MetadataFile_Rule{Type: "nop"}
+	tests := []struct {
+		input []byte
+		index int
+	}{
+		{[]byte{0xff}, -1},    // truncated tag
+		{encode("wrong"), -1}, // bad type tag
+		{encode(""), -1},      // missing type tag
+		{encode(MetadataType, nop, &mfpb.MetadataFile_Rule{Type: "bogus"}), 1},
+		{encode(MetadataType, &mfpb.MetadataFile_Rule{Type: "nop", Extra: map[string][]byte{"x": []byte("{")}}), 0},
+		{encode(MetadataType, &mfpb.MetadataFile_Rule{Type: "anchor_anchor"}), 0}, // no edge
+	}
+	for _, test := range tests {
+		rs, err := ParseProto(test.input)
+		if err == nil {
+			t.Errorf("ParseProto %q: got %v, wanted error", test.input, rs)
+			continue
+		}
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("ParseProto %q: got error %T, want *ParseError", test.input, err)
+		} else if perr.Index != test.index {
+			t.Errorf("ParseProto %q: got index %d, want %d", test.input, perr.Index, test.index)
+		}
+	}
+}
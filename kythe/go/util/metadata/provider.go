@@ -0,0 +1,92 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// A Provider locates the metadata rules that apply to a source file, so that
+// an indexer can obtain them without knowing where they are stored.
+type Provider interface {
+	// Lookup returns the rules that apply to the source file at sourcePath.
+	// If there is no metadata for the file, it returns nil rules and no
+	// error.
+	Lookup(sourcePath string) (Rules, error)
+}
+
+// NewMapProvider returns a Provider that finds the metadata for each source
+// file among inputs, the contents of files keyed by path, as
+// ForSourceFileWithOptions.
+func NewMapProvider(inputs map[string][]byte, opts *SidecarOptions) Provider {
+	return mapProvider{inputs: inputs, opts: opts}
+}
+
+type mapProvider struct {
+	inputs map[string][]byte
+	opts   *SidecarOptions
+}
+
+func (p mapProvider) Lookup(sourcePath string) (Rules, error) {
+	return ForSourceFileWithOptions(p.inputs, sourcePath, p.opts)
+}
+
+// NewFileProvider returns a Provider that reads the metadata for each source
+// file from the file system, as ParseFile. The metadata for a source path is
+// found by probing the candidates given by opts, as ForSourceFileWithOptions,
+// each resolved relative to the directory root. Source paths use forward
+// slashes as separators.
+func NewFileProvider(root string, opts *SidecarOptions) Provider {
+	return fileProvider{root: root, opts: opts}
+}
+
+type fileProvider struct {
+	root string
+	opts *SidecarOptions
+}
+
+func (p fileProvider) Lookup(sourcePath string) (Rules, error) {
+	for _, metaPath := range p.opts.metadataPaths(sourcePath) {
+		rs, err := ParseFile(filepath.Join(p.root, filepath.FromSlash(metaPath)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		return rs, err
+	}
+	return nil, nil
+}
+
+// ChainProviders returns a Provider that looks up each source file in each of
+// ps in turn, and returns the rules they report combined as Rules.Merge. If
+// any of ps reports an error, the lookup stops and returns that error.
+func ChainProviders(ps ...Provider) Provider { return chainProvider(ps) }
+
+type chainProvider []Provider
+
+func (c chainProvider) Lookup(sourcePath string) (Rules, error) {
+	var out Rules
+	for _, p := range c {
+		rs, err := p.Lookup(sourcePath)
+		if err != nil {
+			return nil, err
+		} else if rs != nil {
+			out = out.Merge(rs)
+		}
+	}
+	return out, nil
+}
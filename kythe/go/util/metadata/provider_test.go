@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+)
+
+func TestProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadata")
+	if err != nil {
+		t.Fatalf("Creating temp directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "gen"), 0755); err != nil {
+		t.Fatalf("Creating directory: %v", err)
+	}
+	files := map[string]string{
+		"gen/a.go.meta": `{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2},{"type":"nop","begin":5,"end":6}]}`,
+		"gen/b.go.meta": `{"type":"kythe0","meta":[{"type":"bogus"}]}`,
+		"gen/e.go.json": `{"type":"kythe0","meta":[{"type":"nop","begin":9,"end":10}]}`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(data), 0644); err != nil {
+			t.Fatalf("Writing %q: %v", name, err)
+		}
+	}
+	fp := NewFileProvider(dir, nil)
+	sp := NewFileProvider(dir, &SidecarOptions{Suffixes: []string{".kythe", ".json"}})
+	mp := NewMapProvider(map[string][]byte{
+		"gen/a.go.meta": []byte(`{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2},{"type":"nop","begin":3,"end":4}]}`),
+		"gen/c.go.meta": []byte(`{"type":"kythe0","meta":[{"type":"nop","begin":7,"end":8}]}`),
+	}, nil)
+	chain := ChainProviders(mp, fp)
+
+	tests := []struct {
+		p    Provider
+		path string
+		want Rules
+	}{
+		{fp, "gen/a.go", Rules{{Begin: 1, End: 2}, {Begin: 5, End: 6}}},
+		{fp, "gen/c.go", nil},
+		{fp, "gen/e.go", nil},
+		{sp, "gen/e.go", Rules{{Begin: 9, End: 10}}},
+		{sp, "gen/a.go", nil},
+		{mp, "gen/a.go", Rules{{Begin: 1, End: 2}, {Begin: 3, End: 4}}},
+		{mp, "gen/b.go", nil},
+		{chain, "gen/a.go", Rules{{Begin: 1, End: 2}, {Begin: 3, End: 4}, {Begin: 5, End: 6}}},
+		{chain, "gen/c.go", Rules{{Begin: 7, End: 8}}},
+		{chain, "gen/d.go", nil},
+		{ChainProviders(), "gen/a.go", nil},
+	}
+	for _, test := range tests {
+		got, err := test.p.Lookup(test.path)
+		if err != nil {
+			t.Errorf("Lookup(%q) failed: %v", test.path, err)
+		} else if err := testutil.DeepEqual(test.want, got); err != nil {
+			t.Errorf("Lookup(%q): %v", test.path, err)
+		}
+	}
+
+	for _, p := range []Provider{fp, chain} {
+		if rs, err := p.Lookup("gen/b.go"); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Lookup of malformed metadata: got (%v, %v), want %v", rs, err, ErrMalformed)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"kythe.io/kythe/go/util/schema/edges"
+)
+
+// KnownRuleTypes lists the rule type tags understood by the decoder, in order
+// of registration. It includes the built-in types and any registered by
+// RegisterRuleType.
+var KnownRuleTypes []string
+
+// A ruleType describes how to decode the rules of a single type. Exactly one
+// of its fields is set.
+type ruleType struct {
+	// For the built-in types, a function to complete a rule whose common
+	// fields have been decoded by decodeCommon.
+	builtin func(r *Rule, meta rule) error
+
+	// For types registered by RegisterRuleType, the decoder supplied.
+	custom func(json.RawMessage) (Rule, error)
+}
+
+// ruleTypes maps each registered rule type tag to its decoder.
+var ruleTypes = make(map[string]ruleType)
+
+func init() {
+	registerRuleType("nop", ruleType{builtin: func(*Rule, rule) error {
+		return nil // ok, no special behaviour
+	}})
+	registerRuleType("anchor_defines", ruleType{builtin: func(r *Rule, _ rule) error {
+		r.EdgeIn = edges.DefinesBinding
+		return nil
+	}})
+	registerRuleType("ref", ruleType{builtin: func(r *Rule, _ rule) error {
+		r.EdgeIn = edges.Ref
+		return nil
+	}})
+	registerRuleType("anchor_anchor", ruleType{builtin: func(r *Rule, meta rule) error {
+		// As in the MappingRule message read by the C++ indexer, the target
+		// offsets give the span of the generated anchor, and the source
+		// vname and offsets the file and span of the anchor it is linked to.
+		// The edge is written from the source anchor, so its direction is
+		// the inverse of that of the rule.
+		if meta.Edge == "" {
+			return errors.New("anchor_anchor rule without an edge")
+		}
+		if r.Position == nil {
+			begin, err := checkOffset(derefInt64(meta.TargetBegin))
+			if err != nil {
+				return err
+			}
+			end, err := checkOffset(derefInt64(meta.TargetEnd))
+			if err != nil {
+				return err
+			}
+			r.Begin, r.End = begin, end
+		}
+		r.VName = meta.SourceVName
+		if meta.Language != "" && r.VName != nil {
+			r.VName.Language = meta.Language
+		}
+		r.EdgeIn = edges.DefinesBinding
+		r.Reverse = !r.Reverse
+		r.TargetSpan = &Span{Begin: derefInt(meta.SourceBegin), End: derefInt(meta.SourceEnd)}
+		r.WholeFile = false
+		return nil
+	}})
+}
+
+// derefInt returns *p, or 0 if p == nil.
+func derefInt(p *int) int {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+// derefInt64 returns *p, or 0 if p == nil.
+func derefInt64(p *int64) int64 {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+// RegisterRuleType teaches the decoder to accept rules whose type tag is name,
+// decoding each such rule by passing its complete JSON encoding to decode. The
+// rule returned is then processed as a built-in rule would be, for example by
+// applying the defaults of ParseOptions. Rules are always encoded using the
+// built-in types, so a rule of a registered type does not survive a round
+// trip through MarshalJSON unless it is equivalent to a built-in rule.
+//
+// RegisterRuleType is meant to be called during program initialization, for
+// example from an init function; it must not be called concurrently with
+// itself or with any of the Parse functions. It panics if name is empty or is
+// already registered, or if decode is nil.
+func RegisterRuleType(name string, decode func(json.RawMessage) (Rule, error)) {
+	if decode == nil {
+		panic("metadata: nil decoder for rule type " + name)
+	}
+	registerRuleType(name, ruleType{custom: decode})
+}
+
+func registerRuleType(name string, t ruleType) {
+	if name == "" {
+		panic("metadata: empty rule type name")
+	} else if _, ok := ruleTypes[name]; ok {
+		panic(fmt.Sprintf("metadata: rule type %q registered twice", name))
+	}
+	ruleTypes[name] = t
+	KnownRuleTypes = append(KnownRuleTypes, name)
+}
+
+// isKnownRuleType reports whether t is a registered rule type.
+func isKnownRuleType(t string) bool {
+	_, ok := ruleTypes[t]
+	return ok
+}
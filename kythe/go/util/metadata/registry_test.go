@@ -0,0 +1,130 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"kythe.io/kythe/go/test/testutil"
+	"kythe.io/kythe/go/util/schema/edges"
+
+	mfpb "kythe.io/kythe/proto/metadata_file_go_proto"
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+// testRuleType is a custom rule type registered for testing, whose rules
+// give a signature and a position.
+const testRuleType = "test_defines"
+
+func init() {
+	RegisterRuleType(testRuleType, func(data json.RawMessage) (Rule, error) {
+		var meta struct {
+			At  int    `json:"at"`
+			Sig string `json:"sig"`
+		}
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return Rule{}, err
+		}
+		return Rule{
+			Begin:   meta.At,
+			End:     meta.At + len(meta.Sig),
+			EdgeIn:  edges.DefinesBinding,
+			EdgeOut: edges.Generates,
+			Reverse: true,
+			VName:   &spb.VName{Signature: meta.Sig},
+		}, nil
+	})
+}
+
+func TestRegisterRuleType(t *testing.T) {
+	const input = `{"type":"kythe0","meta":[
+  {"type":"nop","begin":1,"end":2},
+  {"type":"test_defines","at":10,"sig":"foo"}
+]}`
+	want := Rules{{Begin: 1, End: 2}, {
+		Begin:   10,
+		End:     13,
+		EdgeIn:  edges.DefinesBinding,
+		EdgeOut: edges.Generates,
+		Reverse: true,
+		VName:   &spb.VName{Corpus: "dc", Signature: "foo"},
+	}}
+	got, err := ParseWithDefaults(strings.NewReader(input), &spb.VName{Corpus: "dc"})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("Parse: %v", err)
+	}
+
+	// Registered types are known to strict mode.
+	if _, err := ParseStrict(strings.NewReader(input)); err != nil {
+		t.Errorf("ParseStrict failed: %v", err)
+	}
+	if !isKnownRuleType(testRuleType) || KnownRuleTypes[len(KnownRuleTypes)-1] != testRuleType {
+		t.Errorf("KnownRuleTypes = %q, want it to end with %q", KnownRuleTypes, testRuleType)
+	}
+
+	// Errors from the registered decoder are attributed to the rule.
+	_, err = Parse(strings.NewReader(`{"type":"kythe0","meta":[{"type":"nop"},{"type":"test_defines","sig":1}]}`))
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Index != 1 {
+		t.Errorf("Parse: got error %v, want *ParseError for rule 1", err)
+	}
+
+	// The binary encoding is supported too.
+	bin, err := proto.Marshal(&mfpb.MetadataFile{
+		Type: MetadataType,
+		Meta: []*mfpb.MetadataFile_Rule{{
+			Type:  testRuleType,
+			Extra: map[string][]byte{"at": []byte("4"), "sig": []byte(`"x"`)},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Encoding failed: %v", err)
+	}
+	if rs, err := ParseProto(bin); err != nil {
+		t.Errorf("ParseProto failed: %v", err)
+	} else if len(rs) != 1 || rs[0].Begin != 4 || rs[0].VName.GetSignature() != "x" {
+		t.Errorf("ParseProto: got %v, want a rule for x at 4", rs)
+	}
+}
+
+func TestRegisterRuleTypePanics(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		decode func(json.RawMessage) (Rule, error)
+	}{
+		{"", func(json.RawMessage) (Rule, error) { return Rule{}, nil }},
+		{"nop", func(json.RawMessage) (Rule, error) { return Rule{}, nil }},
+		{"new_type", nil},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterRuleType(%q) did not panic", test.name)
+				}
+			}()
+			RegisterRuleType(test.name, test.decode)
+		}()
+	}
+}
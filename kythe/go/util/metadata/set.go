@@ -0,0 +1,262 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+// Merge returns the rules of rs and other together, in the order imposed by
+// Rules.Sort, with exact duplicates removed. Two rules are duplicates if they
+// are equal as reported by Rule.Equal; of a set of duplicates, only the first
+// is kept, where the rules of rs precede those of other. Neither rs nor other
+// is modified.
+func (rs Rules) Merge(other Rules) Rules {
+	all := make(Rules, 0, len(rs)+len(other))
+	all = append(append(all, rs...), other...)
+	sort.SliceStable(all, func(i, j int) bool { return ruleLess(all[i], all[j]) })
+
+	seen := make(map[string]bool)
+	out := all[:0]
+	for _, r := range all {
+		if key := ruleKey(r); !seen[key] {
+			seen[key] = true
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Coalesce returns a copy of rs in which each set of rules that differ only in
+// their spans, and whose spans are adjacent or overlap, is replaced by a
+// single rule spanning their union. Rules that differ in any other field, such
+// as their edge kinds or vnames, are never merged. Whole-file rules,
+// anchor_anchor rules, rules whose span is given by a Position, and rules with
+// inverted spans are kept as they are. The result is in the order imposed by
+// Rules.Sort; the receiver is not modified.
+func (rs Rules) Coalesce() Rules {
+	if rs == nil {
+		return nil
+	}
+	out := make(Rules, 0, len(rs))
+	groups := make(map[string]Rules)
+	var keys []string // in order of first appearance
+	for _, r := range rs {
+		if r.WholeFile || r.TargetSpan != nil || r.Position != nil || r.Begin > r.End {
+			out = append(out, r)
+			continue
+		}
+		key := spanlessKey(r)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], r)
+	}
+	for _, key := range keys {
+		g := groups[key]
+		sort.SliceStable(g, func(i, j int) bool {
+			if g[i].Begin != g[j].Begin {
+				return g[i].Begin < g[j].Begin
+			}
+			return g[i].End < g[j].End
+		})
+		cur := g[0]
+		for _, r := range g[1:] {
+			if r.Begin > cur.End {
+				out = append(out, cur)
+				cur = r
+			} else if r.End > cur.End {
+				cur.End = r.End
+			}
+		}
+		out = append(out, cur)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return ruleLess(out[i], out[j]) })
+	return out
+}
+
+// spanlessKey returns a string that is equal for two rules exactly when they
+// differ at most in their Begin and End offsets.
+func spanlessKey(r Rule) string {
+	r.Begin, r.End = 0, 0
+	key, _ := json.Marshal(encodeRule(r)) // errors only for bad Extra values
+	return string(key)
+}
+
+// Filter returns a new slice holding the rules of rs for which pred reports
+// true, in their original order. It returns nil if no rules match. The
+// receiver is not modified.
+func (rs Rules) Filter(pred func(Rule) bool) Rules {
+	var out Rules
+	for _, r := range rs {
+		if pred(r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// WithEdge returns the rules of rs that match or emit edges of the given kind,
+// that is, those whose EdgeIn or EdgeOut is kind, as Filter.
+func (rs Rules) WithEdge(kind string) Rules {
+	return rs.Filter(func(r Rule) bool { return r.EdgeIn == kind || r.EdgeOut == kind })
+}
+
+// Targets returns the rules of rs whose vname matches pattern, as Filter. Empty
+// fields of pattern match any value, as MatchVNames; rules without a vname
+// match only a nil pattern.
+func (rs Rules) Targets(pattern *spb.VName) Rules {
+	return rs.Filter(func(r Rule) bool { return MatchVNames(pattern, r.VName) })
+}
+
+// FilterByScore returns the rules of rs whose score is at least min, as
+// Filter. Rules without a score are kept, since a producer that does not
+// record scores is taken to be confident in every rule.
+func (rs Rules) FilterByScore(min float64) Rules {
+	return rs.Filter(func(r Rule) bool { return r.Score == nil || *r.Score >= min })
+}
+
+// GroupBySourcePath returns the rules of rs grouped by the path of their vname,
+// for example to write each group to a separate shard. Rules without a vname
+// or whose vname has no path are grouped under the empty string. Within each
+// group, the rules are sorted by Begin offset and then by End offset; rules
+// with equal spans keep their order in rs. The receiver is not modified.
+func (rs Rules) GroupBySourcePath() map[string]Rules {
+	groups := make(map[string]Rules)
+	for _, r := range rs {
+		path := r.VName.GetPath()
+		groups[path] = append(groups[path], r)
+	}
+	for _, g := range groups {
+		sort.SliceStable(g, func(i, j int) bool {
+			if g[i].Begin != g[j].Begin {
+				return g[i].Begin < g[j].Begin
+			}
+			return g[i].End < g[j].End
+		})
+	}
+	return groups
+}
+
+// Diff reports the differences between two versions of a rule set, for
+// example to summarize a regeneration of its metadata. The added rules are
+// those of after that are not in before, and the removed rules are those of
+// before that are not in after. Rules are compared in the canonical form given
+// by Canonicalize, so the order of the inputs does not matter, and each
+// result holds canonical rules in canonical order with duplicates removed.
+// Neither argument is modified.
+func Diff(before, after Rules) (added, removed Rules) {
+	b, a := canonicalRules(before), canonicalRules(after)
+	return keyedDiff(a, b), keyedDiff(b, a)
+}
+
+// Subtract returns the rules of rs that are not in other, for example to find
+// the rules a project adds to an organization-wide rule set. Rules are
+// compared in canonical form as by Diff, and the result holds canonical rules
+// in canonical order with duplicates removed. Neither rs nor other is
+// modified.
+func (rs Rules) Subtract(other Rules) Rules {
+	return keyedDiff(canonicalRules(rs), canonicalRules(other))
+}
+
+// Intersect returns the rules of rs that are also in other. Rules are
+// compared in canonical form as by Diff, and the result holds canonical rules
+// in canonical order with duplicates removed. Neither rs nor other is
+// modified.
+func (rs Rules) Intersect(other Rules) Rules {
+	keep := make(map[string]bool)
+	for _, k := range canonicalRules(other) {
+		keep[k.key] = true
+	}
+	var out Rules
+	for _, k := range canonicalRules(rs) {
+		if keep[k.key] {
+			delete(keep, k.key) // report each rule only once
+			out = append(out, k.rule)
+		}
+	}
+	return out
+}
+
+// keyedDiff returns the distinct rules of ks whose keys do not appear in
+// other, in their order in ks.
+func keyedDiff(ks, other []keyedRule) Rules {
+	skip := make(map[string]bool, len(other))
+	for _, k := range other {
+		skip[k.key] = true
+	}
+	var out Rules
+	for _, k := range ks {
+		if !skip[k.key] {
+			skip[k.key] = true
+			out = append(out, k.rule)
+		}
+	}
+	return out
+}
+
+// ruleKey returns a string that is equal for two rules exactly when they are
+// duplicates, in the sense of Merge, that is, when Rule.Equal reports true.
+func ruleKey(r Rule) string {
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(r.Begin))
+	sb.WriteByte(':')
+	sb.WriteString(strconv.Itoa(r.End))
+	if r.WholeFile {
+		sb.WriteByte('*')
+	}
+	if r.RuneOffsets {
+		sb.WriteByte('r')
+	}
+	sb.WriteString(strconv.Quote(r.EdgeIn))
+	sb.WriteString(strconv.Quote(r.EdgeOut))
+	sb.WriteString(strconv.FormatBool(r.Reverse))
+	sb.WriteString(strconv.Itoa(int(r.Semantic)))
+	if t := r.TargetSpan; t != nil {
+		sb.WriteString("@" + strconv.Itoa(t.Begin) + ":" + strconv.Itoa(t.End))
+	}
+	if p := r.Position; p != nil {
+		sb.WriteString("L" + strconv.Itoa(p.BeginLine) + ":" + strconv.Itoa(p.BeginCol))
+		sb.WriteString("-" + strconv.Itoa(p.EndLine) + ":" + strconv.Itoa(p.EndCol))
+		sb.WriteString(p.Units.String())
+	}
+	if r.Ordinal != nil {
+		sb.WriteString("#" + strconv.Itoa(*r.Ordinal))
+	}
+	if r.Score != nil {
+		sb.WriteString("~" + strconv.FormatFloat(*r.Score, 'g', -1, 64))
+	}
+	// As for Rule.Equal, a nil vname is the same as an empty one.
+	v := r.VName
+	if v == nil {
+		v = new(spb.VName)
+	}
+	for _, f := range []string{v.Corpus, v.Root, v.Path, v.Language, v.Signature} {
+		sb.WriteString(strconv.Quote(f))
+	}
+	for _, key := range sortedKeys(r.Extra) {
+		sb.WriteByte(0)
+		sb.WriteString(strconv.Quote(key))
+		sb.WriteString(strconv.Quote(string(r.Extra[key])))
+	}
+	return sb.String()
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"encoding/json"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+	"kythe.io/kythe/go/util/schema/edges"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+func TestMerge(t *testing.T) {
+	def := func(begin, end int, sig string) Rule {
+		return Rule{
+			Begin:   begin,
+			End:     end,
+			EdgeIn:  edges.DefinesBinding,
+			EdgeOut: edges.Generates,
+			Reverse: true,
+			VName:   &spb.VName{Signature: sig},
+		}
+	}
+	ref := func(begin, end int, sig string) Rule {
+		r := def(begin, end, sig)
+		r.EdgeIn = edges.Ref
+		return r
+	}
+	defs := Rules{def(20, 25, "b"), def(1, 5, "a"), def(1, 5, "a")}
+	refs := Rules{ref(30, 31, "a"), def(20, 25, "b"), ref(1, 5, "a"), {}}
+	want := Rules{{}, def(1, 5, "a"), ref(1, 5, "a"), def(20, 25, "b"), ref(30, 31, "a")}
+
+	got := defs.Merge(refs)
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("Merge: %v", err)
+	}
+	if defs[0].Begin != 20 || len(defs) != 3 {
+		t.Errorf("Merge modified its receiver: %v", defs)
+	}
+
+	// Rules that differ only in their ordinal are not duplicates.
+	p0, p1 := def(1, 2, "x"), def(1, 2, "x")
+	p0.Ordinal, p1.Ordinal = intPtr(0), intPtr(1)
+	if got := (Rules{p0}).Merge(Rules{p1, p0}); len(got) != 2 {
+		t.Errorf("Merge of distinct ordinals: got %v, want 2 rules", got)
+	}
+
+	// Nor are rules that differ in any other field compared by Rule.Equal.
+	for _, mod := range []func(*Rule){
+		func(r *Rule) { r.Position = &LineSpan{BeginLine: 1, EndLine: 1, EndCol: 1} },
+		func(r *Rule) { r.Semantic = SemanticSet },
+		func(r *Rule) { r.Score = floatPtr(0.5) },
+		func(r *Rule) { r.Extra = map[string]json.RawMessage{"k": json.RawMessage(`1`)} },
+		func(r *Rule) { r.RuneOffsets = true },
+	} {
+		a, b := def(1, 2, "x"), def(1, 2, "x")
+		mod(&b)
+		if got := (Rules{a}).Merge(Rules{b}); len(got) != 2 {
+			t.Errorf("Merge of %v and %v: got %v, want 2 rules", a, b, got)
+		}
+	}
+	a, b := Rule{Begin: 1, Extra: map[string]json.RawMessage{}}, Rule{Begin: 1, VName: &spb.VName{}}
+	if got := (Rules{a}).Merge(Rules{b}); len(got) != 1 {
+		t.Errorf("Merge of equal rules %v and %v: got %v, want 1 rule", a, b, got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	rs := Rules{
+		{Begin: 1, EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates, VName: &spb.VName{Corpus: "c", Signature: "a"}},
+		{Begin: 2, EdgeIn: edges.Ref, EdgeOut: edges.Generates, VName: &spb.VName{Corpus: "c", Signature: "b"}},
+		{Begin: 3, EdgeIn: edges.DefinesBinding, EdgeOut: AnchorAnchorEdge, VName: &spb.VName{Corpus: "d", Signature: "a"}},
+		{Begin: 4},
+	}
+	begins := func(rs Rules) (out []int) {
+		for _, r := range rs {
+			out = append(out, r.Begin)
+		}
+		return out
+	}
+	tests := []struct {
+		got  Rules
+		want []int
+	}{
+		{rs.WithEdge(edges.DefinesBinding), []int{1, 3}},
+		{rs.WithEdge(edges.Generates), []int{1, 2}},
+		{rs.WithEdge(edges.Ref), []int{2}},
+		{rs.WithEdge("/no/such/edge"), nil},
+		{rs.Filter(func(r Rule) bool { return r.Begin%2 == 0 }), []int{2, 4}},
+		{rs.Targets(&spb.VName{Signature: "a"}), []int{1, 3}},
+		{rs.Targets(&spb.VName{Corpus: "c"}), []int{1, 2}},
+		{rs.Targets(&spb.VName{Corpus: "c", Signature: "a"}), []int{1}},
+		{rs.Targets(&spb.VName{}), []int{1, 2, 3}},
+		{rs.Targets(nil), []int{1, 2, 3, 4}},
+		{rs.Targets(&spb.VName{Language: "go"}), nil},
+	}
+	for i, test := range tests {
+		if err := testutil.DeepEqual(test.want, begins(test.got)); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+	if err := testutil.DeepEqual([]int{1, 2, 3, 4}, begins(rs)); err != nil {
+		t.Errorf("Filter modified its receiver: %v", err)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	a := &spb.VName{Corpus: "c", Signature: "a"}
+	b := &spb.VName{Corpus: "c", Signature: "b"}
+	def := func(begin, end int, v *spb.VName) Rule {
+		return Rule{Begin: begin, End: end, EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates, Reverse: true, VName: v}
+	}
+	rs := Rules{
+		def(4, 7, a),
+		def(0, 4, a),
+		def(7, 9, a), // three adjacent rules become [0, 9)
+		def(20, 25, a),
+		def(22, 30, a), // overlapping rules become [20, 30)
+		def(31, 33, a), // separated from [20, 30) by a gap
+		def(9, 12, b),  // adjacent to [0, 9), but a different vname
+		def(12, 14, b),
+		{Begin: 0, End: 9, EdgeIn: edges.Ref, EdgeOut: edges.Ref, VName: a},
+		{Begin: 9, End: 11, EdgeIn: edges.Ref, EdgeOut: edges.Ref, VName: a, Ordinal: intPtr(1)},
+		{WholeFile: true, EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates, VName: a},
+		{Begin: 14, End: 16, EdgeIn: edges.DefinesBinding, EdgeOut: AnchorAnchorEdge, VName: a, TargetSpan: &Span{Begin: 1, End: 3}},
+		{Begin: 16, End: 18, EdgeIn: edges.DefinesBinding, EdgeOut: AnchorAnchorEdge, VName: a, TargetSpan: &Span{Begin: 1, End: 3}},
+	}
+	want := Rules{
+		{WholeFile: true, EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates, VName: a},
+		def(0, 9, a),
+		{Begin: 0, End: 9, EdgeIn: edges.Ref, EdgeOut: edges.Ref, VName: a},
+		{Begin: 9, End: 11, EdgeIn: edges.Ref, EdgeOut: edges.Ref, VName: a, Ordinal: intPtr(1)},
+		def(9, 14, b),
+		{Begin: 14, End: 16, EdgeIn: edges.DefinesBinding, EdgeOut: AnchorAnchorEdge, VName: a, TargetSpan: &Span{Begin: 1, End: 3}},
+		{Begin: 16, End: 18, EdgeIn: edges.DefinesBinding, EdgeOut: AnchorAnchorEdge, VName: a, TargetSpan: &Span{Begin: 1, End: 3}},
+		def(20, 30, a),
+		def(31, 33, a),
+	}
+	if err := testutil.DeepEqual(want, rs.Coalesce()); err != nil {
+		t.Errorf("Coalesce: %v", err)
+	}
+	if rs[0].Begin != 4 || rs[0].End != 7 || len(rs) != 13 {
+		t.Errorf("Coalesce modified its receiver: %v", rs)
+	}
+	if got := Rules(nil).Coalesce(); got != nil {
+		t.Errorf("Coalesce of nil: got %v, want nil", got)
+	}
+}
+
+func TestFilterByScore(t *testing.T) {
+	rs := Rules{
+		{Begin: 1, Score: floatPtr(0.9)},
+		{Begin: 2},
+		{Begin: 3, Score: floatPtr(0.2)},
+		{Begin: 4, Score: floatPtr(0.5)},
+	}
+	tests := []struct {
+		min  float64
+		want Rules
+	}{
+		{0, rs},
+		{0.5, Rules{rs[0], rs[1], rs[3]}},
+		{0.95, Rules{rs[1]}},
+	}
+	for _, test := range tests {
+		if err := testutil.DeepEqual(test.want, rs.FilterByScore(test.min)); err != nil {
+			t.Errorf("FilterByScore(%v): %v", test.min, err)
+		}
+	}
+}
+
+func TestGroupBySourcePath(t *testing.T) {
+	a := &spb.VName{Corpus: "c", Path: "a.proto", Signature: "1"}
+	b := &spb.VName{Corpus: "c", Path: "b.proto", Signature: "2"}
+	noPath := &spb.VName{Corpus: "c", Signature: "3"}
+	rs := Rules{
+		{Begin: 20, End: 25, VName: a},
+		{Begin: 5, End: 10, VName: b},
+		{Begin: 5, End: 9, VName: a, EdgeOut: "x"},
+		{Begin: 5, End: 9, VName: a, EdgeOut: "y"},
+		{Begin: 1, End: 2},
+		{Begin: 0, End: 3, VName: noPath},
+	}
+	want := map[string]Rules{
+		"a.proto": {
+			{Begin: 5, End: 9, VName: a, EdgeOut: "x"},
+			{Begin: 5, End: 9, VName: a, EdgeOut: "y"},
+			{Begin: 20, End: 25, VName: a},
+		},
+		"b.proto": {{Begin: 5, End: 10, VName: b}},
+		"":        {{Begin: 0, End: 3, VName: noPath}, {Begin: 1, End: 2}},
+	}
+	if err := testutil.DeepEqual(want, rs.GroupBySourcePath()); err != nil {
+		t.Errorf("GroupBySourcePath: %v", err)
+	}
+	if rs[0].Begin != 20 {
+		t.Errorf("GroupBySourcePath modified its receiver: %v", rs)
+	}
+	if got := Rules(nil).GroupBySourcePath(); len(got) != 0 {
+		t.Errorf("GroupBySourcePath of no rules: got %v, want empty", got)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	def := func(begin, end int, sig string) Rule {
+		return Rule{
+			Begin:   begin,
+			End:     end,
+			EdgeIn:  edges.DefinesBinding,
+			EdgeOut: edges.Generates,
+			Reverse: true,
+			VName:   &spb.VName{Corpus: "c", Signature: sig},
+		}
+	}
+	before := Rules{def(1, 2, "a"), def(3, 4, "b"), def(5, 6, "c"), def(5, 6, "c")}
+	after := Rules{def(9, 10, "e"), def(5, 6, " c "), def(7, 8, "d"), def(1, 2, "a")}
+
+	added, removed := Diff(before, after)
+	if err := testutil.DeepEqual(Rules{def(7, 8, "d"), def(9, 10, "e")}, added); err != nil {
+		t.Errorf("Diff: wrong added rules: %v", err)
+	}
+	if err := testutil.DeepEqual(Rules{def(3, 4, "b")}, removed); err != nil {
+		t.Errorf("Diff: wrong removed rules: %v", err)
+	}
+
+	// Reordering the inputs does not change the result.
+	if added, removed := Diff(before, Rules{after[3], after[1], after[2], after[0]}); len(added) != 2 || len(removed) != 1 {
+		t.Errorf("Diff of reordered rules: got %d added, %d removed; want 2, 1", len(added), len(removed))
+	}
+	if added, removed := Diff(after, Rules{after[2], after[0], after[3], after[1]}); added != nil || removed != nil {
+		t.Errorf("Diff of permuted rules: got %v added, %v removed; want none", added, removed)
+	}
+}
+
+func TestSubtractIntersect(t *testing.T) {
+	ref := func(begin int, sig string) Rule {
+		return Rule{
+			Begin:   begin,
+			End:     begin + 1,
+			EdgeIn:  edges.Ref,
+			EdgeOut: edges.Ref,
+			VName:   &spb.VName{Corpus: "c", Signature: sig},
+		}
+	}
+	a, b, c, d := ref(1, "a"), ref(2, "b"), ref(3, "c"), ref(4, "d")
+	tests := []struct {
+		desc                string
+		rs, other           Rules
+		subtract, intersect Rules
+	}{
+		{"empty", nil, nil, nil, nil},
+		{"disjoint", Rules{b, a}, Rules{c, d}, Rules{a, b}, nil},
+		{"identical", Rules{a, b, c}, Rules{c, a, b}, nil, Rules{a, b, c}},
+		{"overlapping", Rules{c, a, b, a}, Rules{d, b, b}, Rules{a, c}, Rules{b}},
+		{"canonical", Rules{a}, Rules{{
+			Begin:   1,
+			End:     2,
+			EdgeIn:  edges.Ref,
+			EdgeOut: edges.Ref,
+			VName:   &spb.VName{Corpus: " c", Signature: "a "},
+			Extra:   map[string]json.RawMessage{},
+		}}, nil, Rules{a}},
+	}
+	for _, test := range tests {
+		if err := testutil.DeepEqual(test.subtract, test.rs.Subtract(test.other)); err != nil {
+			t.Errorf("Subtract (%s): %v", test.desc, err)
+		}
+		if err := testutil.DeepEqual(test.intersect, test.rs.Intersect(test.other)); err != nil {
+			t.Errorf("Intersect (%s): %v", test.desc, err)
+		}
+	}
+}
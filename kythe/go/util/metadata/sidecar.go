@@ -0,0 +1,93 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import "fmt"
+
+// DefaultMetaSuffix is the suffix that, by convention, is appended to the path
+// of a generated source file to give the path of its metadata file among the
+// required inputs of a compilation. For example, the metadata for "foo.pb.go"
+// is stored as "foo.pb.go.meta".
+const DefaultMetaSuffix = ".meta"
+
+// MetadataPath returns the candidate paths of the metadata file for the source
+// file at sourcePath, one for each of the given suffixes in order, for a
+// caller to probe in turn. For example, with suffixes ".meta" and ".meta.json",
+// the candidates for "foo.pb.go" are "foo.pb.go.meta" and
+// "foo.pb.go.meta.json". Empty suffixes are ignored. If no non-empty suffix is
+// given, the result is the single candidate formed with DefaultMetaSuffix.
+func MetadataPath(sourcePath string, suffixes ...string) []string {
+	var paths []string
+	for _, suffix := range suffixes {
+		if suffix != "" {
+			paths = append(paths, sourcePath+suffix)
+		}
+	}
+	if len(paths) == 0 {
+		paths = []string{sourcePath + DefaultMetaSuffix}
+	}
+	return paths
+}
+
+// SidecarOptions control the behaviour of ForSourceFileWithOptions. A nil
+// *SidecarOptions provides default values.
+type SidecarOptions struct {
+	// The suffix appended to a source path to give the path of its
+	// metadata file. If empty, and Suffixes has no non-empty entry,
+	// DefaultMetaSuffix is used.
+	Suffix string
+
+	// Further suffixes to try in order, if there is no metadata file for
+	// Suffix. The first candidate found is used.
+	Suffixes []string
+}
+
+// metadataPaths returns the candidate metadata paths for sourcePath, as
+// MetadataPath with the suffixes given by o.
+func (o *SidecarOptions) metadataPaths(sourcePath string) []string {
+	if o == nil {
+		return MetadataPath(sourcePath)
+	}
+	return MetadataPath(sourcePath, append([]string{o.Suffix}, o.Suffixes...)...)
+}
+
+// ForSourceFile returns the rules that apply to the source file at sourcePath,
+// given the contents of the required inputs of a compilation keyed by path.
+// The metadata file is located by the DefaultMetaSuffix convention, and parsed
+// as ParseBytes. If there is no such file, ForSourceFile returns nil rules and
+// no error.
+func ForSourceFile(inputs map[string][]byte, sourcePath string) (Rules, error) {
+	return ForSourceFileWithOptions(inputs, sourcePath, nil)
+}
+
+// ForSourceFileWithOptions behaves as ForSourceFile, using the settings from
+// opts. The candidate paths are probed in the order given by MetadataPath, and
+// only the first that is present among inputs is parsed.
+func ForSourceFileWithOptions(inputs map[string][]byte, sourcePath string, opts *SidecarOptions) (Rules, error) {
+	for _, metaPath := range opts.metadataPaths(sourcePath) {
+		bits, ok := inputs[metaPath]
+		if !ok {
+			continue
+		}
+		rs, err := ParseBytes(bits)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", metaPath, err)
+		}
+		return rs, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+)
+
+func TestForSourceFile(t *testing.T) {
+	const meta = `{"type":"kythe0","meta":[{"type":"nop","begin":1,"end":2}]}`
+	inputs := map[string][]byte{
+		"gen/foo.pb.go":          []byte("package foo"),
+		"gen/foo.pb.go.meta":     []byte(meta),
+		"gen/bar.pb.go.kythe":    []byte(meta),
+		"gen/broken.pb.go.meta":  []byte(`{"type":"kythe0","meta":[{"type":"bogus"}]}`),
+		"gen/foo.pb.go.meta.bak": []byte("junk"),
+	}
+	want := Rules{{Begin: 1, End: 2}}
+
+	if rs, err := ForSourceFile(inputs, "gen/foo.pb.go"); err != nil {
+		t.Errorf("ForSourceFile failed: %v", err)
+	} else if err := testutil.DeepEqual(want, rs); err != nil {
+		t.Errorf("ForSourceFile: %v", err)
+	}
+	if rs, err := ForSourceFile(inputs, "gen/bar.pb.go"); rs != nil || err != nil {
+		t.Errorf("ForSourceFile without metadata: got (%v, %v), want (nil, nil)", rs, err)
+	}
+	if rs, err := ForSourceFileWithOptions(inputs, "gen/bar.pb.go", &SidecarOptions{Suffix: ".kythe"}); err != nil {
+		t.Errorf("ForSourceFileWithOptions failed: %v", err)
+	} else if err := testutil.DeepEqual(want, rs); err != nil {
+		t.Errorf("ForSourceFileWithOptions: %v", err)
+	}
+	opts := &SidecarOptions{Suffixes: []string{".kythe", ".meta.bak"}}
+	if rs, err := ForSourceFileWithOptions(inputs, "gen/bar.pb.go", opts); err != nil {
+		t.Errorf("ForSourceFileWithOptions with suffixes failed: %v", err)
+	} else if err := testutil.DeepEqual(want, rs); err != nil {
+		t.Errorf("ForSourceFileWithOptions with suffixes: %v", err)
+	}
+	if rs, err := ForSourceFileWithOptions(inputs, "gen/foo.pb.go", opts); err == nil {
+		t.Errorf("ForSourceFileWithOptions: got %v, want error for %q", rs, "gen/foo.pb.go.meta.bak")
+	}
+
+	rs, err := ForSourceFile(inputs, "gen/broken.pb.go")
+	if !errors.Is(err, ErrMalformed) {
+		t.Errorf("ForSourceFile of malformed metadata: got (%v, %v), want %v", rs, err, ErrMalformed)
+	} else if !strings.Contains(err.Error(), "gen/broken.pb.go.meta") {
+		t.Errorf("ForSourceFile: error %q does not name the metadata file", err)
+	}
+}
+
+func TestMetadataPath(t *testing.T) {
+	tests := []struct {
+		suffixes []string
+		want     []string
+	}{
+		{nil, []string{"gen/foo.pb.go.meta"}},
+		{[]string{".meta"}, []string{"gen/foo.pb.go.meta"}},
+		{[]string{".meta", ".meta.json"}, []string{"gen/foo.pb.go.meta", "gen/foo.pb.go.meta.json"}},
+		{[]string{"", ".kythe"}, []string{"gen/foo.pb.go.kythe"}},
+		{[]string{""}, []string{"gen/foo.pb.go.meta"}},
+	}
+	for _, test := range tests {
+		got := MetadataPath("gen/foo.pb.go", test.suffixes...)
+		if err := testutil.DeepEqual(test.want, got); err != nil {
+			t.Errorf("MetadataPath(%q): %v", test.suffixes, err)
+		}
+	}
+}
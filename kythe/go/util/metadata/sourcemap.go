@@ -0,0 +1,236 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"kythe.io/kythe/go/util/schema/edges"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+// FromSourceMap constructs a set of rules from a version 3 source map, as
+// written by JavaScript compilers such as tsc, for the generated file whose
+// vname is generatedFile. Each source named by the map is denoted by a file
+// vname whose path is resolved relative to the directory of generatedFile,
+// and whose corpus and root are taken from base, or from generatedFile if base
+// is nil. Maps that name several sources are supported; indexed maps, which
+// have sections rather than mappings, are not.
+//
+// Each segment of the mappings that has a source position yields a rule for
+// the span of the generated file from the segment to the next segment on the
+// same line. The map does not record where the last segment of a line ends,
+// so no rule is made for it. If the segment names an identifier that is found
+// at the source position in the content of its source file, as included in
+// the map, the rule is an anchor_anchor rule whose target span is the
+// identifier, and whose edge runs from the source anchor, which imputes the
+// generated text. Otherwise, it is a generates rule drawn from the source file.
+//
+// Columns in source maps count UTF-16 code units. The spans of the generated
+// file are given by the Position of each rule, whose Units are ColumnUTF16;
+// resolve them against the generated text with ResolveLineColumns. Source
+// positions are converted to byte offsets using the source content.
+func FromSourceMap(sm []byte, generatedFile, base *spb.VName) (Rules, error) {
+	var m struct {
+		Version        int             `json:"version"`
+		SourceRoot     string          `json:"sourceRoot"`
+		Sources        []string        `json:"sources"`
+		SourcesContent []*string       `json:"sourcesContent"`
+		Names          []string        `json:"names"`
+		Mappings       string          `json:"mappings"`
+		Sections       json.RawMessage `json:"sections"`
+	}
+	if err := json.Unmarshal(sm, &m); err != nil {
+		return nil, fmt.Errorf("metadata: invalid source map: %v", err)
+	} else if m.Version != 3 {
+		return nil, fmt.Errorf("metadata: unsupported source map version %d", m.Version)
+	} else if m.Sections != nil {
+		return nil, errors.New("metadata: indexed source maps are not supported")
+	}
+	if base == nil {
+		base = generatedFile
+	}
+	dir := path.Dir(generatedFile.GetPath())
+	sources := make([]*spb.VName, len(m.Sources))
+	for i, src := range m.Sources {
+		if m.SourceRoot != "" && !path.IsAbs(src) {
+			src = path.Join(m.SourceRoot, src)
+		}
+		if !path.IsAbs(src) {
+			src = path.Join(dir, src)
+		}
+		sources[i] = &spb.VName{Corpus: base.GetCorpus(), Root: base.GetRoot(), Path: src}
+	}
+	contents := make([]*lineIndex, len(m.Sources))
+	for i, text := range m.SourcesContent {
+		if i < len(contents) && text != nil {
+			idx := newLineIndex([]byte(*text))
+			contents[i] = &idx
+		}
+	}
+
+	segs, err := decodeMappings(m.Mappings)
+	if err != nil {
+		return nil, err
+	}
+	var rs Rules
+	for i, seg := range segs {
+		if seg.source < 0 {
+			continue // no source position
+		} else if i+1 == len(segs) || segs[i+1].line != seg.line {
+			continue // the end of the span is unknown
+		} else if seg.source >= len(sources) {
+			return nil, fmt.Errorf("metadata: source map segment %d: source %d out of range", i, seg.source)
+		} else if seg.name >= len(m.Names) {
+			return nil, fmt.Errorf("metadata: source map segment %d: name %d out of range", i, seg.name)
+		}
+		r := Rule{
+			EdgeIn:  edges.DefinesBinding,
+			EdgeOut: edges.Generates,
+			Reverse: true,
+			VName:   sources[seg.source],
+			Position: &LineSpan{
+				BeginLine: seg.line + 1,
+				BeginCol:  seg.col,
+				EndLine:   seg.line + 1,
+				EndCol:    segs[i+1].col,
+				Units:     ColumnUTF16,
+			},
+		}
+		if text := contents[seg.source]; text != nil && seg.name >= 0 {
+			if t := text.find(m.Names[seg.name], seg.srcLine+1, seg.srcCol); t != nil {
+				// The source range imputes the generated text, as for
+				// a decoded anchor_anchor rule, so Reverse is kept.
+				r.EdgeOut = AnchorAnchorEdge
+				r.TargetSpan = t
+			}
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+// find returns the span of name at the given line and UTF-16 column of x, or
+// nil if the text at that position is not name.
+func (x lineIndex) find(name string, line, col int) *Span {
+	var n int
+	for _, c := range name {
+		n += utf16Width(c)
+	}
+	begin, ok := x.offset(line, col, utf16Width)
+	if !ok {
+		return nil
+	}
+	end, ok := x.offset(line, col+n, utf16Width)
+	if !ok || string(x.text[begin:end]) != name {
+		return nil
+	}
+	return &Span{Begin: begin, End: end}
+}
+
+// A mapSegment is a decoded segment of the mappings of a source map. Lines
+// and columns are numbered from 0.
+type mapSegment struct {
+	line, col       int // position in the generated file
+	source          int // index of the source, or -1 if none
+	srcLine, srcCol int // position in the source
+	name            int // index of the name, or -1 if none
+}
+
+// decodeMappings decodes the mappings field of a source map. Segments are
+// separated by commas and lines by semicolons; each segment is a sequence of
+// 1, 4, or 5 base64 VLQ values, each relative to the corresponding value of
+// the previous segment. The generated column is relative to the previous
+// segment on the same line only.
+func decodeMappings(mappings string) ([]mapSegment, error) {
+	var segs []mapSegment
+	var source, srcLine, srcCol, name int
+	for line, text := range strings.Split(mappings, ";") {
+		var col int
+		for _, s := range strings.Split(text, ",") {
+			if s == "" {
+				continue
+			}
+			vs, err := decodeVLQ(s)
+			if err != nil {
+				return nil, fmt.Errorf("metadata: source map line %d: %v", line+1, err)
+			}
+			seg := mapSegment{line: line, source: -1, name: -1}
+			switch len(vs) {
+			case 5:
+				name += vs[4]
+				seg.name = name
+				fallthrough
+			case 4:
+				source += vs[1]
+				srcLine += vs[2]
+				srcCol += vs[3]
+				seg.source, seg.srcLine, seg.srcCol = source, srcLine, srcCol
+				fallthrough
+			case 1:
+				col += vs[0]
+				seg.col = col
+			default:
+				return nil, fmt.Errorf("metadata: source map line %d: segment %q has %d fields", line+1, s, len(vs))
+			}
+			if seg.col < 0 || seg.source < -1 || seg.srcLine < 0 || seg.srcCol < 0 || seg.name < -1 {
+				return nil, fmt.Errorf("metadata: source map line %d: segment %q has a negative position", line+1, s)
+			}
+			segs = append(segs, seg)
+		}
+	}
+	return segs, nil
+}
+
+// vlqDigits are the base64 digits of the VLQ encoding.
+const vlqDigits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// decodeVLQ decodes a sequence of base64 VLQ values. Each value is encoded
+// in groups of 5 bits, least significant first, with the sixth bit of each
+// digit set if more follow; the lowest bit of the value is its sign.
+func decodeVLQ(s string) ([]int, error) {
+	var vs []int
+	var v, shift int
+	for i := 0; i < len(s); i++ {
+		d := strings.IndexByte(vlqDigits, s[i])
+		if d < 0 {
+			return nil, fmt.Errorf("invalid VLQ digit %q", s[i])
+		}
+		v |= (d & 31) << shift
+		if d&32 != 0 {
+			if shift += 5; shift > 30 {
+				return nil, errors.New("VLQ value out of range")
+			}
+			continue
+		}
+		if v&1 != 0 {
+			vs = append(vs, -(v >> 1))
+		} else {
+			vs = append(vs, v>>1)
+		}
+		v, shift = 0, 0
+	}
+	if shift != 0 {
+		return nil, errors.New("truncated VLQ value")
+	}
+	return vs, nil
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+	"kythe.io/kythe/go/util/schema/edges"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+// encodeVLQ encodes vs in the base64 VLQ format of source maps.
+func encodeVLQ(vs ...int) string {
+	var sb strings.Builder
+	for _, v := range vs {
+		u := v << 1
+		if v < 0 {
+			u = -v<<1 | 1
+		}
+		for {
+			d := u & 31
+			if u >>= 5; u > 0 {
+				d |= 32
+			}
+			sb.WriteByte(vlqDigits[d])
+			if u == 0 {
+				break
+			}
+		}
+	}
+	return sb.String()
+}
+
+// encodeMappings encodes segments given as absolute values, one slice of
+// segments per line.
+func encodeMappings(lines [][][]int) string {
+	var prev [5]int
+	var out []string
+	for _, line := range lines {
+		prev[0] = 0
+		var segs []string
+		for _, seg := range line {
+			rel := make([]int, len(seg))
+			for i, v := range seg {
+				rel[i] = v - prev[i]
+				prev[i] = v
+			}
+			segs = append(segs, encodeVLQ(rel...))
+		}
+		out = append(out, strings.Join(segs, ","))
+	}
+	return strings.Join(out, ";")
+}
+
+func TestDecodeVLQ(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []int
+	}{
+		{"AAAA", []int{0, 0, 0, 0}},
+		{"C", []int{1}},
+		{"D", []int{-1}},
+		{"gB", []int{16}},
+		{"2H", []int{123}},
+		{"IAAM", []int{4, 0, 0, 6}},
+	}
+	for _, test := range tests {
+		got, err := decodeVLQ(test.input)
+		if err != nil {
+			t.Errorf("decodeVLQ(%q) failed: %v", test.input, err)
+		} else if err := testutil.DeepEqual(test.want, got); err != nil {
+			t.Errorf("decodeVLQ(%q): %v", test.input, err)
+		}
+		if enc := encodeVLQ(test.want...); enc != test.input {
+			t.Errorf("encodeVLQ(%v): got %q, want %q", test.want, enc, test.input)
+		}
+	}
+	for _, bad := range []string{"!", "g", "gggggggggB"} {
+		if got, err := decodeVLQ(bad); err == nil {
+			t.Errorf("decodeVLQ(%q): got %v, wanted error", bad, got)
+		}
+	}
+}
+
+func TestFromSourceMap(t *testing.T) {
+	const (
+		generated = "var hello = 1;\nvar 𝒳 = hello;\n"
+		source    = "let hello: number = 1;\nlet 𝒳 = hello;\n"
+	)
+	// Segments are [genCol, source, srcLine, srcCol, name], with columns in
+	// UTF-16 code units.
+	mappings := encodeMappings([][][]int{{
+		{0, 0, 0, 0},
+		{4, 0, 0, 4, 0}, // hello
+		{9, 0, 0, 17},
+		{13, 0, 0, 21},
+	}, {
+		{0, 1, 0, 0},
+		{4, 0, 1, 4, 1}, // 𝒳
+		{6, 0, 1, 6},
+		{9, 0, 1, 9, 0}, // hello
+		{14, 0, 1, 14},
+	}})
+	sm, err := json.Marshal(map[string]interface{}{
+		"version":        3,
+		"file":           "gen.js",
+		"sources":        []string{"../src/a.ts", "b.ts"},
+		"sourcesContent": []interface{}{source, nil},
+		"names":          []string{"hello", "𝒳"},
+		"mappings":       mappings,
+	})
+	if err != nil {
+		t.Fatalf("Encoding source map: %v", err)
+	}
+
+	genFile := &spb.VName{Corpus: "gen", Path: "out/gen.js"}
+	rs, err := FromSourceMap(sm, genFile, &spb.VName{Corpus: "c", Root: "r"})
+	if err != nil {
+		t.Fatalf("FromSourceMap failed: %v", err)
+	}
+	if err := rs.ResolveLineColumns([]byte(generated)); err != nil {
+		t.Fatalf("ResolveLineColumns failed: %v", err)
+	}
+
+	type link struct {
+		Generated, Path, Kind, Target string
+	}
+	var got []link
+	for _, r := range rs {
+		l := link{Generated: generated[r.Begin:r.End], Path: r.VName.Path, Kind: r.EdgeOut}
+		// Both kinds of rule link from the source to the generated text, and
+		// an anchor_anchor rule is encoded as a forward imputes edge.
+		if t := r.TargetSpan; t != nil {
+			l.Target = source[t.Begin:t.End]
+			if !r.Reverse || encodeRule(r).Edge != AnchorAnchorEdge {
+				l.Kind = "wrong direction"
+			}
+		} else if !r.Reverse || r.EdgeOut != edges.Generates {
+			l.Kind = "wrong direction"
+		}
+		got = append(got, l)
+		if r.VName.Corpus != "c" || r.VName.Root != "r" {
+			t.Errorf("Rule %v: wrong corpus or root", r)
+		}
+	}
+	want := []link{
+		{"var ", "src/a.ts", edges.Generates, ""},
+		{"hello", "src/a.ts", AnchorAnchorEdge, "hello"},
+		{" = 1", "src/a.ts", edges.Generates, ""},
+		{"var ", "out/b.ts", edges.Generates, ""},
+		{"𝒳", "src/a.ts", AnchorAnchorEdge, "𝒳"},
+		{" = ", "src/a.ts", edges.Generates, ""},
+		{"hello", "src/a.ts", AnchorAnchorEdge, "hello"},
+	}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("FromSourceMap: %v", err)
+	}
+}
+
+func TestFromSourceMapErrors(t *testing.T) {
+	for _, bad := range []string{
+		`not json`,
+		`{"version":2,"sources":["a.ts"],"mappings":"AAAA"}`,
+		`{"version":3,"sections":[]}`,
+		`{"version":3,"sources":["a.ts"],"mappings":"A!AA"}`,
+		`{"version":3,"sources":["a.ts"],"mappings":"AA"}`,
+		`{"version":3,"sources":["a.ts"],"mappings":"ACAA,CAAA"}`,
+		`{"version":3,"sources":["a.ts"],"mappings":"AAAAC,CAAA"}`,
+		`{"version":3,"sources":["a.ts"],"mappings":"AADA"}`,
+	} {
+		if rs, err := FromSourceMap([]byte(bad), nil, nil); err == nil {
+			t.Errorf("FromSourceMap(%s): got %v, wanted error", bad, rs)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"kythe.io/kythe/go/util/schema"
+	"kythe.io/kythe/go/util/schema/edges"
+
+	scpb "kythe.io/kythe/proto/schema_go_proto"
+)
+
+// An InvalidRule describes a single problem found in a rule.
+type InvalidRule struct {
+	Index  int    // the index of the offending rule in its set
+	Reason string // a description of the problem
+}
+
+// A ValidationError is returned by Validate to report all the problems found
+// in a set of rules.
+type ValidationError []InvalidRule
+
+// Error satisfies the error interface.
+func (v ValidationError) Error() string {
+	msgs := make([]string, len(v))
+	for i, bad := range v {
+		msgs[i] = fmt.Sprintf("rule %d: %s", bad.Index, bad.Reason)
+	}
+	return "metadata: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks each rule in rs for semantic problems: The span of each rule
+// must be a non-negative, non-inverted interval, and rules that match an edge
+// (that is, all but nop rules) must specify an outbound edge kind and a target
+// vname. The resulting error, if any, has concrete type ValidationError and
+// lists every problem found.
+func (rs Rules) Validate() error {
+	var bad ValidationError
+	for i, r := range rs {
+		for _, reason := range r.problems() {
+			bad = append(bad, InvalidRule{Index: i, Reason: reason})
+		}
+	}
+	if bad != nil {
+		return bad
+	}
+	return nil
+}
+
+// problems returns descriptions of any semantic problems with r.
+func (r Rule) problems() []string {
+	var ps []string
+	if r.Begin < 0 {
+		ps = append(ps, fmt.Sprintf("negative begin offset %d", r.Begin))
+	}
+	if r.End < 0 {
+		ps = append(ps, fmt.Sprintf("negative end offset %d", r.End))
+	}
+	if r.Begin > r.End {
+		ps = append(ps, fmt.Sprintf("begin offset %d > end offset %d", r.Begin, r.End))
+	}
+	if r.WholeFile && (r.Begin != 0 || r.End != 0) {
+		ps = append(ps, fmt.Sprintf("whole-file rule with span [%d, %d)", r.Begin, r.End))
+	}
+	if r.EdgeIn != "" {
+		if r.EdgeOut == "" {
+			ps = append(ps, "missing outbound edge kind")
+		}
+		if r.VName == nil {
+			ps = append(ps, "missing target vname")
+		}
+	} else if r.Reverse && r.EdgeOut == "" {
+		ps = append(ps, "reverse rule without an outbound edge kind")
+	}
+	return ps
+}
+
+// ValidateEdges checks that the edge kinds of each rule in rs, other than nop
+// rules, are known Kythe edge kinds. An ordinal suffix, as in "param.0", is
+// permitted on kinds that take one. The resulting error, if any, has concrete
+// type ValidationError, and lists every unknown edge kind found.
+func (rs Rules) ValidateEdges() error {
+	var bad ValidationError
+	for i, r := range rs {
+		for _, reason := range r.edgeProblems() {
+			bad = append(bad, InvalidRule{Index: i, Reason: reason})
+		}
+	}
+	if bad != nil {
+		return bad
+	}
+	return nil
+}
+
+// edgeProblems returns descriptions of any unknown edge kinds of r.
+func (r Rule) edgeProblems() []string {
+	if r.EdgeIn == "" {
+		return nil // nop rules do not emit edges
+	}
+	var ps []string
+	for _, kind := range []string{r.EdgeIn, r.EdgeOut} {
+		if !knownEdgeKind(kind) {
+			ps = append(ps, fmt.Sprintf("unknown edge kind %q", kind))
+		}
+	}
+	return ps
+}
+
+// Append returns rs with r appended, if r passes the checks of both Validate
+// and ValidateEdges, so that a rule set built up incrementally can be checked
+// as each rule is added. Otherwise, it returns rs unchanged and an error of
+// concrete type ValidationError listing every problem with r, at the index r
+// would have had in the result.
+func (rs Rules) Append(r Rule) (Rules, error) {
+	var bad ValidationError
+	for _, reason := range append(r.problems(), r.edgeProblems()...) {
+		bad = append(bad, InvalidRule{Index: len(rs), Reason: reason})
+	}
+	if bad != nil {
+		return rs, bad
+	}
+	return append(rs, r), nil
+}
+
+// knownEdgeKind reports whether kind is an edge kind defined by the Kythe
+// schema, possibly in reverse or with an ordinal.
+func knownEdgeKind(kind string) bool {
+	kind = edges.Canonical(kind)
+	if base, _, ok := edges.ParseOrdinal(kind); ok {
+		if !edges.OrdinalKind(base) {
+			return false
+		}
+		kind = base
+	}
+	return schema.EdgeKind(kind) != scpb.EdgeKind_UNKNOWN_EDGE_KIND || extraEdgeKinds[kind]
+}
+
+// extraEdgeKinds are edge kinds defined by the edges package that are not
+// listed in the schema.
+var extraEdgeKinds = map[string]bool{
+	edges.ExtendsPrivate:          true,
+	edges.ExtendsPrivateVirtual:   true,
+	edges.ExtendsProtected:        true,
+	edges.ExtendsProtectedVirtual: true,
+	edges.ExtendsPublic:           true,
+	edges.ExtendsPublicVirtual:    true,
+	edges.ExtendsVirtual:          true,
+}
+
+// Overlaps returns the index pairs of rules in rs whose spans intersect.  Each
+// pair is reported once, with the smaller index first, and the pairs are
+// ordered lexicographically.  Spans are treated as half-open intervals; a
+// zero-length span (Begin == End) is a point, which overlaps a span that
+// contains it, or another point at the same offset.
+//
+// The rules need not be sorted. Overlaps takes O(n log n + k) time for n
+// rules and k overlapping pairs.
+func (rs Rules) Overlaps() [][2]int {
+	order := make([]int, len(rs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := rs[order[i]], rs[order[j]]
+		if a.Begin != b.Begin {
+			return a.Begin < b.Begin
+		}
+		return a.End < b.End
+	})
+
+	var pairs [][2]int
+	var active []int // rules that may overlap the current one
+	for _, i := range order {
+		cur := rs[i]
+		keep := active[:0]
+		for _, j := range active {
+			prev := rs[j]
+			if prev.End > cur.Begin || (prev.Begin == prev.End && prev.Begin == cur.Begin) {
+				keep = append(keep, j)
+				if spansOverlap(prev, cur) {
+					if j < i {
+						pairs = append(pairs, [2]int{j, i})
+					} else {
+						pairs = append(pairs, [2]int{i, j})
+					}
+				}
+			}
+		}
+		active = append(keep, i)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	return pairs
+}
+
+// spansOverlap reports whether the spans of a and b intersect, treating
+// zero-length spans as points.
+func spansOverlap(a, b Rule) bool {
+	aPoint, bPoint := a.Begin == a.End, b.Begin == b.End
+	switch {
+	case aPoint && bPoint:
+		return a.Begin == b.Begin
+	case aPoint:
+		return b.Begin <= a.Begin && a.Begin < b.End
+	case bPoint:
+		return a.Begin <= b.Begin && b.Begin < a.End
+	default:
+		return a.Begin < b.End && b.Begin < a.End
+	}
+}
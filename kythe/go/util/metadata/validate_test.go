@@ -0,0 +1,193 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+	"kythe.io/kythe/go/util/schema/edges"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+func TestValidate(t *testing.T) {
+	good := Rules{
+		{},
+		{Begin: 25, End: 37, EdgeOut: "blah"},
+		{
+			Begin:   179,
+			End:     182,
+			EdgeIn:  edges.DefinesBinding,
+			EdgeOut: edges.Generates,
+			Reverse: true,
+			VName:   &spb.VName{Signature: "gsig"},
+		},
+	}
+	if err := good.Validate(); err != nil {
+		t.Errorf("Validate %+v: unexpected error: %v", good, err)
+	}
+
+	bad := Rules{
+		{Begin: 10, End: 5}, // 0: inverted
+		{Begin: -1, End: 5}, // 1: negative
+		{EdgeIn: edges.DefinesBinding, VName: &spb.VName{}},      // 2: no EdgeOut
+		{EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates}, // 3: no VName
+		{Reverse: true},    // 4: reverse, no EdgeOut
+		{Begin: 1, End: 2}, // OK
+	}
+	err := bad.Validate()
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("Validate: got error %v (%T), want ValidationError", err, err)
+	}
+	var got []int
+	for _, v := range verr {
+		got = append(got, v.Index)
+	}
+	if err := testutil.DeepEqual([]int{0, 1, 2, 3, 4}, got); err != nil {
+		t.Errorf("Validate: wrong rule indices: %v", err)
+	}
+	for _, want := range []string{"rule 0:", "rule 4:"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate: error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestValidateEdges(t *testing.T) {
+	good := Rules{
+		{EdgeOut: "not checked for nop rules"},
+		{EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates},
+		{EdgeIn: edges.Ref, EdgeOut: "%" + edges.Generates},
+		{EdgeIn: edges.DefinesBinding, EdgeOut: edges.ParamIndex(3)},
+		{EdgeIn: edges.DefinesBinding, EdgeOut: edges.ExtendsPublic},
+	}
+	if err := good.ValidateEdges(); err != nil {
+		t.Errorf("ValidateEdges %+v: unexpected error: %v", good, err)
+	}
+
+	bad := Rules{
+		{EdgeIn: "defines_binding", EdgeOut: edges.Generates},
+		{EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates},
+		{EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates + ".1"},
+		{EdgeIn: edges.DefinesBinding, EdgeOut: "/kythe/edge/bogus"},
+	}
+	err := bad.ValidateEdges()
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("ValidateEdges: got error %v (%T), want ValidationError", err, err)
+	}
+	var got []int
+	for _, v := range verr {
+		got = append(got, v.Index)
+	}
+	if err := testutil.DeepEqual([]int{0, 2, 3}, got); err != nil {
+		t.Errorf("ValidateEdges: wrong rule indices: %v", err)
+	}
+	if want := `"defines_binding"`; !strings.Contains(err.Error(), want) {
+		t.Errorf("ValidateEdges: error %q does not mention %s", err, want)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	var rs Rules
+	var err error
+	for _, r := range []Rule{
+		{Begin: 1, End: 2},
+		{Begin: 3, End: 5, EdgeIn: edges.Ref, EdgeOut: edges.Ref, VName: &spb.VName{Signature: "s"}},
+		{EdgeIn: edges.DefinesBinding, EdgeOut: edges.Generates, Reverse: true, VName: &spb.VName{Path: "p"}, WholeFile: true},
+	} {
+		if rs, err = rs.Append(r); err != nil {
+			t.Fatalf("Append %v failed: %v", r, err)
+		}
+	}
+	if len(rs) != 3 {
+		t.Fatalf("Append: got %d rules, want 3", len(rs))
+	}
+
+	tests := []struct {
+		rule Rule
+		want []string // substrings of the reasons, in order
+	}{
+		{Rule{Begin: 5, End: 4}, []string{"begin offset 5 > end offset 4"}},
+		{Rule{Begin: -1, End: 2}, []string{"negative begin offset"}},
+		{Rule{Begin: 1, End: 2, EdgeIn: edges.Ref, EdgeOut: edges.Ref}, []string{"missing target vname"}},
+		{Rule{Begin: 1, End: 2, EdgeIn: edges.Ref}, []string{"missing outbound edge kind", "missing target vname", `unknown edge kind ""`}},
+		{Rule{Begin: 1, End: 2, EdgeIn: "/bogus", EdgeOut: edges.Ref, VName: &spb.VName{}}, []string{`unknown edge kind "/bogus"`}},
+	}
+	for _, test := range tests {
+		got, err := rs.Append(test.rule)
+		verr, ok := err.(ValidationError)
+		if !ok {
+			t.Errorf("Append %v: got error %v, want ValidationError", test.rule, err)
+			continue
+		} else if len(got) != len(rs) {
+			t.Errorf("Append %v: got %d rules, want %d", test.rule, len(got), len(rs))
+		}
+		if len(verr) != len(test.want) {
+			t.Errorf("Append %v: got %d problems, want %d: %v", test.rule, len(verr), len(test.want), verr)
+			continue
+		}
+		for i, bad := range verr {
+			if bad.Index != len(rs) {
+				t.Errorf("Append %v: got index %d, want %d", test.rule, bad.Index, len(rs))
+			}
+			if !strings.Contains(bad.Reason, test.want[i]) {
+				t.Errorf("Append %v: reason %q does not mention %q", test.rule, bad.Reason, test.want[i])
+			}
+		}
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	tests := []struct {
+		input Rules
+		want  [][2]int
+	}{
+		{nil, nil},
+		{Rules{{Begin: 0, End: 5}, {Begin: 5, End: 10}}, nil},
+		{Rules{{Begin: 0, End: 5}, {Begin: 4, End: 10}}, [][2]int{{0, 1}}},
+
+		// Unsorted input, with one span containing the others.
+		{Rules{
+			{Begin: 20, End: 25},
+			{Begin: 0, End: 100},
+			{Begin: 30, End: 40},
+			{Begin: 100, End: 110},
+		}, [][2]int{{0, 1}, {1, 2}}},
+
+		// Points overlap spans containing them, and points at the same offset.
+		{Rules{
+			{Begin: 5, End: 5},
+			{Begin: 0, End: 5},
+			{Begin: 5, End: 8},
+			{Begin: 5, End: 5},
+			{Begin: 8, End: 8},
+		}, [][2]int{{0, 2}, {0, 3}, {2, 3}}},
+
+		// Identical spans.
+		{Rules{{Begin: 1, End: 3}, {Begin: 1, End: 3}}, [][2]int{{0, 1}}},
+	}
+	for _, test := range tests {
+		got := test.input.Overlaps()
+		if err := testutil.DeepEqual(test.want, got); err != nil {
+			t.Errorf("Overlaps %+v: %v", test.input, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+// A SignatureTemplate is a vname signature that may contain placeholders of
+// the form ${N}, which are replaced by the Nth capture group of a regular
+// expression match over the text of an anchor. ${0} denotes the entire match.
+type SignatureTemplate string
+
+// placeholder matches a template placeholder, capturing the group number.
+var placeholder = regexp.MustCompile(`\$\{(\d+)\}`)
+
+// IsTemplate reports whether t contains any placeholders.
+func (t SignatureTemplate) IsTemplate() bool { return placeholder.MatchString(string(t)) }
+
+// Expand returns t with each placeholder replaced by the corresponding element
+// of matched, as returned by regexp.FindStringSubmatch. Placeholders with no
+// corresponding element are replaced by the empty string.
+func (t SignatureTemplate) Expand(matched []string) string {
+	return placeholder.ReplaceAllStringFunc(string(t), func(p string) string {
+		n, err := strconv.Atoi(placeholder.FindStringSubmatch(p)[1])
+		if err != nil || n >= len(matched) {
+			return ""
+		}
+		return matched[n]
+	})
+}
+
+// ResolveVName returns the vname of r with its signature expanded as a
+// SignatureTemplate using matched. If the signature contains no placeholders,
+// r.VName is returned unmodified; otherwise the result is a new vname.
+func (r Rule) ResolveVName(matched []string) *spb.VName {
+	tmpl := SignatureTemplate(r.VName.GetSignature())
+	if !tmpl.IsTemplate() {
+		return r.VName
+	}
+	v := proto.Clone(r.VName).(*spb.VName)
+	v.Signature = tmpl.Expand(matched)
+	return v
+}
+
+// VNameEqual reports whether a and b have equal fields. A nil vname is equal
+// only to another nil vname.
+func VNameEqual(a, b *spb.VName) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Signature == b.Signature &&
+		a.Corpus == b.Corpus &&
+		a.Root == b.Root &&
+		a.Path == b.Path &&
+		a.Language == b.Language
+}
+
+// MatchVNames reports whether target matches pattern. Each non-empty field of
+// pattern must equal the corresponding field of target; empty fields of
+// pattern match any value. A nil pattern matches any vname, including nil.
+// It is useful, for example, to check the vnames of the entries emitted by
+// Apply against a partial expectation.
+func MatchVNames(pattern, target *spb.VName) bool {
+	if pattern == nil {
+		return true
+	} else if target == nil {
+		return false
+	}
+	match := func(p, s string) bool { return p == "" || p == s }
+	return match(pattern.Signature, target.Signature) &&
+		match(pattern.Corpus, target.Corpus) &&
+		match(pattern.Root, target.Root) &&
+		match(pattern.Path, target.Path) &&
+		match(pattern.Language, target.Language)
+}
+
+// RemapCorpus returns a copy of rs in which the corpus of each rule vname is
+// replaced by its value in mapping, if it has one. Corpora not in mapping are
+// left unchanged. The receiver is not modified; rules whose vnames change get
+// new vnames, and the others share their vnames with rs.
+func (rs Rules) RemapCorpus(mapping map[string]string) Rules {
+	return rs.rewriteVNames(func(v *spb.VName) *spb.VName {
+		corpus, ok := mapping[v.Corpus]
+		if !ok || corpus == v.Corpus {
+			return nil
+		}
+		nv := proto.Clone(v).(*spb.VName)
+		nv.Corpus = corpus
+		return nv
+	})
+}
+
+// StripPathPrefix returns a copy of rs in which prefix is removed from the
+// path of each rule vname that begins with it, along with any slashes that
+// would otherwise begin the result. The prefix matches only whole path
+// components, so "/gen" is stripped from "/gen/foo.go" but not from
+// "/generated/foo.go". Other paths are left unchanged. The receiver is not
+// modified, as with RemapCorpus.
+func (rs Rules) StripPathPrefix(prefix string) Rules {
+	return rs.rewriteVNames(func(v *spb.VName) *spb.VName {
+		rest := strings.TrimPrefix(v.Path, prefix)
+		if prefix == "" || rest == v.Path {
+			return nil
+		} else if rest != "" && !strings.HasSuffix(prefix, "/") && rest[0] != '/' {
+			return nil // not at a component boundary
+		}
+		nv := proto.Clone(v).(*spb.VName)
+		nv.Path = strings.TrimLeft(rest, "/")
+		return nv
+	})
+}
+
+// InferLanguage returns a copy of rs in which the language of each rule vname
+// that does not set one is defaultLang, as FromGeneratedCodeInfo does for the
+// rules it constructs, since the language is part of the identity of the node
+// the vname denotes. Languages set explicitly are left unchanged, and rules
+// without a vname are not given one. The receiver is not modified, as with
+// RemapCorpus.
+func (rs Rules) InferLanguage(defaultLang string) Rules {
+	return rs.rewriteVNames(func(v *spb.VName) *spb.VName {
+		if v.Language != "" || defaultLang == "" {
+			return nil
+		}
+		nv := proto.Clone(v).(*spb.VName)
+		nv.Language = defaultLang
+		return nv
+	})
+}
+
+// rewriteVNames returns a copy of rs in which the vname of each rule is
+// replaced by the result of calling f on it, unless f returns nil.
+func (rs Rules) rewriteVNames(f func(*spb.VName) *spb.VName) Rules {
+	if rs == nil {
+		return nil
+	}
+	out := append(Rules(nil), rs...)
+	for i, r := range out {
+		if r.VName == nil {
+			continue
+		}
+		if v := f(r.VName); v != nil {
+			out[i].VName = v
+		}
+	}
+	return out
+}
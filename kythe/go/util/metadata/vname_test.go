@@ -0,0 +1,207 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"kythe.io/kythe/go/test/testutil"
+
+	spb "kythe.io/kythe/proto/storage_go_proto"
+)
+
+func TestResolveVName(t *testing.T) {
+	re := regexp.MustCompile(`Get(\w+)_(\w+)`)
+	matched := re.FindStringSubmatch("func GetFoo_bar()")
+
+	tests := []struct {
+		sig, want string
+	}{
+		{"", ""},
+		{"literal", "literal"},
+		{"$1 and {1}", "$1 and {1}"},
+		{"${1}", "Foo"},
+		{"msg.${1}.${2}", "msg.Foo.bar"},
+		{"${0}!", "GetFoo_bar!"},
+		{"x${9}y", "xy"},
+	}
+	for _, test := range tests {
+		vname := &spb.VName{Corpus: "c", Signature: test.sig}
+		r := Rule{VName: vname}
+		got := r.ResolveVName(matched)
+		if got.Signature != test.want {
+			t.Errorf("ResolveVName(%q): got signature %q, want %q", test.sig, got.Signature, test.want)
+		}
+		if got.Corpus != "c" {
+			t.Errorf("ResolveVName(%q): got corpus %q, want %q", test.sig, got.Corpus, "c")
+		}
+		if vname.Signature != test.sig {
+			t.Errorf("ResolveVName(%q) modified the rule: %v", test.sig, vname)
+		}
+		if isTmpl := SignatureTemplate(test.sig).IsTemplate(); isTmpl != (got != vname) {
+			t.Errorf("ResolveVName(%q): template %v, but copied %v", test.sig, isTmpl, got != vname)
+		}
+	}
+}
+
+func TestVNameEqual(t *testing.T) {
+	v := &spb.VName{Corpus: "c", Root: "r", Path: "p", Language: "l", Signature: "s"}
+	tests := []struct {
+		a, b *spb.VName
+		want bool
+	}{
+		{nil, nil, true},
+		{v, nil, false},
+		{nil, v, false},
+		{v, v, true},
+		{v, &spb.VName{Corpus: "c", Root: "r", Path: "p", Language: "l", Signature: "s"}, true},
+		{v, &spb.VName{Corpus: "c", Root: "r", Path: "p", Language: "l"}, false},
+		{&spb.VName{}, &spb.VName{}, true},
+	}
+	for _, test := range tests {
+		if got := VNameEqual(test.a, test.b); got != test.want {
+			t.Errorf("VNameEqual(%v, %v): got %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestMatchVNamesPatterns(t *testing.T) {
+	v := &spb.VName{Corpus: "c", Root: "r", Path: "p", Language: "l", Signature: "s"}
+	tests := []struct {
+		pattern *spb.VName
+		want    bool
+	}{
+		{nil, true},
+		{&spb.VName{}, true},
+		{&spb.VName{Signature: "s"}, true},                   // wildcard corpus
+		{&spb.VName{Corpus: "c", Signature: "s"}, true},      // exact signature
+		{&spb.VName{Corpus: "c", Signature: "other"}, false}, // wrong signature
+		{&spb.VName{Corpus: "other"}, false},
+		{v, true},
+	}
+	for _, test := range tests {
+		if got := MatchVNames(test.pattern, v); got != test.want {
+			t.Errorf("MatchVNames(%v, %v): got %v, want %v", test.pattern, v, got, test.want)
+		}
+	}
+	if MatchVNames(&spb.VName{}, nil) {
+		t.Error("MatchVNames(empty, nil): got true, want false")
+	}
+}
+
+func TestMatchVNamesWildcards(t *testing.T) {
+	target := &spb.VName{Corpus: "c", Root: "r", Path: "p", Language: "l", Signature: "s"}
+	// Each field in turn is a wildcard, while the others must match.
+	for _, field := range []string{"corpus", "root", "path", "language", "signature"} {
+		pattern := proto.Clone(target).(*spb.VName)
+		other := proto.Clone(target).(*spb.VName)
+		switch field {
+		case "corpus":
+			pattern.Corpus, other.Corpus = "", "other"
+		case "root":
+			pattern.Root, other.Root = "", "other"
+		case "path":
+			pattern.Path, other.Path = "", "other"
+		case "language":
+			pattern.Language, other.Language = "", "other"
+		case "signature":
+			pattern.Signature, other.Signature = "", "other"
+		}
+		if !MatchVNames(pattern, target) || !MatchVNames(pattern, other) {
+			t.Errorf("MatchVNames with wildcard %s: got false, want true", field)
+		}
+		// With the field set, the other value no longer matches.
+		if MatchVNames(target, other) {
+			t.Errorf("MatchVNames(%v, %v): got true, want false", target, other)
+		}
+	}
+}
+
+func TestRemapCorpus(t *testing.T) {
+	rs := Rules{
+		{Begin: 1, VName: &spb.VName{Corpus: "upstream", Signature: "a"}},
+		{Begin: 2, VName: &spb.VName{Corpus: "other", Signature: "b"}},
+		{Begin: 3, VName: &spb.VName{Corpus: "local", Signature: "c"}},
+		{Begin: 4},
+	}
+	got := rs.RemapCorpus(map[string]string{
+		"upstream": "ours",
+		"other":    "ours/other",
+	})
+	want := Rules{
+		{Begin: 1, VName: &spb.VName{Corpus: "ours", Signature: "a"}},
+		{Begin: 2, VName: &spb.VName{Corpus: "ours/other", Signature: "b"}},
+		{Begin: 3, VName: &spb.VName{Corpus: "local", Signature: "c"}},
+		{Begin: 4},
+	}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("RemapCorpus: %v", err)
+	}
+	if c := rs[0].VName.Corpus; c != "upstream" {
+		t.Errorf("RemapCorpus modified its receiver: corpus is %q", c)
+	}
+}
+
+func TestInferLanguage(t *testing.T) {
+	rs := Rules{
+		{Begin: 1, VName: &spb.VName{Signature: "a"}},
+		{Begin: 2, VName: &spb.VName{Signature: "b", Language: "go"}},
+		{Begin: 3, VName: &spb.VName{Corpus: "c", Path: "p"}},
+		{Begin: 4},
+	}
+	got := rs.InferLanguage("java")
+	want := Rules{
+		{Begin: 1, VName: &spb.VName{Signature: "a", Language: "java"}},
+		{Begin: 2, VName: &spb.VName{Signature: "b", Language: "go"}},
+		{Begin: 3, VName: &spb.VName{Corpus: "c", Path: "p", Language: "java"}},
+		{Begin: 4},
+	}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("InferLanguage: %v", err)
+	}
+	if lang := rs[0].VName.Language; lang != "" {
+		t.Errorf("InferLanguage modified its receiver: language is %q", lang)
+	}
+}
+
+func TestStripPathPrefix(t *testing.T) {
+	tests := []struct {
+		prefix, path, want string
+	}{
+		{"/bazel-out/k8-fastbuild/bin", "/bazel-out/k8-fastbuild/bin/gen/foo.pb.go", "gen/foo.pb.go"},
+		{"/bazel-out/k8-fastbuild/bin/", "/bazel-out/k8-fastbuild/bin/gen/foo.pb.go", "gen/foo.pb.go"},
+		{"/bazel-out", "/bazel-out//gen/foo.go", "gen/foo.go"},
+		{"/bazel-out", "/bazel-out", ""},
+		{"/bazel-out", "/bazel-output/foo.go", "/bazel-output/foo.go"},
+		{"/bazel-out", "src/foo.go", "src/foo.go"},
+		{"", "/abs/foo.go", "/abs/foo.go"},
+	}
+	for _, test := range tests {
+		rs := Rules{{VName: &spb.VName{Corpus: "c", Path: test.path}}, {}}
+		got := rs.StripPathPrefix(test.prefix)
+		want := Rules{{VName: &spb.VName{Corpus: "c", Path: test.want}}, {}}
+		if err := testutil.DeepEqual(want, got); err != nil {
+			t.Errorf("StripPathPrefix(%q) of %q: %v", test.prefix, test.path, err)
+		}
+		if p := rs[0].VName.Path; p != test.path {
+			t.Errorf("StripPathPrefix(%q) modified its receiver: path is %q", test.prefix, p)
+		}
+	}
+}
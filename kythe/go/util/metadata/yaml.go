@@ -0,0 +1,53 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ParseYAML parses a single YAML metadata document from r and returns the
+// corresponding rules. The document has the same structure as the JSON
+// format accepted by Parse, for example:
+//
+//	type: kythe0
+//	meta:
+//	  # Comments are ignored.
+//	  - type: anchor_defines
+//	    begin: 179
+//	    end: 182
+//	    edge: "%/kythe/edge/generates"
+//	    vname: {corpus: gcorp, path: gpath, signature: gsig}
+//
+// The document is converted to JSON and decoded as by Parse, so the two
+// formats produce identical rules. Any error returned has concrete type
+// *ParseError.
+func ParseYAML(r io.Reader) (Rules, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, &ParseError{Index: -1, Err: fmt.Errorf("invalid file: %v", err)}
+	}
+	js, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, &ParseError{Index: -1, Err: fmt.Errorf("invalid YAML: %v", err)}
+	}
+	return ParseBytes(js)
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"kythe.io/kythe/go/test/testutil"
+)
+
+func TestParseYAML(t *testing.T) {
+	const yamlInput = `
+# Hand-written metadata for gen.go.
+type: kythe0
+meta:
+  - type: nop
+  - type: anchor_defines  # generated from the message
+    begin: 179
+    end: 182
+    edge: "%/kythe/edge/generates"
+    vname:
+      corpus: gcorp
+      path: gpath
+      signature: gsig
+  - type: ref
+    begin: 5
+    end: 10
+    edge: /kythe/edge/generates
+    vname: {signature: rsig}
+`
+	const jsonInput = `{"type":"kythe0","meta":[
+  {"type":"nop"},
+  {"type":"anchor_defines","begin":179,"end":182,"edge":"%/kythe/edge/generates",
+   "vname":{"corpus":"gcorp","path":"gpath","signature":"gsig"}},
+  {"type":"ref","begin":5,"end":10,"edge":"/kythe/edge/generates","vname":{"signature":"rsig"}}
+]}`
+	want, err := Parse(strings.NewReader(jsonInput))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	got, err := ParseYAML(strings.NewReader(yamlInput))
+	if err != nil {
+		t.Fatalf("ParseYAML failed: %v", err)
+	}
+	if err := testutil.DeepEqual(want, got); err != nil {
+		t.Errorf("ParseYAML: %v", err)
+	}
+
+	for _, bad := range []string{
+		"type: [kythe0",
+		"type: wrong",
+		"type: kythe0\nmeta:\n  - type: bogus\n",
+	} {
+		if rs, err := ParseYAML(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseYAML %q: got %v, wanted error", bad, rs)
+		} else if !errors.Is(err, ErrMalformed) {
+			t.Errorf("ParseYAML %q: got error %v, want %v", bad, err, ErrMalformed)
+		}
+	}
+}
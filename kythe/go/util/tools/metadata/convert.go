@@ -0,0 +1,194 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"kythe.io/kythe/go/util/cmdutil"
+	"kythe.io/kythe/go/util/metadata"
+
+	"github.com/google/subcommands"
+	"sigs.k8s.io/yaml"
+)
+
+// A format names an encoding of metadata files.
+type format string
+
+const (
+	jsonFormat  format = "json"
+	yamlFormat  format = "yaml"
+	protoFormat format = "pb"
+)
+
+type convertCmd struct {
+	cmdutil.Info
+
+	from, to string
+	force    bool
+}
+
+var convertInfo = cmdutil.NewInfo("convert", "convert a metadata file between formats",
+	`Usage: convert --from <format> --to <format> [--force] <input> <output>
+
+Read the metadata file at input in one format and write its rules to output in
+another. The formats are "json", "yaml", and "pb" (binary protobuf). If the
+conversion would lose information, such as rules of unknown type or fields not
+understood by the metadata library, it is refused unless --force is given.`)
+
+func (c *convertCmd) SetFlags(flag *flag.FlagSet) {
+	flag.StringVar(&c.from, "from", string(jsonFormat), `Format of the input file {"json", "yaml", "pb"}`)
+	flag.StringVar(&c.to, "to", string(jsonFormat), `Format of the output file {"json", "yaml", "pb"}`)
+	flag.BoolVar(&c.force, "force", false, "Whether to convert even if information will be lost")
+}
+
+func (c *convertCmd) Execute(ctx context.Context, flag *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if flag.NArg() != 2 {
+		return c.Fail("an input and an output file must be given")
+	}
+	in, out := flag.Arg(0), flag.Arg(1)
+	from, to := format(strings.ToLower(c.from)), format(strings.ToLower(c.to))
+	for _, f := range []format{from, to} {
+		if !f.valid() {
+			return c.Fail("unknown metadata format: %q", f)
+		}
+	}
+
+	data, err := ioutil.ReadFile(in)
+	if err != nil {
+		return c.Fail("reading input: %v", err)
+	}
+	bits, losses, err := convert(data, from, to, c.force)
+	if err != nil {
+		return c.Fail("%s: %v", in, err)
+	}
+	for _, loss := range losses {
+		fmt.Printf("WARNING: %s\n", loss)
+	}
+
+	if err := ioutil.WriteFile(out, bits, 0644); err != nil {
+		return c.Fail("writing output: %v", err)
+	}
+	return subcommands.ExitSuccess
+}
+
+// convert re-encodes the metadata file data from format from to format to. It
+// returns the output and a description of the information that the output does
+// not hold. If any information would be lost, convert fails unless force is
+// true.
+func convert(data []byte, from, to format, force bool) ([]byte, []string, error) {
+	rs, losses, err := from.parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	bits, err := to.marshal(rs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding rules: %v", err)
+	}
+
+	// Check that the output decodes to the same rules.
+	if back, _, err := to.parse(bits); err != nil {
+		losses = append(losses, fmt.Sprintf("output does not decode: %v", err))
+	} else if !sameRules(rs, back) {
+		losses = append(losses, fmt.Sprintf("rules differ after conversion to %s", to))
+	}
+	if len(losses) != 0 && !force {
+		return nil, losses, fmt.Errorf("conversion would lose information (use --force to convert anyway):\n  %s",
+			strings.Join(losses, "\n  "))
+	}
+	return bits, losses, nil
+}
+
+func (f format) valid() bool { return f == jsonFormat || f == yamlFormat || f == protoFormat }
+
+// parse decodes data in format f. In addition to the rules, it returns a
+// description of any information in data that the rules do not hold.
+func (f format) parse(data []byte) (metadata.Rules, []string, error) {
+	if f == protoFormat {
+		rs, err := metadata.ParseProto(data)
+		return rs, nil, err
+	}
+	if f == yamlFormat {
+		js, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid YAML: %v", err)
+		}
+		data = js
+	}
+	res, err := metadata.ParseVerbose(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	var losses []string
+	for _, w := range res.Warnings() {
+		losses = append(losses, w.String())
+	}
+	return res.Rules(), append(losses, unknownKeys(data)...), nil
+}
+
+// marshal encodes rs in format f.
+func (f format) marshal(rs metadata.Rules) ([]byte, error) {
+	switch f {
+	case protoFormat:
+		return rs.MarshalProto()
+	case yamlFormat:
+		js, err := rs.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		return yaml.JSONToYAML(js)
+	default:
+		bits, err := rs.MarshalIndent("", "  ")
+		return append(bits, '\n'), err
+	}
+}
+
+// unknownKeys describes the top-level fields of the JSON metadata file data
+// that are ignored by the parser.
+func unknownKeys(data []byte) []string {
+	var file map[string]json.RawMessage
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil // already reported by the parser
+	}
+	var keys []string
+	for key := range file {
+		switch key {
+		case "type", "meta", "offset_encoding", "offset_base":
+		default:
+			keys = append(keys, fmt.Sprintf("unknown top-level field %q dropped", key))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sameRules reports whether a and b encode identically.
+func sameRules(a, b metadata.Rules) bool {
+	x, err := a.MarshalCanonical()
+	if err != nil {
+		return false
+	}
+	y, err := b.MarshalCanonical()
+	return err == nil && bytes.Equal(x, y)
+}
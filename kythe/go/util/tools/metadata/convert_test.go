@@ -0,0 +1,98 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testMeta = `{"type":"kythe0","meta":[` +
+	`{"type":"nop","begin":1,"end":2},` +
+	`{"type":"anchor_defines","begin":3,"end":7,"edge":"%/kythe/edge/generates",` +
+	`"vname":{"corpus":"c","path":"foo.proto","language":"protobuf","signature":"pkg.Foo"}}]}`
+
+func TestConvertRoundTrip(t *testing.T) {
+	pb, losses, err := convert([]byte(testMeta), jsonFormat, protoFormat, false)
+	if err != nil || losses != nil {
+		t.Fatalf("convert json to pb: got (%v, %v), want no losses", losses, err)
+	}
+	yml, losses, err := convert(pb, protoFormat, yamlFormat, false)
+	if err != nil || losses != nil {
+		t.Fatalf("convert pb to yaml: got (%v, %v), want no losses", losses, err)
+	}
+	js, losses, err := convert(yml, yamlFormat, jsonFormat, false)
+	if err != nil || losses != nil {
+		t.Fatalf("convert yaml to json: got (%v, %v), want no losses", losses, err)
+	}
+
+	want, _, err := jsonFormat.parse([]byte(testMeta))
+	if err != nil {
+		t.Fatalf("Parsing input: %v", err)
+	}
+	got, _, err := jsonFormat.parse(js)
+	if err != nil {
+		t.Fatalf("Parsing output: %v", err)
+	}
+	if !sameRules(want, got) {
+		t.Errorf("Round trip changed the rules:\ngot:  %s\nwant: %s", js, testMeta)
+	}
+
+	// Converting the output again is stable.
+	if again, _, err := convert(js, jsonFormat, jsonFormat, false); err != nil {
+		t.Errorf("convert json to json failed: %v", err)
+	} else if !bytes.Equal(again, js) {
+		t.Errorf("convert json to json:\ngot:  %s\nwant: %s", again, js)
+	}
+}
+
+func TestConvertLossy(t *testing.T) {
+	const lossy = `{"type":"kythe0","comment":"dropped","meta":[` +
+		`{"type":"nop","begin":1,"end":2},{"type":"bogus","begin":3,"end":4}]}`
+	if out, losses, err := convert([]byte(lossy), jsonFormat, protoFormat, false); err == nil {
+		t.Errorf("convert without --force: got (%q, %v), want error", out, losses)
+	} else if !strings.Contains(err.Error(), "--force") {
+		t.Errorf("convert without --force: error %q does not mention --force", err)
+	} else if len(losses) != 2 {
+		t.Errorf("convert without --force: got losses %q, want 2", losses)
+	}
+
+	out, losses, err := convert([]byte(lossy), jsonFormat, protoFormat, true)
+	if err != nil {
+		t.Fatalf("convert with --force failed: %v", err)
+	} else if len(losses) != 2 {
+		t.Errorf("convert with --force: got losses %q, want 2", losses)
+	}
+	rs, _, err := protoFormat.parse(out)
+	if err != nil {
+		t.Fatalf("Parsing forced output: %v", err)
+	} else if len(rs) != 1 {
+		t.Errorf("Forced output: got %d rules, want 1", len(rs))
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	got := unknownKeys([]byte(`{"type":"kythe0","meta":[],"offset_encoding":"","zzz":1,"aaa":2}`))
+	want := []string{`unknown top-level field "aaa" dropped`, `unknown top-level field "zzz" dropped`}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unknownKeys: got %q, want %q", got, want)
+	}
+	if got := unknownKeys([]byte("not json")); got != nil {
+		t.Errorf("unknownKeys of invalid input: got %q, want nil", got)
+	}
+}
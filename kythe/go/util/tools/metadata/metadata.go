@@ -0,0 +1,53 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Binary metadata is a utility for handling Kythe metadata files.
+//
+// Examples:
+//   # Check that metadata files are well-formed and use known edge kinds.
+//   metadata validate foo.pb.go.meta bar.pb.go.meta
+//
+//   # As above, but report every problem in each file rather than the first.
+//   metadata validate -strict foo.pb.go.meta
+//
+//   # Print the rules of a metadata file, with a summary.
+//   metadata show foo.pb.go.meta
+//
+//   # Convert a JSON metadata file to YAML.
+//   metadata convert -from json -to yaml foo.pb.go.meta foo.meta.yaml
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/google/subcommands"
+)
+
+func main() {
+	flag.Parse()
+
+	subcommands.Register(&convertCmd{Info: convertInfo}, "")
+	subcommands.Register(&showCmd{Info: showInfo}, "")
+	subcommands.Register(&validateCmd{Info: validateInfo}, "")
+
+	subcommands.Register(subcommands.FlagsCommand(), "info")
+	subcommands.Register(subcommands.HelpCommand(), "info")
+
+	ctx := context.Background()
+	os.Exit(int(subcommands.Execute(ctx)))
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+
+	"kythe.io/kythe/go/util/cmdutil"
+	"kythe.io/kythe/go/util/metadata"
+
+	"github.com/google/subcommands"
+)
+
+type showCmd struct {
+	cmdutil.Info
+
+	json bool
+}
+
+var showInfo = cmdutil.NewInfo("show", "print the rules of a metadata file",
+	`Usage: show [--json] <path>
+
+Print each rule of the metadata file, followed by a summary of the number of
+rules of each edge kind and the extent of the file covered by their spans.`)
+
+func (c *showCmd) SetFlags(flag *flag.FlagSet) {
+	flag.BoolVar(&c.json, "json", false, "Print the canonical JSON encoding of the rules instead")
+}
+
+func (c *showCmd) Execute(ctx context.Context, flag *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if flag.NArg() != 1 {
+		return c.Fail("exactly one metadata file must be given")
+	}
+	path := flag.Arg(0)
+	rs, err := metadata.ParseFile(path)
+	if err != nil {
+		return c.Fail("%v", err)
+	}
+	if c.json {
+		bits, err := rs.Canonicalize().MarshalCanonical()
+		if err != nil {
+			return c.Fail("encoding rules: %v", err)
+		}
+		fmt.Printf("%s\n", bits)
+		return subcommands.ExitSuccess
+	}
+	for i, r := range rs {
+		fmt.Printf("%4d  %s\n", i, r)
+	}
+	fmt.Println(rs)
+	if covered, begin, end := coverage(rs); covered > 0 {
+		fmt.Printf("%d bytes covered by spans within [%d, %d)\n", covered, begin, end)
+	}
+	return subcommands.ExitSuccess
+}
+
+// coverage returns the number of offsets spanned by at least one rule of rs,
+// and the least and greatest offsets spanned. Whole-file rules, and rules
+// whose spans are empty or invalid, are not counted.
+func coverage(rs metadata.Rules) (covered, begin, end int) {
+	var spans []metadata.Span
+	for _, r := range rs {
+		if !r.WholeFile && r.Begin >= 0 && r.Begin < r.End {
+			spans = append(spans, metadata.Span{Begin: r.Begin, End: r.End})
+		}
+	}
+	if len(spans) == 0 {
+		return 0, 0, 0
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Begin < spans[j].Begin })
+	begin, end = spans[0].Begin, spans[0].End
+	lo, hi := begin, end // the current run of overlapping spans
+	for _, s := range spans[1:] {
+		if s.Begin > hi {
+			covered += hi - lo
+			lo, hi = s.Begin, s.End
+		} else if s.End > hi {
+			hi = s.End
+		}
+		if s.End > end {
+			end = s.End
+		}
+	}
+	covered += hi - lo
+	return covered, begin, end
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2020 The Kythe Authors. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"kythe.io/kythe/go/util/cmdutil"
+	"kythe.io/kythe/go/util/metadata"
+
+	"github.com/google/subcommands"
+)
+
+type validateCmd struct {
+	cmdutil.Info
+
+	strict bool
+}
+
+var validateInfo = cmdutil.NewInfo("validate", "check metadata files for problems",
+	`Usage: validate [--strict] <path>...
+
+Parse each metadata file and check its rules, printing whether each file
+passed and the problems found with those that did not. Without --strict, only
+the first problem in each file is reported; with it, every rule is checked and
+all the problems found are reported together. Exits with a non-zero status if
+any file fails.`)
+
+func (c *validateCmd) SetFlags(flag *flag.FlagSet) {
+	flag.BoolVar(&c.strict, "strict", false, "Check every rule and report all the problems found, rather than only the first")
+}
+
+func (c *validateCmd) Execute(ctx context.Context, flag *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if flag.NArg() == 0 {
+		return c.Fail("no metadata files given")
+	}
+	status := subcommands.ExitSuccess
+	for _, path := range flag.Args() {
+		if err := c.validate(path); err != nil {
+			fmt.Printf("FAIL %s\n  %v\n", path, err)
+			status = subcommands.ExitFailure
+		} else {
+			fmt.Printf("PASS %s\n", path)
+		}
+	}
+	return status
+}
+
+// validate reports the problems found with the metadata file at path: the
+// first problem, or in strict mode every problem found while parsing.
+func (c *validateCmd) validate(path string) error {
+	rs, err := metadata.ParseFileWithOptions(path, &metadata.ParseOptions{Strict: c.strict})
+	if err != nil {
+		return err
+	} else if err := rs.Validate(); err != nil {
+		return err
+	}
+	return rs.ValidateEdges()
+}
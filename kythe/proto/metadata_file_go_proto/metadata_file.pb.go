@@ -0,0 +1,701 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.22.0
+// 	protoc        v30.10.0
+// source: kythe/proto/metadata_file.proto
+
+package metadata_file_go_proto
+
+import (
+	proto "github.com/golang/protobuf/proto"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	storage_go_proto "kythe.io/kythe/proto/storage_go_proto"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// This is a compile-time assertion that a sufficiently up-to-date version
+// of the legacy proto package is being used.
+const _ = proto.ProtoPackageIsVersion4
+
+type MetadataFile struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type           string               `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Meta           []*MetadataFile_Rule `protobuf:"bytes,2,rep,name=meta,proto3" json:"meta,omitempty"`
+	OffsetEncoding string               `protobuf:"bytes,3,opt,name=offset_encoding,json=offsetEncoding,proto3" json:"offset_encoding,omitempty"`
+}
+
+func (x *MetadataFile) Reset() {
+	*x = MetadataFile{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kythe_proto_metadata_file_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MetadataFile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetadataFile) ProtoMessage() {}
+
+func (x *MetadataFile) ProtoReflect() protoreflect.Message {
+	mi := &file_kythe_proto_metadata_file_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetadataFile.ProtoReflect.Descriptor instead.
+func (*MetadataFile) Descriptor() ([]byte, []int) {
+	return file_kythe_proto_metadata_file_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *MetadataFile) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *MetadataFile) GetMeta() []*MetadataFile_Rule {
+	if x != nil {
+		return x.Meta
+	}
+	return nil
+}
+
+func (x *MetadataFile) GetOffsetEncoding() string {
+	if x != nil {
+		return x.OffsetEncoding
+	}
+	return ""
+}
+
+type MetadataFile_Rule struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type        string                  `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Begin       int64                   `protobuf:"varint,2,opt,name=begin,proto3" json:"begin,omitempty"`
+	End         int64                   `protobuf:"varint,3,opt,name=end,proto3" json:"end,omitempty"`
+	Edge        string                  `protobuf:"bytes,4,opt,name=edge,proto3" json:"edge,omitempty"`
+	Vname       *storage_go_proto.VName `protobuf:"bytes,5,opt,name=vname,proto3" json:"vname,omitempty"`
+	Target      *MetadataFile_Span      `protobuf:"bytes,6,opt,name=target,proto3" json:"target,omitempty"`
+	SourceVname *storage_go_proto.VName `protobuf:"bytes,7,opt,name=source_vname,json=sourceVname,proto3" json:"source_vname,omitempty"`
+	Source      *MetadataFile_Span      `protobuf:"bytes,8,opt,name=source,proto3" json:"source,omitempty"`
+	Semantic    string                  `protobuf:"bytes,9,opt,name=semantic,proto3" json:"semantic,omitempty"`
+	Ordinal     *MetadataFile_Ordinal   `protobuf:"bytes,10,opt,name=ordinal,proto3" json:"ordinal,omitempty"`
+	Score       *MetadataFile_Score     `protobuf:"bytes,11,opt,name=score,proto3" json:"score,omitempty"`
+	Language    string                  `protobuf:"bytes,12,opt,name=language,proto3" json:"language,omitempty"`
+	Position    *MetadataFile_LineSpan  `protobuf:"bytes,13,opt,name=position,proto3" json:"position,omitempty"`
+	Extra       map[string][]byte       `protobuf:"bytes,14,rep,name=extra,proto3" json:"extra,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	WholeFile   bool                    `protobuf:"varint,15,opt,name=whole_file,json=wholeFile,proto3" json:"whole_file,omitempty"`
+}
+
+func (x *MetadataFile_Rule) Reset() {
+	*x = MetadataFile_Rule{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kythe_proto_metadata_file_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MetadataFile_Rule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetadataFile_Rule) ProtoMessage() {}
+
+func (x *MetadataFile_Rule) ProtoReflect() protoreflect.Message {
+	mi := &file_kythe_proto_metadata_file_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetadataFile_Rule.ProtoReflect.Descriptor instead.
+func (*MetadataFile_Rule) Descriptor() ([]byte, []int) {
+	return file_kythe_proto_metadata_file_proto_rawDescGZIP(), []int{0, 0}
+}
+
+func (x *MetadataFile_Rule) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *MetadataFile_Rule) GetBegin() int64 {
+	if x != nil {
+		return x.Begin
+	}
+	return 0
+}
+
+func (x *MetadataFile_Rule) GetEnd() int64 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+func (x *MetadataFile_Rule) GetEdge() string {
+	if x != nil {
+		return x.Edge
+	}
+	return ""
+}
+
+func (x *MetadataFile_Rule) GetVname() *storage_go_proto.VName {
+	if x != nil {
+		return x.Vname
+	}
+	return nil
+}
+
+func (x *MetadataFile_Rule) GetTarget() *MetadataFile_Span {
+	if x != nil {
+		return x.Target
+	}
+	return nil
+}
+
+func (x *MetadataFile_Rule) GetSourceVname() *storage_go_proto.VName {
+	if x != nil {
+		return x.SourceVname
+	}
+	return nil
+}
+
+func (x *MetadataFile_Rule) GetSource() *MetadataFile_Span {
+	if x != nil {
+		return x.Source
+	}
+	return nil
+}
+
+func (x *MetadataFile_Rule) GetSemantic() string {
+	if x != nil {
+		return x.Semantic
+	}
+	return ""
+}
+
+func (x *MetadataFile_Rule) GetOrdinal() *MetadataFile_Ordinal {
+	if x != nil {
+		return x.Ordinal
+	}
+	return nil
+}
+
+func (x *MetadataFile_Rule) GetScore() *MetadataFile_Score {
+	if x != nil {
+		return x.Score
+	}
+	return nil
+}
+
+func (x *MetadataFile_Rule) GetLanguage() string {
+	if x != nil {
+		return x.Language
+	}
+	return ""
+}
+
+func (x *MetadataFile_Rule) GetPosition() *MetadataFile_LineSpan {
+	if x != nil {
+		return x.Position
+	}
+	return nil
+}
+
+func (x *MetadataFile_Rule) GetExtra() map[string][]byte {
+	if x != nil {
+		return x.Extra
+	}
+	return nil
+}
+
+func (x *MetadataFile_Rule) GetWholeFile() bool {
+	if x != nil {
+		return x.WholeFile
+	}
+	return false
+}
+
+type MetadataFile_Span struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Begin int64 `protobuf:"varint,1,opt,name=begin,proto3" json:"begin,omitempty"`
+	End   int64 `protobuf:"varint,2,opt,name=end,proto3" json:"end,omitempty"`
+}
+
+func (x *MetadataFile_Span) Reset() {
+	*x = MetadataFile_Span{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kythe_proto_metadata_file_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MetadataFile_Span) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetadataFile_Span) ProtoMessage() {}
+
+func (x *MetadataFile_Span) ProtoReflect() protoreflect.Message {
+	mi := &file_kythe_proto_metadata_file_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetadataFile_Span.ProtoReflect.Descriptor instead.
+func (*MetadataFile_Span) Descriptor() ([]byte, []int) {
+	return file_kythe_proto_metadata_file_proto_rawDescGZIP(), []int{0, 1}
+}
+
+func (x *MetadataFile_Span) GetBegin() int64 {
+	if x != nil {
+		return x.Begin
+	}
+	return 0
+}
+
+func (x *MetadataFile_Span) GetEnd() int64 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+type MetadataFile_Ordinal struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Value int64 `protobuf:"varint,1,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (x *MetadataFile_Ordinal) Reset() {
+	*x = MetadataFile_Ordinal{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kythe_proto_metadata_file_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MetadataFile_Ordinal) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetadataFile_Ordinal) ProtoMessage() {}
+
+func (x *MetadataFile_Ordinal) ProtoReflect() protoreflect.Message {
+	mi := &file_kythe_proto_metadata_file_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetadataFile_Ordinal.ProtoReflect.Descriptor instead.
+func (*MetadataFile_Ordinal) Descriptor() ([]byte, []int) {
+	return file_kythe_proto_metadata_file_proto_rawDescGZIP(), []int{0, 2}
+}
+
+func (x *MetadataFile_Ordinal) GetValue() int64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+type MetadataFile_Score struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Value float64 `protobuf:"fixed64,1,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (x *MetadataFile_Score) Reset() {
+	*x = MetadataFile_Score{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kythe_proto_metadata_file_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MetadataFile_Score) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetadataFile_Score) ProtoMessage() {}
+
+func (x *MetadataFile_Score) ProtoReflect() protoreflect.Message {
+	mi := &file_kythe_proto_metadata_file_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetadataFile_Score.ProtoReflect.Descriptor instead.
+func (*MetadataFile_Score) Descriptor() ([]byte, []int) {
+	return file_kythe_proto_metadata_file_proto_rawDescGZIP(), []int{0, 3}
+}
+
+func (x *MetadataFile_Score) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+type MetadataFile_LineSpan struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	BeginLine   int64  `protobuf:"varint,1,opt,name=begin_line,json=beginLine,proto3" json:"begin_line,omitempty"`
+	BeginCol    int64  `protobuf:"varint,2,opt,name=begin_col,json=beginCol,proto3" json:"begin_col,omitempty"`
+	EndLine     int64  `protobuf:"varint,3,opt,name=end_line,json=endLine,proto3" json:"end_line,omitempty"`
+	EndCol      int64  `protobuf:"varint,4,opt,name=end_col,json=endCol,proto3" json:"end_col,omitempty"`
+	ColumnUnits string `protobuf:"bytes,5,opt,name=column_units,json=columnUnits,proto3" json:"column_units,omitempty"`
+}
+
+func (x *MetadataFile_LineSpan) Reset() {
+	*x = MetadataFile_LineSpan{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kythe_proto_metadata_file_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MetadataFile_LineSpan) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetadataFile_LineSpan) ProtoMessage() {}
+
+func (x *MetadataFile_LineSpan) ProtoReflect() protoreflect.Message {
+	mi := &file_kythe_proto_metadata_file_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetadataFile_LineSpan.ProtoReflect.Descriptor instead.
+func (*MetadataFile_LineSpan) Descriptor() ([]byte, []int) {
+	return file_kythe_proto_metadata_file_proto_rawDescGZIP(), []int{0, 4}
+}
+
+func (x *MetadataFile_LineSpan) GetBeginLine() int64 {
+	if x != nil {
+		return x.BeginLine
+	}
+	return 0
+}
+
+func (x *MetadataFile_LineSpan) GetBeginCol() int64 {
+	if x != nil {
+		return x.BeginCol
+	}
+	return 0
+}
+
+func (x *MetadataFile_LineSpan) GetEndLine() int64 {
+	if x != nil {
+		return x.EndLine
+	}
+	return 0
+}
+
+func (x *MetadataFile_LineSpan) GetEndCol() int64 {
+	if x != nil {
+		return x.EndCol
+	}
+	return 0
+}
+
+func (x *MetadataFile_LineSpan) GetColumnUnits() string {
+	if x != nil {
+		return x.ColumnUnits
+	}
+	return ""
+}
+
+var File_kythe_proto_metadata_file_proto protoreflect.FileDescriptor
+
+var file_kythe_proto_metadata_file_proto_rawDesc = []byte{
+	0x0a, 0x1f, 0x6b, 0x79, 0x74, 0x68, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x6d, 0x65,
+	0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x5f, 0x66, 0x69, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x12, 0x14, 0x6b, 0x79, 0x74, 0x68, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x6d,
+	0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x1a, 0x19, 0x6b, 0x79, 0x74, 0x68, 0x65, 0x2f, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x22, 0xfe, 0x08, 0x0a, 0x0c, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x46,
+	0x69, 0x6c, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x3b, 0x0a, 0x04, 0x6d, 0x65, 0x74, 0x61, 0x18,
+	0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x27, 0x2e, 0x6b, 0x79, 0x74, 0x68, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x2e, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x2e, 0x4d, 0x65, 0x74,
+	0x61, 0x64, 0x61, 0x74, 0x61, 0x46, 0x69, 0x6c, 0x65, 0x2e, 0x52, 0x75, 0x6c, 0x65, 0x52, 0x04,
+	0x6d, 0x65, 0x74, 0x61, 0x12, 0x27, 0x0a, 0x0f, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x5f, 0x65,
+	0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x6f,
+	0x66, 0x66, 0x73, 0x65, 0x74, 0x45, 0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67, 0x1a, 0xe3, 0x05,
+	0x0a, 0x04, 0x52, 0x75, 0x6c, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x62, 0x65,
+	0x67, 0x69, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x62, 0x65, 0x67, 0x69, 0x6e,
+	0x12, 0x10, 0x0a, 0x03, 0x65, 0x6e, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03, 0x65,
+	0x6e, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x65, 0x64, 0x67, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x65, 0x64, 0x67, 0x65, 0x12, 0x28, 0x0a, 0x05, 0x76, 0x6e, 0x61, 0x6d, 0x65, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x6b, 0x79, 0x74, 0x68, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x2e, 0x56, 0x4e, 0x61, 0x6d, 0x65, 0x52, 0x05, 0x76, 0x6e, 0x61, 0x6d, 0x65,
+	0x12, 0x3f, 0x0a, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x27, 0x2e, 0x6b, 0x79, 0x74, 0x68, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x6d,
+	0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x2e, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61,
+	0x46, 0x69, 0x6c, 0x65, 0x2e, 0x53, 0x70, 0x61, 0x6e, 0x52, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65,
+	0x74, 0x12, 0x35, 0x0a, 0x0c, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x5f, 0x76, 0x6e, 0x61, 0x6d,
+	0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x6b, 0x79, 0x74, 0x68, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x56, 0x4e, 0x61, 0x6d, 0x65, 0x52, 0x0b, 0x73, 0x6f, 0x75,
+	0x72, 0x63, 0x65, 0x56, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x3f, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72,
+	0x63, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x27, 0x2e, 0x6b, 0x79, 0x74, 0x68, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x2e,
+	0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x46, 0x69, 0x6c, 0x65, 0x2e, 0x53, 0x70, 0x61,
+	0x6e, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x6d,
+	0x61, 0x6e, 0x74, 0x69, 0x63, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x65, 0x6d,
+	0x61, 0x6e, 0x74, 0x69, 0x63, 0x12, 0x44, 0x0a, 0x07, 0x6f, 0x72, 0x64, 0x69, 0x6e, 0x61, 0x6c,
+	0x18, 0x0a, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x2a, 0x2e, 0x6b, 0x79, 0x74, 0x68, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x2e, 0x4d, 0x65,
+	0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x46, 0x69, 0x6c, 0x65, 0x2e, 0x4f, 0x72, 0x64, 0x69, 0x6e,
+	0x61, 0x6c, 0x52, 0x07, 0x6f, 0x72, 0x64, 0x69, 0x6e, 0x61, 0x6c, 0x12, 0x3e, 0x0a, 0x05, 0x73,
+	0x63, 0x6f, 0x72, 0x65, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x28, 0x2e, 0x6b, 0x79, 0x74,
+	0x68, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74,
+	0x61, 0x2e, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x46, 0x69, 0x6c, 0x65, 0x2e, 0x53,
+	0x63, 0x6f, 0x72, 0x65, 0x52, 0x05, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x6c,
+	0x61, 0x6e, 0x67, 0x75, 0x61, 0x67, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c,
+	0x61, 0x6e, 0x67, 0x75, 0x61, 0x67, 0x65, 0x12, 0x47, 0x0a, 0x08, 0x70, 0x6f, 0x73, 0x69, 0x74,
+	0x69, 0x6f, 0x6e, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x2b, 0x2e, 0x6b, 0x79, 0x74, 0x68,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61,
+	0x2e, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x46, 0x69, 0x6c, 0x65, 0x2e, 0x4c, 0x69,
+	0x6e, 0x65, 0x53, 0x70, 0x61, 0x6e, 0x52, 0x08, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x6f, 0x6e,
+	0x12, 0x48, 0x0a, 0x05, 0x65, 0x78, 0x74, 0x72, 0x61, 0x18, 0x0e, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x32, 0x2e, 0x6b, 0x79, 0x74, 0x68, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x6d, 0x65,
+	0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x2e, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x46,
+	0x69, 0x6c, 0x65, 0x2e, 0x52, 0x75, 0x6c, 0x65, 0x2e, 0x45, 0x78, 0x74, 0x72, 0x61, 0x45, 0x6e,
+	0x74, 0x72, 0x79, 0x52, 0x05, 0x65, 0x78, 0x74, 0x72, 0x61, 0x12, 0x1d, 0x0a, 0x0a, 0x77, 0x68,
+	0x6f, 0x6c, 0x65, 0x5f, 0x66, 0x69, 0x6c, 0x65, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x08, 0x52, 0x09,
+	0x77, 0x68, 0x6f, 0x6c, 0x65, 0x46, 0x69, 0x6c, 0x65, 0x1a, 0x38, 0x0a, 0x0a, 0x45, 0x78, 0x74,
+	0x72, 0x61, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a,
+	0x02, 0x38, 0x01, 0x1a, 0x2e, 0x0a, 0x04, 0x53, 0x70, 0x61, 0x6e, 0x12, 0x14, 0x0a, 0x05, 0x62,
+	0x65, 0x67, 0x69, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x62, 0x65, 0x67, 0x69,
+	0x6e, 0x12, 0x10, 0x0a, 0x03, 0x65, 0x6e, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03,
+	0x65, 0x6e, 0x64, 0x1a, 0x1f, 0x0a, 0x07, 0x4f, 0x72, 0x64, 0x69, 0x6e, 0x61, 0x6c, 0x12, 0x14,
+	0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x1a, 0x1d, 0x0a, 0x05, 0x53, 0x63, 0x6f, 0x72, 0x65, 0x12, 0x14, 0x0a,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x1a, 0x9d, 0x01, 0x0a, 0x08, 0x4c, 0x69, 0x6e, 0x65, 0x53, 0x70, 0x61, 0x6e,
+	0x12, 0x1d, 0x0a, 0x0a, 0x62, 0x65, 0x67, 0x69, 0x6e, 0x5f, 0x6c, 0x69, 0x6e, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x62, 0x65, 0x67, 0x69, 0x6e, 0x4c, 0x69, 0x6e, 0x65, 0x12,
+	0x1b, 0x0a, 0x09, 0x62, 0x65, 0x67, 0x69, 0x6e, 0x5f, 0x63, 0x6f, 0x6c, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x08, 0x62, 0x65, 0x67, 0x69, 0x6e, 0x43, 0x6f, 0x6c, 0x12, 0x19, 0x0a, 0x08,
+	0x65, 0x6e, 0x64, 0x5f, 0x6c, 0x69, 0x6e, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07,
+	0x65, 0x6e, 0x64, 0x4c, 0x69, 0x6e, 0x65, 0x12, 0x17, 0x0a, 0x07, 0x65, 0x6e, 0x64, 0x5f, 0x63,
+	0x6f, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x65, 0x6e, 0x64, 0x43, 0x6f, 0x6c,
+	0x12, 0x21, 0x0a, 0x0c, 0x63, 0x6f, 0x6c, 0x75, 0x6d, 0x6e, 0x5f, 0x75, 0x6e, 0x69, 0x74, 0x73,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x63, 0x6f, 0x6c, 0x75, 0x6d, 0x6e, 0x55, 0x6e,
+	0x69, 0x74, 0x73, 0x42, 0x18, 0x5a, 0x16, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x5f,
+	0x66, 0x69, 0x6c, 0x65, 0x5f, 0x67, 0x6f, 0x5f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_kythe_proto_metadata_file_proto_rawDescOnce sync.Once
+	file_kythe_proto_metadata_file_proto_rawDescData = file_kythe_proto_metadata_file_proto_rawDesc
+)
+
+func file_kythe_proto_metadata_file_proto_rawDescGZIP() []byte {
+	file_kythe_proto_metadata_file_proto_rawDescOnce.Do(func() {
+		file_kythe_proto_metadata_file_proto_rawDescData = protoimpl.X.CompressGZIP(file_kythe_proto_metadata_file_proto_rawDescData)
+	})
+	return file_kythe_proto_metadata_file_proto_rawDescData
+}
+
+var file_kythe_proto_metadata_file_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_kythe_proto_metadata_file_proto_goTypes = []interface{}{
+	(*MetadataFile)(nil),           // 0: kythe.proto.metadata.MetadataFile
+	(*MetadataFile_Rule)(nil),      // 1: kythe.proto.metadata.MetadataFile.Rule
+	(*MetadataFile_Span)(nil),      // 2: kythe.proto.metadata.MetadataFile.Span
+	(*MetadataFile_Ordinal)(nil),   // 3: kythe.proto.metadata.MetadataFile.Ordinal
+	(*MetadataFile_Score)(nil),     // 4: kythe.proto.metadata.MetadataFile.Score
+	(*MetadataFile_LineSpan)(nil),  // 5: kythe.proto.metadata.MetadataFile.LineSpan
+	nil,                            // 6: kythe.proto.metadata.MetadataFile.Rule.ExtraEntry
+	(*storage_go_proto.VName)(nil), // 7: kythe.proto.VName
+}
+var file_kythe_proto_metadata_file_proto_depIdxs = []int32{
+	1, // 0: kythe.proto.metadata.MetadataFile.meta:type_name -> kythe.proto.metadata.MetadataFile.Rule
+	7, // 1: kythe.proto.metadata.MetadataFile.Rule.vname:type_name -> kythe.proto.VName
+	2, // 2: kythe.proto.metadata.MetadataFile.Rule.target:type_name -> kythe.proto.metadata.MetadataFile.Span
+	7, // 3: kythe.proto.metadata.MetadataFile.Rule.source_vname:type_name -> kythe.proto.VName
+	2, // 4: kythe.proto.metadata.MetadataFile.Rule.source:type_name -> kythe.proto.metadata.MetadataFile.Span
+	3, // 5: kythe.proto.metadata.MetadataFile.Rule.ordinal:type_name -> kythe.proto.metadata.MetadataFile.Ordinal
+	4, // 6: kythe.proto.metadata.MetadataFile.Rule.score:type_name -> kythe.proto.metadata.MetadataFile.Score
+	5, // 7: kythe.proto.metadata.MetadataFile.Rule.position:type_name -> kythe.proto.metadata.MetadataFile.LineSpan
+	6, // 8: kythe.proto.metadata.MetadataFile.Rule.extra:type_name -> kythe.proto.metadata.MetadataFile.Rule.ExtraEntry
+	9, // [9:9] is the sub-list for method output_type
+	9, // [9:9] is the sub-list for method input_type
+	9, // [9:9] is the sub-list for extension type_name
+	9, // [9:9] is the sub-list for extension extendee
+	0, // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_kythe_proto_metadata_file_proto_init() }
+func file_kythe_proto_metadata_file_proto_init() {
+	if File_kythe_proto_metadata_file_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_kythe_proto_metadata_file_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MetadataFile); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kythe_proto_metadata_file_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MetadataFile_Rule); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kythe_proto_metadata_file_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MetadataFile_Span); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kythe_proto_metadata_file_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MetadataFile_Ordinal); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kythe_proto_metadata_file_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MetadataFile_Score); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kythe_proto_metadata_file_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MetadataFile_LineSpan); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_kythe_proto_metadata_file_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_kythe_proto_metadata_file_proto_goTypes,
+		DependencyIndexes: file_kythe_proto_metadata_file_proto_depIdxs,
+		MessageInfos:      file_kythe_proto_metadata_file_proto_msgTypes,
+	}.Build()
+	File_kythe_proto_metadata_file_proto = out.File
+	file_kythe_proto_metadata_file_proto_rawDesc = nil
+	file_kythe_proto_metadata_file_proto_goTypes = nil
+	file_kythe_proto_metadata_file_proto_depIdxs = nil
+}